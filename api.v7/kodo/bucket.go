@@ -56,11 +56,12 @@ func (p *Client) BucketWithSafe(name string) (Bucket, error) {
 }
 
 type Entry struct {
-	Hash     string `json:"hash"`
-	Fsize    int64  `json:"fsize"`
-	PutTime  int64  `json:"putTime"`
-	MimeType string `json:"mimeType"`
-	EndUser  string `json:"endUser"`
+	Hash     string   `json:"hash"`
+	Fsize    int64    `json:"fsize"`
+	PutTime  int64    `json:"putTime"`
+	MimeType string   `json:"mimeType"`
+	EndUser  string   `json:"endUser"`
+	Type     FileType `json:"type"`
 }
 
 // 取文件属性。
@@ -279,6 +280,7 @@ type FileType uint32
 const (
 	TypeNormal = iota
 	TypeLine
+	TypeArchive
 )
 
 func URIChangeType(bucket, key string, Type FileType) string {
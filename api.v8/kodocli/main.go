@@ -5,6 +5,9 @@ import (
 	"time"
 
 	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/api.v7/conf"
+	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/x/hostpool.v1"
+	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/x/metrics.v1"
+	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/x/retry.v1"
 	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/x/rpc.v7"
 	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/x/url.v7"
 )
@@ -42,6 +45,22 @@ type UploadConfig struct {
 	UploadPartSize int64
 	Concurrency    int
 	UseBuffer      bool
+	// 可选，上传进度通知，对所有上传方式生效，PutExtra.OnProgress 优先。fsize 未知时为 -1。
+	OnProgress func(fsize, uploaded int64)
+	// 可选，表单上传、上传分片、列举分片、合并分片和删除分片的重试策略。
+	// 为空时表单上传、上传分片、列举分片和合并分片最多尝试 5 次，删除分片最多尝试 10 次，每次间隔 3 秒。
+	// MaxAttempts 为 0 时各操作仍然使用上面的默认次数。
+	Retry *retry.Policy
+	// 可选，选择上传节点的策略，为空时每个 Uploader 使用自己的轮询策略。
+	// 多个 Uploader 共享同一个 HostSelector 时，节点的耗时和并发数统计也是共享的。
+	HostSelector hostpool.Selector
+	// 可选，记录上传节点健康状态的 Pool，为空时每个 Uploader 使用 NewHostPool 创建自己的 Pool。
+	// 和 syncdata 等上层共享同一个 Pool 时，任何一层发现的故障节点对另一层也生效。
+	HostPool *hostpool.Pool
+	// 可选，上传请求的超时时间，为 0 时为 10 分钟。
+	Timeout time.Duration
+	// 可选，接收监控数据的 Collector，为空时上报到 metrics.Default()。
+	Metrics metrics.Collector
 }
 
 type Uploader struct {
@@ -50,6 +69,11 @@ type Uploader struct {
 	UploadPartSize int64
 	Concurrency    int
 	UseBuffer      bool
+	OnProgress     func(fsize, uploaded int64)
+	Retry          *retry.Policy
+	HostSelector   hostpool.Selector
+	HostPool       *hostpool.Pool
+	Metrics        metrics.Collector
 }
 
 func NewUploader(zone int, cfg *UploadConfig) (p Uploader) {
@@ -78,8 +102,23 @@ func NewUploader(zone int, cfg *UploadConfig) (p Uploader) {
 	}
 
 	p.UseBuffer = uc.UseBuffer
+	p.OnProgress = uc.OnProgress
+	p.Retry = uc.Retry
+	p.HostSelector = uc.HostSelector
+	if p.HostSelector == nil {
+		p.HostSelector = hostpool.NewRoundRobin(MaxFindHostsPrecent)
+	}
+	p.HostPool = uc.HostPool
+	p.Metrics = uc.Metrics
+	if p.HostPool == nil {
+		p.HostPool = NewHostPool()
+	}
 	p.UpHosts = uc.UpHosts
-	p.Conn.Client = &http.Client{Transport: uc.Transport, Timeout: 10 * time.Minute}
+	timeout := uc.Timeout
+	if timeout == 0 {
+		timeout = 10 * time.Minute
+	}
+	p.Conn.Client = &http.Client{Transport: uc.Transport, Timeout: timeout}
 
 	p.shuffleUpHosts()
 	return
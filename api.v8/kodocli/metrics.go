@@ -0,0 +1,34 @@
+package kodocli
+
+import (
+	"time"
+
+	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/x/hostpool.v1"
+	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/x/httputil.v1"
+	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/x/metrics.v1"
+)
+
+// startRequest 通知节点选择策略即将向 host 发出请求，返回请求的开始时间
+func (p Uploader) startRequest(host string) time.Time {
+	p.hostSelector().Start(host)
+	return time.Now()
+}
+
+// observeRequest 把请求的结果反馈给节点选择策略并上报监控数据，重试时同时记录重试次数
+func (p Uploader) observeRequest(op, host string, attempt int, start time.Time, err error) {
+	elapsed := time.Since(start)
+	p.hostSelector().Done(host, elapsed, hostpool.HostFailed(err))
+	m := p.metrics()
+	m.ObserveRequest(op, host, httputil.DetectCode(err), elapsed)
+	if attempt > 0 {
+		m.IncRetry(op)
+	}
+}
+
+// metrics 返回配置的 Collector，没有配置时返回 metrics.Default()
+func (p Uploader) metrics() metrics.Collector {
+	if p.Metrics != nil {
+		return p.Metrics
+	}
+	return metrics.Default()
+}
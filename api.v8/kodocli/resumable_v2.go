@@ -9,7 +9,6 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
-	"math/rand"
 	"sort"
 	"strings"
 	"sync"
@@ -18,6 +17,8 @@ import (
 	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/api.v8/kodo"
 	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/api.v8/limit"
 	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/x/httputil.v1"
+	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/x/metrics.v1"
+	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/x/retry.v1"
 	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/x/xlog.v8"
 )
 
@@ -25,6 +26,8 @@ const minUploadPartSize = 1 << 22
 const uploadPartRetryTimes = 5
 const deletePartsRetryTimes = 10
 const completePartsRetryTimes = 5
+const listPartsRetryTimes = 5
+const retryInterval = 3 * time.Second
 
 var ErrMd5NotMatch = httputil.NewError(406, "md5 not match")
 
@@ -113,6 +116,14 @@ func (p *CompleteMultipart) Sort() {
 	sort.Sort(p)
 }
 
+func (p Uploader) listParts(ctx context.Context, host string, ret interface{}, bucket, key, uploadId string, marker int) error {
+	url1 := fmt.Sprintf("%s/buckets/%s/objects/%s/uploads/%s?max-parts=%d", host, bucket, encodeKey(key, true), uploadId, listPartsLimit)
+	if marker > 0 {
+		url1 += fmt.Sprintf("&part-number-marker=%d", marker)
+	}
+	return p.Conn.Call(ctx, ret, "GET", url1)
+}
+
 //https://github.com/qbox/product/blob/master/kodo/resumable-up-v2/delete_parts.md
 func (p Uploader) deleteParts(ctx context.Context, host, bucket, key string, hasKey bool, uploadId string) error {
 	url1 := fmt.Sprintf("%s/buckets/%s/objects/%s/uploads/%s", host, bucket, encodeKey(key, hasKey), uploadId)
@@ -147,34 +158,183 @@ func (p Uploader) UploadWithoutKeyWithParts(ctx context.Context, ret interface{}
 	return p.upload(ctx, ret, uptoken, "", false, f, fsize, uploadParts, mp, partNotify)
 }
 
+// 初始化一个分片上传任务，返回的 uploadId 可用于 ResumeUpload 续传。
+//
+func (p Uploader) InitMultipart(ctx context.Context, uptoken, key string) (uploadId string, err error) {
+	bucket, err := bucketOfUptoken(uptoken)
+	if err != nil {
+		return
+	}
+	p.Conn.Client = newUptokenClient(uptoken, p.Conn.Transport)
+	return p.initPartsWithHost(ctx, bucket, key, true)
+}
+
+// 续传一个已经初始化的分片上传任务，alreadyDone 中的分片不会被重新上传。
+// 分片的划分方式需要和之前上传时一致，即 UploadPartSize 不能改变。
+// 和 Upload 不同，上传失败或者被取消时不会删除已经上传的分片，以便之后继续续传。
+// alreadyDone 可以来自调用方自己保存的记录，也可以通过 ListParts 获得。
+//
+func (p Uploader) ResumeUpload(ctx context.Context, ret interface{}, uptoken, key, uploadId string, alreadyDone []Part,
+	f io.ReaderAt, fsize int64, mp *CompleteMultipart, partNotify func(partIdx int, etag string)) error {
+
+	if fsize == 0 {
+		return errors.New("can't upload empty file")
+	}
+	bucket, err := bucketOfUptoken(uptoken)
+	if err != nil {
+		return err
+	}
+	p.Conn.Client = newUptokenClient(uptoken, p.Conn.Transport)
+	uploadParts := p.makeUploadParts(fsize)
+	return p.uploadParts(ctx, ret, bucket, key, true, uploadId, alreadyDone, false, f, uploadParts, mp, partNotify)
+}
+
+// 上传一个分片，分片需要在 CompleteMultipart 之前全部上传完成。
+// 不同的分片可以由不同的机器使用同一个 uploadId 并行上传，失败时会自动重试。
+//
+func (p Uploader) UploadPart(ctx context.Context, uptoken, key, uploadId string, partNum int, body io.ReaderAt, size int64) (ret UploadPartRet, err error) {
+	bucket, err := bucketOfUptoken(uptoken)
+	if err != nil {
+		return
+	}
+	p.Conn.Client = newUptokenClient(uptoken, p.Conn.Transport)
+	getBody := func() (io.Reader, int) {
+		return io.NewSectionReader(body, 0, size), int(size)
+	}
+	return p.uploadPartWithRetry(ctx, bucket, key, true, uploadId, partNum, getBody)
+}
+
+type ListPartsItem struct {
+	PartNumber int    `json:"partNumber"`
+	Etag       string `json:"etag"`
+	Size       int64  `json:"size"`
+	PutTime    int64  `json:"putTime"`
+}
+
+const listPartsLimit = 1000
+
+// 列举一个分片上传任务中已经上传完成的分片，结果按分片号排序。
+//
+func (p Uploader) ListParts(ctx context.Context, uptoken, key, uploadId string) (parts []ListPartsItem, err error) {
+	bucket, err := bucketOfUptoken(uptoken)
+	if err != nil {
+		return
+	}
+	p.Conn.Client = newUptokenClient(uptoken, p.Conn.Transport)
+
+	marker := 0
+	for {
+		var ret struct {
+			PartNumberMarker int             `json:"partNumberMarker"`
+			Parts            []ListPartsItem `json:"parts"`
+		}
+		if err = p.listPartsWithRetry(ctx, &ret, bucket, key, uploadId, marker); err != nil {
+			return nil, err
+		}
+		parts = append(parts, ret.Parts...)
+		if ret.PartNumberMarker == 0 || len(ret.Parts) == 0 {
+			break
+		}
+		marker = ret.PartNumberMarker
+	}
+	sort.Slice(parts, func(i, j int) bool {
+		return parts[i].PartNumber < parts[j].PartNumber
+	})
+	return
+}
+
+// 合并所有已经上传的分片，生成最终的文件。mp.Parts 不需要预先排序。
+//
+func (p Uploader) CompleteMultipart(ctx context.Context, ret interface{}, uptoken, key, uploadId string, mp *CompleteMultipart) error {
+	bucket, err := bucketOfUptoken(uptoken)
+	if err != nil {
+		return err
+	}
+	if mp == nil || len(mp.Parts) == 0 {
+		return errors.New("no parts to complete")
+	}
+	p.Conn.Client = newUptokenClient(uptoken, p.Conn.Transport)
+	sorted := *mp
+	sorted.Parts = append([]Part(nil), mp.Parts...)
+	sorted.Sort()
+	return p.completePartsWithRetry(ctx, ret, bucket, key, true, uploadId, &sorted)
+}
+
+// 终止一个分片上传任务，并删除已经上传的分片。
+//
+func (p Uploader) AbortMultipart(ctx context.Context, uptoken, key, uploadId string) error {
+	bucket, err := bucketOfUptoken(uptoken)
+	if err != nil {
+		return err
+	}
+	p.Conn.Client = newUptokenClient(uptoken, p.Conn.Transport)
+	return p.deletePartsWithRetry(ctx, bucket, key, true, uploadId)
+}
+
+func bucketOfUptoken(uptoken string) (bucket string, err error) {
+	policy, err := kodo.ParseUptoken(uptoken)
+	if err != nil {
+		return
+	}
+	return strings.Split(policy.Scope, ":")[0], nil
+}
+
+func (p Uploader) initPartsWithHost(ctx context.Context, bucket, key string, hasKey bool) (uploadId string, err error) {
+	upHost := p.chooseUpHost()
+	start := p.startRequest(upHost)
+	uploadId, err = p.initParts(ctx, upHost, bucket, key, hasKey)
+	p.observeRequest("init_parts", upHost, 0, start, err)
+	if err != nil {
+		p.hostPool().Fail(upHost)
+	} else {
+		p.hostPool().Succeed(upHost)
+	}
+	return
+}
+
 func (p Uploader) upload(ctx context.Context, ret interface{}, uptoken, key string, hasKey bool, f io.ReaderAt, fsize int64, uploadParts []int64,
 	mp *CompleteMultipart, partNotify func(partIdx int, etag string)) error {
 
-	xl := xlog.FromContextSafe(ctx)
 	if fsize == 0 {
 		return errors.New("can't upload empty file")
 	}
 
-	policy, err := kodo.ParseUptoken(uptoken)
+	bucket, err := bucketOfUptoken(uptoken)
 	if err != nil {
 		return err
 	}
-	bucket := strings.Split(policy.Scope, ":")[0]
 
 	p.Conn.Client = newUptokenClient(uptoken, p.Conn.Transport)
-	upHost := p.chooseUpHost()
-	uploadId, err := p.initParts(ctx, upHost, bucket, key, hasKey)
+	uploadId, err := p.initPartsWithHost(ctx, bucket, key, hasKey)
 	if err != nil {
-		failHostName(upHost)
 		return err
-	} else {
-		succeedHostName(upHost)
 	}
+	return p.uploadParts(ctx, ret, bucket, key, hasKey, uploadId, nil, true, f, uploadParts, mp, partNotify)
+}
+
+// abortOnErr 为 true 时，上传失败或者被取消会删除已经上传的分片
+func (p Uploader) uploadParts(ctx context.Context, ret interface{}, bucket, key string, hasKey bool, uploadId string, alreadyDone []Part,
+	abortOnErr bool, f io.ReaderAt, uploadParts []int64, mp *CompleteMultipart, partNotify func(partIdx int, etag string)) (err error) {
 
+	xl := xlog.FromContextSafe(ctx)
 	var partUpErr error
 	partUpErrLock := sync.Mutex{}
 	partCnt := len(uploadParts)
 	parts := make([]Part, partCnt)
+	for _, part := range alreadyDone {
+		if part.PartNumber < 1 || part.PartNumber > partCnt {
+			return fmt.Errorf("invalid part number: %d", part.PartNumber)
+		}
+		parts[part.PartNumber-1] = part
+	}
+	var fsize, doneSize int64
+	for i, partSize := range uploadParts {
+		fsize += partSize
+		if parts[i].Etag != "" {
+			doneSize += partSize
+		}
+	}
+	progress := newPartsProgress(fsize, doneSize, p.OnProgress)
 	partUpCtx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
@@ -182,11 +342,14 @@ func (p Uploader) upload(ctx context.Context, ret interface{}, uptoken, key stri
 	var wg sync.WaitGroup
 	var lastPartEnd int64 = 0
 	for i := 0; i < partCnt; i++ {
-		wg.Add(1)
-		bkLimit.Acquire(nil)
 		partSize := uploadParts[i]
 		offset := lastPartEnd
 		lastPartEnd = partSize + offset
+		if parts[i].Etag != "" {
+			continue
+		}
+		wg.Add(1)
+		bkLimit.Acquire(nil)
 		go func(f io.ReaderAt, offset int64, partNum int, partSize int64) {
 			defer func() {
 				bkLimit.Release(nil)
@@ -200,6 +363,7 @@ func (p Uploader) upload(ctx context.Context, ret interface{}, uptoken, key stri
 
 			var buf []byte = nil
 			if p.UseBuffer {
+				var err error
 				buf, err = ioutil.ReadAll(io.NewSectionReader(f, offset, partSize))
 				if err != nil {
 					partUpErrLock.Lock()
@@ -213,9 +377,9 @@ func (p Uploader) upload(ctx context.Context, ret interface{}, uptoken, key stri
 
 			getBody := func() (io.Reader, int) {
 				if buf == nil {
-					return io.NewSectionReader(f, offset, partSize), int(partSize)
+					return progress.wrap(partNum, io.NewSectionReader(f, offset, partSize)), int(partSize)
 				} else {
-					return bytes.NewReader(buf), len(buf)
+					return progress.wrap(partNum, bytes.NewReader(buf)), len(buf)
 				}
 			}
 			ret, err := p.uploadPartWithRetry(partUpCtx, bucket, key, hasKey, uploadId, partNum, getBody)
@@ -235,8 +399,14 @@ func (p Uploader) upload(ctx context.Context, ret interface{}, uptoken, key stri
 	}
 	wg.Wait()
 
+	if partUpErr == nil && ctx.Err() != nil {
+		partUpErr = ctx.Err()
+	}
 	if partUpErr != nil {
-		err = p.deletePartsWithRetry(ctx, bucket, key, hasKey, uploadId)
+		if !abortOnErr {
+			return partUpErr
+		}
+		err = p.deletePartsWithRetry(detachContext(ctx), bucket, key, hasKey, uploadId)
 		if err != nil {
 			return err
 		}
@@ -303,26 +473,23 @@ func (p Uploader) StreamUploadWithoutKey(ctx context.Context, ret interface{}, u
 }
 
 func (p Uploader) streamUpload(ctx context.Context, ret interface{}, uptoken, key string, hasKey bool, reader io.Reader, partNotify func(partIdx int, etag string)) error {
-	policy, err := kodo.ParseUptoken(uptoken)
+	bucket, err := bucketOfUptoken(uptoken)
 	if err != nil {
 		return err
 	}
-	bucket := strings.Split(policy.Scope, ":")[0]
 
 	p.Conn.Client = newUptokenClient(uptoken, p.Conn.Transport)
-	upHost := p.chooseUpHost()
-	uploadId, err := p.initParts(ctx, upHost, bucket, key, hasKey)
+	uploadId, err := p.initPartsWithHost(ctx, bucket, key, hasKey)
 	if err != nil {
-		failHostName(upHost)
 		return err
 	}
-	succeedHostName(upHost)
 
 	partUpCtx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
 	var parts []Part
 	var partsLock sync.Mutex
+	progress := newPartsProgress(-1, 0, p.OnProgress)
 
 	var wg sync.WaitGroup
 	type PartData struct {
@@ -346,11 +513,11 @@ func (p Uploader) streamUpload(ctx context.Context, ret interface{}, uptoken, ke
 						return
 					}
 					getBody := func() (io.Reader, int) {
-						return bytes.NewReader(partData.Data), len(partData.Data)
+						return progress.wrap(partData.PartNumber, bytes.NewReader(partData.Data)), len(partData.Data)
 					}
 					ret, err := p.uploadPartWithRetry(partUpCtx, bucket, key, hasKey, uploadId, partData.PartNumber, getBody)
 					if err != nil {
-						if err != context.Canceled {
+						if partUpCtx.Err() == nil {
 							errorChan <- err
 							cancel()
 						}
@@ -367,23 +534,35 @@ func (p Uploader) streamUpload(ctx context.Context, ret interface{}, uptoken, ke
 		}()
 	}
 
+	var readErr error
+readLoop:
 	for partNum := 1; ; partNum++ {
 		data, err := ioutil.ReadAll(io.LimitReader(reader, p.UploadPartSize))
 		if err != nil {
-			close(partChan)
-			return err
+			readErr = err
+			cancel()
+			break
 		} else if len(data) == 0 {
 			break
-		} else {
-			partChan <- PartData{Data: data, PartNumber: partNum}
+		}
+		select {
+		case partChan <- PartData{Data: data, PartNumber: partNum}:
+		case <-partUpCtx.Done():
+			break readLoop
 		}
 	}
 	close(partChan)
 	wg.Wait()
 	close(errorChan)
 	partUpErr := <-errorChan
+	if partUpErr == nil {
+		partUpErr = readErr
+	}
+	if partUpErr == nil && ctx.Err() != nil {
+		partUpErr = ctx.Err()
+	}
 	if partUpErr != nil {
-		err = p.deletePartsWithRetry(ctx, bucket, key, hasKey, uploadId)
+		err = p.deletePartsWithRetry(detachContext(ctx), bucket, key, hasKey, uploadId)
 		if err != nil {
 			return err
 		}
@@ -395,84 +574,171 @@ func (p Uploader) streamUpload(ctx context.Context, ret interface{}, uptoken, ke
 	return p.completePartsWithRetry(ctx, ret, bucket, key, hasKey, uploadId, &completeMultipart)
 }
 
+// retryPolicy 返回配置的重试策略，没有配置时使用最多尝试 attempts 次、每次间隔 3 秒的策略，
+// 配置中没有设置 MaxAttempts 时最多尝试 attempts 次
+func (p Uploader) retryPolicy(attempts int) *retry.Policy {
+	if p.Retry != nil {
+		if p.Retry.MaxAttempts == 0 {
+			return p.Retry.WithAttempts(attempts)
+		}
+		return p.Retry
+	}
+	return &retry.Policy{MaxAttempts: attempts, InitialBackoff: retryInterval, Multiplier: 1}
+}
+
 func (p Uploader) uploadPartWithRetry(ctx context.Context, bucket, key string, hasKey bool, uploadId string, partNum int, getBody func() (io.Reader, int)) (ret UploadPartRet, err error) {
 	xl := xlog.NewWith(xlog.FromContextSafe(ctx).ReqId() + "." + fmt.Sprint(partNum))
-	tryTimes := uploadPartRetryTimes
+	policy := p.retryPolicy(uploadPartRetryTimes)
 
-	for {
+	err = policy.Do(ctx, func(attempt int) (err error) {
 		upHost := p.chooseUpHost()
 		bodyReader, bodySize := getBody()
+		start := p.startRequest(upHost)
 		ret, err = p.uploadPart(ctx, upHost, bucket, key, hasKey, uploadId, partNum, bodyReader, bodySize)
+		p.observeRequest("upload_part", upHost, attempt, start, err)
 		if err == nil {
-			succeedHostName(upHost)
-			break
+			p.metrics().ObservePartUpload(upHost, time.Since(start))
+			p.metrics().AddBytes(metrics.Up, int64(bodySize))
+		}
+		if err != nil && policy.ShouldRetry(err) {
+			p.hostPool().Fail(upHost)
+			elog.Warn(xl.ReqId(), "uploadPartRetry:", partNum, err)
 		} else {
-			if err == context.Canceled {
-				break
-			}
-			code := httputil.DetectCode(err)
-			if code == 509 { // 因为流量受限失败，不减少重试次数
-				failHostName(upHost)
-				elog.Warn(xl.ReqId(), "uploadPartRetryLater:", partNum, err)
-				time.Sleep(time.Second * time.Duration(rand.Intn(9)+1))
-			} else if tryTimes > 1 && (code == 406 || code/100 != 4) {
-				failHostName(upHost)
-				tryTimes--
-				elog.Warn(xl.ReqId(), "uploadPartRetry:", partNum, err)
-				time.Sleep(time.Second * 3)
-			} else {
-				succeedHostName(upHost)
-				break
-			}
+			p.hostPool().Succeed(upHost)
 		}
+		return
+	})
+	if err != nil && ctx.Err() != nil {
+		err = ctx.Err()
 	}
 	return
 }
 
 func (p Uploader) completePartsWithRetry(ctx context.Context, ret interface{}, bucket, key string, hasKey bool, uploadId string, mp *CompleteMultipart) (err error) {
 	xl := xlog.FromContextSafe(ctx)
+	policy := p.retryPolicy(completePartsRetryTimes)
 
-	for i := 0; i < completePartsRetryTimes; i++ {
+	err = policy.Do(ctx, func(attempt int) (err error) {
 		upHost := p.chooseUpHost()
+		start := p.startRequest(upHost)
 		err = p.completeParts(ctx, upHost, ret, bucket, key, hasKey, uploadId, mp)
-		if err == context.Canceled {
-			break
+		p.observeRequest("complete_parts", upHost, attempt, start, err)
+		if code := httputil.DetectCode(err); code == 612 || code == 614 {
+			p.hostPool().Succeed(upHost)
+			elog.Warn(xl.ReqId(), "completeParts:", err)
+			return nil
 		}
-		code := httputil.DetectCode(err)
-		if err == nil || code/100 == 4 || code == 612 || code == 614 || code == 579 {
-			succeedHostName(upHost)
-			if code == 612 || code == 614 {
-				elog.Warn(xl.ReqId(), "completeParts:", err)
-				err = nil
-			}
-			break
+		if err != nil && policy.ShouldRetry(err) {
+			p.hostPool().Fail(upHost)
+			elog.Error(xl.ReqId(), "completeParts:", err)
+		} else {
+			p.hostPool().Succeed(upHost)
+		}
+		return
+	})
+	if err != nil && ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	return
+}
+
+func (p Uploader) listPartsWithRetry(ctx context.Context, ret interface{}, bucket, key, uploadId string, marker int) (err error) {
+	xl := xlog.FromContextSafe(ctx)
+	policy := p.retryPolicy(listPartsRetryTimes)
+
+	err = policy.Do(ctx, func(attempt int) (err error) {
+		upHost := p.chooseUpHost()
+		start := p.startRequest(upHost)
+		err = p.listParts(ctx, upHost, ret, bucket, key, uploadId, marker)
+		p.observeRequest("list_parts", upHost, attempt, start, err)
+		if err != nil && policy.ShouldRetry(err) {
+			p.hostPool().Fail(upHost)
+			elog.Warn(xl.ReqId(), "listParts:", err)
 		} else {
-			failHostName(upHost)
-			elog.Error(xl.ReqId(), "completeParts:", err, code)
-			time.Sleep(time.Second * 3)
+			p.hostPool().Succeed(upHost)
 		}
+		return
+	})
+	if err != nil && ctx.Err() != nil {
+		err = ctx.Err()
 	}
 	return
 }
 
 func (p Uploader) deletePartsWithRetry(ctx context.Context, bucket, key string, hasKey bool, uploadId string) (err error) {
 	xl := xlog.FromContextSafe(ctx)
+	policy := p.retryPolicy(deletePartsRetryTimes)
 
-	for i := 0; i < deletePartsRetryTimes; i++ {
+	err = policy.Do(ctx, func(attempt int) (err error) {
 		upHost := p.chooseUpHost()
+		start := p.startRequest(upHost)
 		err = p.deleteParts(ctx, upHost, bucket, key, hasKey, uploadId)
-		if err == context.Canceled {
-			break
-		}
-		code := httputil.DetectCode(err)
-		if err == nil || code/100 == 4 {
-			succeedHostName(upHost)
-			break
-		} else {
-			failHostName(upHost)
+		p.observeRequest("delete_parts", upHost, attempt, start, err)
+		if err != nil && policy.ShouldRetry(err) {
+			p.hostPool().Fail(upHost)
 			elog.Error(xl.ReqId(), "deleteParts:", err)
-			time.Sleep(time.Second * 3)
+		} else {
+			p.hostPool().Succeed(upHost)
 		}
+		return
+	})
+	if err != nil && ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	return
+}
+
+// detachContext 返回一个保留 ctx 中 reqid 但不随 ctx 取消的上下文，用于取消后清理已上传的分片
+func detachContext(ctx context.Context) context.Context {
+	return xlog.NewContext(context.Background(), xlog.FromContextSafe(ctx).Spawn())
+}
+
+// partsProgress 汇总各个分片的上传进度，分片重试时会先扣除该分片之前的进度
+type partsProgress struct {
+	m          sync.Mutex
+	fsize      int64
+	uploaded   int64
+	parts      map[int]int64
+	onProgress func(fsize, uploaded int64)
+}
+
+func newPartsProgress(fsize, uploaded int64, onProgress func(fsize, uploaded int64)) *partsProgress {
+	if onProgress == nil {
+		return nil
+	}
+	return &partsProgress{fsize: fsize, uploaded: uploaded, parts: make(map[int]int64), onProgress: onProgress}
+}
+
+func (pp *partsProgress) wrap(partNum int, r io.Reader) io.Reader {
+	if pp == nil {
+		return r
+	}
+	pp.m.Lock()
+	pp.uploaded -= pp.parts[partNum]
+	pp.parts[partNum] = 0
+	pp.m.Unlock()
+	return &partReader{r: r, partNum: partNum, progress: pp}
+}
+
+func (pp *partsProgress) add(partNum int, n int64) {
+	pp.m.Lock()
+	pp.parts[partNum] += n
+	pp.uploaded += n
+	uploaded := pp.uploaded
+	pp.m.Unlock()
+	pp.onProgress(pp.fsize, uploaded)
+}
+
+type partReader struct {
+	r        io.Reader
+	partNum  int
+	progress *partsProgress
+}
+
+func (r *partReader) Read(b []byte) (n int, err error) {
+	n, err = r.r.Read(b)
+	if n > 0 {
+		r.progress.add(r.partNum, int64(n))
 	}
 	return
 }
@@ -1,17 +1,24 @@
 package kodocli
 
 import (
+	"bytes"
 	"context"
 	"crypto/md5"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"path"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/api.v8/kodo"
+	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/x/retry.v1"
 )
 
 var uploader Uploader
@@ -151,9 +158,264 @@ func TestStreamUpload(t *testing.T) {
 
 	defer resp.Body.Close()
 	var ret PutRet
-	err = upCli.StreamUpload(context.TODO(), &ret, upToken, key, resp.Body, resp.ContentLength, nil, nil)
+	err = upCli.StreamUpload(context.TODO(), &ret, upToken, key, resp.Body, nil)
 	if err != nil {
 		t.Fatalf("up file err: %v", err)
 	}
 	t.Log(ret)
 }
+
+func TestUploadCancel(t *testing.T) {
+	var deleted int32
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case "POST":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"uploadId":"test-upload-id"}`))
+		case "PUT":
+			<-release
+		case "DELETE":
+			atomic.AddInt32(&deleted, 1)
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	upCli := NewUploader(0, &UploadConfig{
+		UpHosts:        []string{srv.URL},
+		UploadPartSize: minUploadPartSize,
+	})
+	upToken := MakeAuthTokenString("ak", "sk", &AuthPolicy{Scope: "bucket:key"})
+	data := make([]byte, minUploadPartSize*2)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	err := upCli.Upload(ctx, nil, upToken, "key", bytes.NewReader(data), int64(len(data)), nil, nil)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("expect %v, but got: %v", context.DeadlineExceeded, err)
+	}
+	if atomic.LoadInt32(&deleted) != 1 {
+		t.Fatalf("expect parts to be deleted once, but got: %d", deleted)
+	}
+}
+
+func TestResumeUpload(t *testing.T) {
+	var uploaded, completed []string
+	var lock sync.Mutex
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		switch req.Method {
+		case "PUT":
+			h := md5.New()
+			io.Copy(h, req.Body)
+			uploaded = append(uploaded, path.Base(req.URL.Path))
+			fmt.Fprintf(w, `{"etag":"etag-%s","md5":"%x"}`, path.Base(req.URL.Path), h.Sum(nil))
+		case "POST":
+			var mp CompleteMultipart
+			json.NewDecoder(req.Body).Decode(&mp)
+			for _, part := range mp.Parts {
+				completed = append(completed, part.Etag)
+			}
+			w.Write([]byte(`{}`))
+		}
+	}))
+	defer srv.Close()
+
+	var maxUploaded, progressSize int64
+	var progressLock sync.Mutex
+	upCli := NewUploader(0, &UploadConfig{
+		UpHosts:        []string{srv.URL},
+		UploadPartSize: minUploadPartSize,
+		OnProgress: func(fsize, uploaded int64) {
+			progressLock.Lock()
+			defer progressLock.Unlock()
+			if uploaded < minUploadPartSize {
+				t.Errorf("progress should start from finished parts, but got: %d", uploaded)
+			}
+			if uploaded > maxUploaded {
+				maxUploaded = uploaded
+			}
+			progressSize = fsize
+		},
+	})
+	upToken := MakeAuthTokenString("ak", "sk", &AuthPolicy{Scope: "bucket:key"})
+	data := make([]byte, minUploadPartSize*3)
+
+	err := upCli.ResumeUpload(context.Background(), nil, upToken, "key", "test-upload-id",
+		[]Part{{PartNumber: 2, Etag: "etag-2"}}, bytes.NewReader(data), int64(len(data)), nil, nil)
+	if err != nil {
+		t.Fatal("resume upload failed:", err)
+	}
+	sort.Strings(uploaded)
+	if fmt.Sprint(uploaded) != "[1 3]" {
+		t.Fatalf("expect parts [1 3] to be uploaded, but got: %v", uploaded)
+	}
+	if fmt.Sprint(completed) != "[etag-1 etag-2 etag-3]" {
+		t.Fatalf("unexpected complete parts: %v", completed)
+	}
+	if progressSize != int64(len(data)) || maxUploaded != int64(len(data)) {
+		t.Fatalf("unexpected progress: %d/%d", maxUploaded, progressSize)
+	}
+}
+
+func TestMultipartAPI(t *testing.T) {
+	var lock sync.Mutex
+	parts := map[int]ListPartsItem{}
+	var completed CompleteMultipart
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case req.Method == "POST" && path.Base(req.URL.Path) == "uploads":
+			w.Write([]byte(`{"uploadId":"test-upload-id"}`))
+		case req.Method == "PUT":
+			h := md5.New()
+			n, _ := io.Copy(h, req.Body)
+			var partNum int
+			fmt.Sscan(path.Base(req.URL.Path), &partNum)
+			parts[partNum] = ListPartsItem{PartNumber: partNum, Etag: fmt.Sprint("etag-", partNum), Size: n}
+			fmt.Fprintf(w, `{"etag":"etag-%d","md5":"%x"}`, partNum, h.Sum(nil))
+		case req.Method == "GET":
+			var items []ListPartsItem
+			for _, part := range parts {
+				items = append(items, part)
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"parts": items})
+		case req.Method == "POST":
+			json.NewDecoder(req.Body).Decode(&completed)
+			w.Write([]byte(`{}`))
+		}
+	}))
+	defer srv.Close()
+
+	upCli := NewUploader(0, &UploadConfig{UpHosts: []string{srv.URL}})
+	upToken := MakeAuthTokenString("ak", "sk", &AuthPolicy{Scope: "bucket:key"})
+	ctx := context.Background()
+
+	uploadId, err := upCli.InitMultipart(ctx, upToken, "key")
+	if err != nil || uploadId != "test-upload-id" {
+		t.Fatal("init multipart failed:", uploadId, err)
+	}
+	for _, partNum := range []int{2, 1} {
+		data := bytes.Repeat([]byte{byte(partNum)}, 1024)
+		if _, err = upCli.UploadPart(ctx, upToken, "key", uploadId, partNum, bytes.NewReader(data), int64(len(data))); err != nil {
+			t.Fatal("upload part failed:", partNum, err)
+		}
+	}
+
+	listed, err := upCli.ListParts(ctx, upToken, "key", uploadId)
+	if err != nil || len(listed) != 2 || listed[0].PartNumber != 1 || listed[1].Size != 1024 {
+		t.Fatal("list parts failed:", listed, err)
+	}
+	mp := &CompleteMultipart{}
+	for _, part := range listed {
+		mp.Parts = append(mp.Parts, Part{PartNumber: part.PartNumber, Etag: part.Etag})
+	}
+	if err = upCli.CompleteMultipart(ctx, nil, upToken, "key", uploadId, mp); err != nil {
+		t.Fatal("complete multipart failed:", err)
+	}
+	if len(completed.Parts) != 2 || completed.Parts[0].Etag != "etag-1" {
+		t.Fatal("unexpected complete parts:", completed.Parts)
+	}
+}
+
+func TestListPartsRetry(t *testing.T) {
+	var lists int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if atomic.AddInt32(&lists, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"error":"service unavailable"}`))
+			return
+		}
+		w.Write([]byte(`{"parts":[{"partNumber":2,"etag":"etag-2"},{"partNumber":1,"etag":"etag-1"}]}`))
+	}))
+	defer srv.Close()
+
+	upCli := NewUploader(0, &UploadConfig{UpHosts: []string{srv.URL}, Retry: &retry.Policy{MaxAttempts: 2}})
+	upToken := MakeAuthTokenString("ak", "sk", &AuthPolicy{Scope: "bucket:key"})
+
+	parts, err := upCli.ListParts(context.Background(), upToken, "key", "test-upload-id")
+	if err != nil || len(parts) != 2 || parts[0].PartNumber != 1 {
+		t.Fatal("list parts failed:", parts, err)
+	}
+	if atomic.LoadInt32(&lists) != 2 {
+		t.Fatal("expect list parts to be retried once, but got:", lists)
+	}
+}
+
+func TestCompleteMultipartKeepsParts(t *testing.T) {
+	var completed CompleteMultipart
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewDecoder(req.Body).Decode(&completed)
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	upCli := NewUploader(0, &UploadConfig{UpHosts: []string{srv.URL}})
+	upToken := MakeAuthTokenString("ak", "sk", &AuthPolicy{Scope: "bucket:key"})
+	mp := &CompleteMultipart{Parts: []Part{{PartNumber: 2, Etag: "etag-2"}, {PartNumber: 1, Etag: "etag-1"}}}
+
+	if err := upCli.CompleteMultipart(context.Background(), nil, upToken, "key", "test-upload-id", mp); err != nil {
+		t.Fatal("complete multipart failed:", err)
+	}
+	if len(completed.Parts) != 2 || completed.Parts[0].PartNumber != 1 {
+		t.Fatal("parts should be sorted before completing:", completed.Parts)
+	}
+	if mp.Parts[0].PartNumber != 2 {
+		t.Fatal("caller's parts should not be modified:", mp.Parts)
+	}
+}
+
+func TestRetryPolicyDefaultAttempts(t *testing.T) {
+	var up Uploader
+	if p := up.retryPolicy(deletePartsRetryTimes); p.MaxAttempts != deletePartsRetryTimes || p.InitialBackoff != retryInterval {
+		t.Fatalf("unexpected default policy: %+v", p)
+	}
+
+	up.Retry = &retry.Policy{MaxElapsed: time.Minute}
+	if p := up.retryPolicy(deletePartsRetryTimes); p.MaxAttempts != deletePartsRetryTimes || p.MaxElapsed != time.Minute {
+		t.Fatalf("policy without MaxAttempts should use default attempts: %+v", p)
+	}
+	if up.Retry.MaxAttempts != 0 {
+		t.Fatal("configured policy should not be modified")
+	}
+
+	up.Retry = &retry.Policy{MaxAttempts: 2}
+	if p := up.retryPolicy(deletePartsRetryTimes); p != up.Retry {
+		t.Fatalf("configured policy should be used: %+v", p)
+	}
+}
+
+func TestUploaderHostState(t *testing.T) {
+	hosts := []string{"http://up1", "http://up2"}
+	a := NewUploader(0, &UploadConfig{UpHosts: hosts})
+	b := NewUploader(0, &UploadConfig{UpHosts: hosts})
+	if a.HostSelector == nil || a.HostSelector == b.HostSelector || a.HostPool == nil || a.HostPool == b.HostPool {
+		t.Fatal("each Uploader should have its own selector and pool")
+	}
+	for i := 0; i < MaxContinuousFailureTimes; i++ {
+		a.hostPool().Fail("http://up1")
+	}
+	if a.hostPool().Usable("http://up1") || !b.hostPool().Usable("http://up1") {
+		t.Fatal("failures should not be shared between Uploaders")
+	}
+
+	up := Uploader{UpHosts: hosts}
+	for i := 0; i < 10; i++ {
+		up.hostPool().Fail("http://up1")
+	}
+	seen := map[string]bool{}
+	for i := 0; i < 100; i++ {
+		seen[up.chooseUpHost()] = true
+	}
+	if len(seen) != 2 || up.hostPool() != nil {
+		t.Fatal("Uploader without pool should use every host:", seen)
+	}
+}
@@ -1,33 +1,44 @@
 package kodocli
 
 import (
-	"container/ring"
 	"math/rand"
 	"os"
-	"sync"
-	"sync/atomic"
 	"time"
+
+	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/x/hostpool.v1"
 )
 
-var curUpHostIndex uint32 = 0
+// hostSelector 返回 Uploader 使用的 Selector。NewUploader 创建的 Uploader 总是有自己的 Selector，
+// 直接构造并且没有设置 HostSelector 的 Uploader 随机选择节点，不保存任何状态
+func (p Uploader) hostSelector() hostpool.Selector {
+	if p.HostSelector != nil {
+		return p.HostSelector
+	}
+	return randomSelector{}
+}
+
+// randomSelector 从可用的节点中随机选择一个，Start 和 Done 不做任何事情
+type randomSelector struct{}
+
+func (randomSelector) Select(hosts []string, usable func(string) bool) string {
+	start := rand.Intn(len(hosts))
+	for i := range hosts {
+		host := hosts[(start+i)%len(hosts)]
+		if usable == nil || usable(host) {
+			return host
+		}
+	}
+	return hosts[start]
+}
+
+func (randomSelector) Start(host string)                                    {}
+func (randomSelector) Done(host string, elapsed time.Duration, failed bool) {}
 
 func (p Uploader) chooseUpHost() string {
-	switch len(p.UpHosts) {
-	case 0:
+	if len(p.UpHosts) == 0 {
 		panic("No Up hosts is configured")
-	case 1:
-		return p.UpHosts[0]
-	default:
-		var upHost string
-		for i := 0; i <= len(p.UpHosts)*MaxFindHostsPrecent/100; i++ {
-			index := int(atomic.AddUint32(&curUpHostIndex, 1) - 1)
-			upHost = p.UpHosts[index%len(p.UpHosts)]
-			if isHostNameValid(upHost) {
-				break
-			}
-		}
-		return upHost
 	}
+	return p.hostSelector().Select(p.UpHosts, p.hostPool().Usable)
 }
 
 func (p Uploader) shuffleUpHosts() {
@@ -39,61 +50,25 @@ func (p Uploader) shuffleUpHosts() {
 	}
 }
 
-type hostsScore struct {
-	m      sync.Mutex
-	scores *ring.Ring
-}
-
 var (
 	MaxContinuousFailureTimes    = 5
 	MaxContinuousFailureDuration = 1 * time.Minute
 	MaxFindHostsPrecent          = 50
-	hostsScores                  sync.Map
 )
 
-func isHostNameValid(hostName string) bool {
-	if hs, ok := hostsScores.Load(hostName); ok {
-		return hs.(*hostsScore).isValid()
-	}
-	return true
-}
-
-func failHostName(hostName string) {
-	hs, _ := hostsScores.LoadOrStore(hostName, newHostsScore())
-	hs.(*hostsScore).fail()
-}
-
-func succeedHostName(hostName string) {
-	hostsScores.Delete(hostName)
-}
-
-func newHostsScore() *hostsScore {
-	return &hostsScore{
-		scores: ring.New(MaxContinuousFailureTimes),
-	}
+// NewHostPool 按 MaxContinuousFailureTimes 和 MaxContinuousFailureDuration 创建上传节点的 Pool：
+// MaxContinuousFailureDuration 内连续失败 MaxContinuousFailureTimes 次的节点被熔断，
+// MaxContinuousFailureDuration 后或者探测成功后进入半开状态
+func NewHostPool() *hostpool.Pool {
+	return hostpool.NewPool(hostpool.BreakerConfig{
+		MaxFailures: MaxContinuousFailureTimes,
+		Window:      MaxContinuousFailureDuration,
+		OpenTimeout: MaxContinuousFailureDuration,
+	})
 }
 
-func (hs *hostsScore) isValid() bool {
-	hs.m.Lock()
-	defer hs.m.Unlock()
-
-	for i := 0; i < hs.scores.Len(); i++ {
-		if t, ok := hs.scores.Value.(time.Time); ok {
-			if t.Add(MaxContinuousFailureDuration).Before(time.Now()) {
-				return true
-			}
-		} else {
-			return true
-		}
-		hs.scores = hs.scores.Next()
-	}
-	return false
-}
-
-func (hs *hostsScore) fail() {
-	hs.m.Lock()
-	defer hs.m.Unlock()
-
-	hs.scores.Value = time.Now()
-	hs.scores = hs.scores.Next()
+// hostPool 返回 Uploader 使用的 Pool。NewUploader 创建的 Uploader 总是有自己的 Pool，
+// 直接构造并且没有设置 HostPool 的 Uploader 返回 nil：所有节点都视为可用，不记录节点的健康状态
+func (p Uploader) hostPool() *hostpool.Pool {
+	return p.HostPool
 }
@@ -8,7 +8,6 @@ import (
 	"hash"
 	"hash/crc32"
 	"io"
-	"math/rand"
 	"mime/multipart"
 	"net/http"
 	"net/textproto"
@@ -16,9 +15,9 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
-	"time"
 
-	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/x/httputil.v1"
+	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/x/metrics.v1"
+	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/x/retry.v1"
 	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/x/rpc.v7"
 	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/x/xlog.v8"
 )
@@ -120,14 +119,30 @@ func (p Uploader) put(
 	if extra == nil {
 		extra = &defaultPutExtra
 	}
+	onProgress := p.progressFunc(extra)
 
-	tryTimes := formUploadRetryTimes
 	xl := xlog.NewWith(xlog.FromContextSafe(ctx).ReqId())
+	policy := p.retryPolicy(formUploadRetryTimes)
+	err = policy.Do(ctx, func(attempt int) error {
+		return p.putOnce(ctx, ret, uptoken, key, hasKey, dataReaderAt, size, extra, fileName, onProgress, policy, xl, attempt)
+	})
+	if err != nil && ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	if err == nil && onProgress != nil {
+		onProgress(size, size)
+	}
+	return err
+}
+
+// putOnce 进行一次表单上传，由 put 按重试策略重复调用
+func (p Uploader) putOnce(
+	ctx Context, ret interface{}, uptoken string, key string, hasKey bool, dataReaderAt io.ReaderAt, size int64,
+	extra *PutExtra, fileName string, onProgress func(fsize, uploaded int64), policy *retry.Policy, xl *xlog.Logger, attempt int) (err error) {
 
-lzRetry:
 	var data io.Reader = io.NewSectionReader(dataReaderAt, 0, size)
-	if extra.OnProgress != nil {
-		data = &readerWithProgress{reader: data, fsize: size, onProgress: extra.OnProgress}
+	if onProgress != nil {
+		data = &readerWithProgress{reader: data, fsize: size, onProgress: onProgress}
 	}
 
 	b := new(bytes.Buffer)
@@ -199,7 +214,7 @@ lzRetry:
 		}
 	})))
 	if err != nil {
-		failHostName(upHost)
+		p.hostPool().Fail(upHost)
 		return
 	}
 	req.Header.Set("Content-Type", contentType)
@@ -207,36 +222,29 @@ lzRetry:
 	if extra.Md5Trailer == nil {
 		req.ContentLength = bodyLen
 	}
+	start := p.startRequest(upHost)
 	resp, err := p.Conn.Do(ctx, req)
-	if err != nil {
-		if err == Canceled {
-			return
-		}
-		code := httputil.DetectCode(err)
-		if code == 509 {
-			failHostName(upHost)
-			elog.Warn(xl.ReqId(), "formUploadRetryLater:", err)
-			time.Sleep(time.Second * time.Duration(rand.Intn(9)+1))
-			goto lzRetry
-		} else if tryTimes > 1 && (code == 406 || code/100 != 4) {
-			failHostName(upHost)
-			tryTimes--
-			elog.Warn(xl.ReqId(), "formUploadRetry:", err)
-			time.Sleep(time.Second * 3)
-			goto lzRetry
-		}
-		return err
+	if err == nil {
+		err = rpc.CallRet(ctx, ret, resp)
 	}
-	err = rpc.CallRet(ctx, ret, resp)
-	if err != nil {
-		failHostName(upHost)
+	p.observeRequest("form_upload", upHost, attempt, start, err)
+	if err == nil && size > 0 {
+		p.metrics().AddBytes(metrics.Up, size)
+	}
+	if err != nil && policy.ShouldRetry(err) {
+		p.hostPool().Fail(upHost)
+		elog.Warn(xl.ReqId(), "formUploadRetry:", err)
 	} else {
-		succeedHostName(upHost)
+		p.hostPool().Succeed(upHost)
 	}
-	if extra.OnProgress != nil {
-		extra.OnProgress(size, size)
+	return
+}
+
+func (p Uploader) progressFunc(extra *PutExtra) func(fsize, uploaded int64) {
+	if extra != nil && extra.OnProgress != nil {
+		return extra.OnProgress
 	}
-	return err
+	return p.OnProgress
 }
 
 // ----------------------------------------------------------
@@ -389,24 +397,36 @@ func (p Uploader) put2(ctx Context, ret interface{}, uptoken, key string, data i
 		url += "/key/" + base64.URLEncoding.EncodeToString([]byte(key))
 	}
 	elog.Debug("Put2", url)
-	req, err := http.NewRequest("POST", url, io.NewSectionReader(data, 0, size))
+	var body io.Reader = io.NewSectionReader(data, 0, size)
+	onProgress := p.progressFunc(extra)
+	if onProgress != nil {
+		body = &readerWithProgress{reader: body, fsize: size, onProgress: onProgress}
+	}
+	req, err := http.NewRequest("POST", url, body)
 	if err != nil {
-		failHostName(upHost)
+		p.hostPool().Fail(upHost)
 		return err
 	}
 	req.Header.Set("Content-Type", "application/octet-stream")
 	req.Header.Set("Authorization", "UpToken "+uptoken)
 	req.ContentLength = size
+	start := p.startRequest(upHost)
 	resp, err := p.Conn.Do(ctx, req)
 	if err != nil {
-		failHostName(upHost)
+		p.observeRequest("put", upHost, 0, start, err)
+		p.hostPool().Fail(upHost)
 		return err
 	}
 	err = rpc.CallRet(ctx, ret, resp)
+	p.observeRequest("put", upHost, 0, start, err)
 	if err != nil {
-		failHostName(upHost)
+		p.hostPool().Fail(upHost)
 		return err
 	}
-	succeedHostName(upHost)
+	p.hostPool().Succeed(upHost)
+	p.metrics().AddBytes(metrics.Up, size)
+	if onProgress != nil {
+		onProgress(size, size)
+	}
 	return nil
 }
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/syncdata/operation"
+)
+
+func main() {
+	cf := flag.String("c", "cfg.toml", "config")
+	dir := flag.String("d", ".", "local directory")
+	prefix := flag.String("p", "", "remote key prefix")
+	down := flag.Bool("down", false, "download the remote prefix into the local directory")
+	del := flag.Bool("delete", false, "delete files which do not exist in the source")
+	dryRun := flag.Bool("dry-run", false, "print the plan without executing it")
+	flag.Parse()
+
+	x, err := operation.Load(*cf)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	opts := operation.SyncOptions{Delete: *del, DryRun: *dryRun}
+	var ops []operation.SyncOp
+	if *down {
+		ops, err = operation.NewDownloader(x).DownloadPrefix(*prefix, *dir, opts)
+	} else {
+		ops, err = operation.NewSyncer(x).Sync(*dir, *prefix, opts)
+	}
+	for _, op := range ops {
+		if op.Err != nil {
+			fmt.Println("FAIL", op.String(), op.Err)
+		} else {
+			fmt.Println(op.String())
+		}
+	}
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if *dryRun {
+		fmt.Printf("%d operations planned\n", len(ops))
+	}
+}
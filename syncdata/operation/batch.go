@@ -0,0 +1,213 @@
+package operation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/api.v7/kodo"
+	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/x/httputil.v1"
+	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/x/slog.v1"
+)
+
+const batchLimit = 1000
+
+// KeyPair 描述一次复制或者移动
+type KeyPair struct {
+	Src        string `json:"src"`
+	Dest       string `json:"dest"`
+	DestBucket string `json:"dest_bucket,omitempty"` // 为空表示和源文件在同一个 bucket
+}
+
+// BatchResult 是批量操作中单个文件的结果，顺序和输入一致
+type BatchResult struct {
+	Key   string `json:"key"`
+	Dest  string `json:"dest,omitempty"`
+	Code  int    `json:"code"`
+	Error string `json:"error,omitempty"`
+}
+
+func (r *BatchResult) OK() bool {
+	return r.Code == 200
+}
+
+// DeleteMany 批量删除 keys，按每批 1000 个并发执行，只重试失败的文件
+func (l *Lister) DeleteMany(keys []string) ([]BatchResult, error) {
+	return l.DeleteManyWithContext(context.Background(), keys)
+}
+
+// DeleteManyWithContext 和 DeleteMany 相同，ctx 结束时会中断正在进行的请求和重试等待
+func (l *Lister) DeleteManyWithContext(ctx context.Context, keys []string) ([]BatchResult, error) {
+	return l.deleteMany(ctx, keys, nil)
+}
+
+func (l *Lister) deleteMany(ctx context.Context, keys []string, onProgress func(done, total int)) ([]BatchResult, error) {
+	ops := make([]string, len(keys))
+	results := make([]BatchResult, len(keys))
+	for i, key := range keys {
+		ops[i] = kodo.URIDelete(l.bucket, key)
+		results[i].Key = key
+	}
+	return results, l.batch(ctx, "delete", ops, results, onProgress)
+}
+
+// CopyMany 批量复制文件，目标文件已经存在时对应的结果为 614
+func (l *Lister) CopyMany(pairs []KeyPair) ([]BatchResult, error) {
+	return l.CopyManyWithContext(context.Background(), pairs)
+}
+
+// CopyManyWithContext 和 CopyMany 相同，ctx 结束时会中断正在进行的请求和重试等待
+func (l *Lister) CopyManyWithContext(ctx context.Context, pairs []KeyPair) ([]BatchResult, error) {
+	return l.batchPairs(ctx, "copy", kodo.URICopy, pairs, nil)
+}
+
+// MoveMany 批量移动文件，可以移动到其他 bucket
+func (l *Lister) MoveMany(pairs []KeyPair) ([]BatchResult, error) {
+	return l.MoveManyWithContext(context.Background(), pairs)
+}
+
+// MoveManyWithContext 和 MoveMany 相同，ctx 结束时会中断正在进行的请求和重试等待
+func (l *Lister) MoveManyWithContext(ctx context.Context, pairs []KeyPair) ([]BatchResult, error) {
+	return l.batchPairs(ctx, "move", kodo.URIMove, pairs, nil)
+}
+
+func (l *Lister) batchPairs(ctx context.Context, name string, uri func(bucketSrc, keySrc, bucketDest, keyDest string) string, pairs []KeyPair,
+	onProgress func(done, total int)) ([]BatchResult, error) {
+	ops := make([]string, len(pairs))
+	results := make([]BatchResult, len(pairs))
+	for i, pair := range pairs {
+		destBucket := pair.DestBucket
+		if destBucket == "" {
+			destBucket = l.bucket
+		}
+		ops[i] = uri(l.bucket, pair.Src, destBucket, pair.Dest)
+		results[i].Key, results[i].Dest = pair.Src, pair.Dest
+	}
+	return results, l.batch(ctx, name, ops, results, onProgress)
+}
+
+// batch 执行 ops 并把每个操作的结果写入 results，可重试的失败按 batchRetry 策略重试，每轮只重试失败的操作。
+// onProgress 不为空时，每批完成后通知已经得到最终结果的操作数。ctx 结束时停止重试并返回 ctx 的错误，
+// 其他情况下有操作失败时返回汇总的错误
+func (l *Lister) batch(ctx context.Context, name string, ops []string, results []BatchResult, onProgress func(done, total int)) error {
+	var (
+		m    sync.Mutex
+		done int
+	)
+	report := func(n int) {
+		if onProgress == nil || n == 0 {
+			return
+		}
+		m.Lock()
+		defer m.Unlock()
+		done += n
+		onProgress(done, len(ops))
+	}
+
+	pending := make([]int, len(ops))
+	for i := range pending {
+		pending[i] = i
+	}
+	err := l.batchRetry.Do(ctx, func(round int) error {
+		if round > 0 {
+			elog.Log(slog.LevelInfo, "retry", slog.Op(name), slog.F("round", round), slog.F("pending", len(pending)))
+			collector(l.metrics).IncRetry("batch")
+		}
+		l.batchRound(ctx, ops, results, pending, report)
+		retry := pending[:0]
+		for _, i := range pending {
+			if l.batchRetry.ShouldRetryCode(results[i].Code) {
+				retry = append(retry, i)
+			}
+		}
+		if pending = retry; len(pending) == 0 {
+			return nil
+		}
+		// 用第一个待重试的结果决定这一轮是否重试以及等待时间
+		first := results[pending[0]]
+		return &Error{Op: name, Key: first.Key, Code: first.Code, Err: errors.New(first.Error)}
+	})
+	if err != nil && ctx.Err() != nil {
+		return wrapError(name, "", "", ctx.Err())
+	}
+	// 重试次数用完后剩下的操作也得到了最终结果
+	report(len(pending))
+
+	var failed int
+	var first *BatchResult
+	for i := range results {
+		if !results[i].OK() {
+			if first == nil {
+				first = &results[i]
+			}
+			failed++
+		}
+	}
+	if failed > 0 {
+		return &Error{Op: name, Key: first.Key, Code: first.Code,
+			Err: fmt.Errorf("%d of %d failed, first: %s", failed, len(results), first.Error)}
+	}
+	return nil
+}
+
+// batchRound 把 pending 中的操作按 batchLimit 分批，以 batchConcurrency 的并发度执行一轮，
+// 每批完成后用不需要重试的操作数调用 report
+func (l *Lister) batchRound(ctx context.Context, ops []string, results []BatchResult, pending []int, report func(n int)) {
+	var wg sync.WaitGroup
+	ch := make(chan []int)
+	for i := 0; i < l.batchConcurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for indexes := range ch {
+				l.batchOnce(ctx, ops, results, indexes)
+				n := 0
+				for _, i := range indexes {
+					if !l.batchRetry.ShouldRetryCode(results[i].Code) {
+						n++
+					}
+				}
+				report(n)
+			}
+		}()
+	}
+	for i := 0; i < len(pending); i += batchLimit {
+		end := i + batchLimit
+		if end > len(pending) {
+			end = len(pending)
+		}
+		ch <- pending[i:end]
+	}
+	close(ch)
+	wg.Wait()
+}
+
+func (l *Lister) batchOnce(ctx context.Context, ops []string, results []BatchResult, indexes []int) {
+	batchOps := make([]string, len(indexes))
+	for j, i := range indexes {
+		batchOps[j] = ops[i]
+	}
+	host := l.nextRsHost()
+	bucket := l.newBucket(host, "")
+	var rets []kodo.BatchItemRet
+	start := startRequest(l.rsSelector, host)
+	err := bucket.Conn.Batch(ctx, &rets, batchOps)
+	observeRequest(l.metrics, l.rsSelector, "batch", host, 0, start, err)
+	if err == nil && len(rets) != len(batchOps) {
+		err = fmt.Errorf("batch returns %d results for %d ops", len(rets), len(batchOps))
+	}
+	if err != nil {
+		l.pool.Fail(host)
+		elog.Log(slog.LevelInfo, "batch failed", slog.Op("batch"), slog.Host(host), slog.F("ops", len(batchOps)), slog.Err(err))
+		code := httputil.DetectCode(err)
+		for _, i := range indexes {
+			results[i].Code, results[i].Error = code, err.Error()
+		}
+		return
+	}
+	l.pool.Succeed(host)
+	for j, i := range indexes {
+		results[i].Code, results[i].Error = rets[j].Code, rets[j].Error
+	}
+}
@@ -0,0 +1,166 @@
+package operation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestBatchLister(rs *fakeRsServer, attempts int) *Lister {
+	c := newTestConfig(rs)
+	c.Retry.MaxAttempts = attempts
+	c.BatchConcurrency = 2
+	return NewLister(c)
+}
+
+func sortedBatches(rs *fakeRsServer) string {
+	rs.m.Lock()
+	defer rs.m.Unlock()
+	batches := append([]int(nil), rs.batches...)
+	sort.Sort(sort.Reverse(sort.IntSlice(batches)))
+	return fmt.Sprint(batches)
+}
+
+func TestDeleteMany(t *testing.T) {
+	rs := newFakeRsServer()
+	defer rs.Close()
+	keys := make([]string, 2*batchLimit+500)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key-%04d", i)
+		if i%25 != 0 {
+			rs.put(keys[i], []byte("data"))
+		}
+	}
+	l := newTestBatchLister(rs, 3)
+
+	results, err := l.DeleteMany(keys)
+	if got := sortedBatches(rs); got != "[1000 1000 500]" {
+		t.Fatal("keys should be split by batch limit:", got)
+	}
+	var e *Error
+	if !errors.As(err, &e) || e.Code != 612 || !strings.Contains(err.Error(), "100 of 2500") {
+		t.Fatal("unexpected error:", err)
+	}
+	for i, r := range results {
+		if r.Key != keys[i] {
+			t.Fatal("results should be in the order of keys:", i, r.Key)
+		}
+		if expected := map[bool]int{true: 612, false: 200}[i%25 == 0]; r.Code != expected {
+			t.Fatalf("unexpected result: %+v", r)
+		}
+	}
+	if len(rs.keys()) != 0 {
+		t.Fatal("all files should be deleted:", len(rs.keys()))
+	}
+}
+
+func TestBatchRetryFailedItems(t *testing.T) {
+	rs := newFakeRsServer()
+	defer rs.Close()
+	for i := 0; i < 10; i++ {
+		rs.put(fmt.Sprint("key-", i), []byte("data"))
+	}
+	flaky := "/delete/" + encodedEntry("key-3")
+	rs.opHook = func(op string, n int) int {
+		if op == flaky && n < 3 {
+			return 503
+		}
+		return 0
+	}
+	l := newTestBatchLister(rs, 3)
+
+	keys := make([]string, 10)
+	for i := range keys {
+		keys[i] = fmt.Sprint("key-", i)
+	}
+	var progress []int
+	results, err := l.deleteMany(context.Background(), keys, func(done, total int) {
+		if total != 10 {
+			t.Error("unexpected total:", total)
+		}
+		progress = append(progress, done)
+	})
+	if err != nil {
+		t.Fatal("delete should succeed after retry:", err, results)
+	}
+	if fmt.Sprint(rs.batches) != "[10 1 1]" {
+		t.Fatal("only failed items should be retried:", rs.batches)
+	}
+	if fmt.Sprint(progress) != "[9 10]" {
+		t.Fatal("unexpected progress:", progress)
+	}
+}
+
+func TestBatchRetryLimit(t *testing.T) {
+	rs := newFakeRsServer()
+	defer rs.Close()
+	rs.put("a", []byte("a"))
+	rs.failBatches = 5
+	l := newTestBatchLister(rs, 2)
+
+	results, err := l.DeleteMany([]string{"a"})
+	if !errors.Is(err, ErrServer) || results[0].Code != 503 {
+		t.Fatal("expect server error after all rounds:", err, results)
+	}
+	if len(rs.batches) != 2 {
+		t.Fatal("batch should be tried twice:", rs.batches)
+	}
+
+	rs.failBatches = 1
+	if results, err = l.DeleteMany([]string{"a"}); err != nil || !results[0].OK() {
+		t.Fatal("whole batch failure should be retried:", err, results)
+	}
+}
+
+func TestBatchRetryContext(t *testing.T) {
+	rs := newFakeRsServer()
+	defer rs.Close()
+	rs.put("a", []byte("a"))
+	rs.failBatches = 5
+	c := newTestConfig(rs)
+	c.Retry = RetryConfig{MaxAttempts: 3, InitialBackoff: 60 * 1000}
+	l := NewLister(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := l.DeleteManyWithContext(ctx, []string{"a"})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("expect deadline exceeded, but got:", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second || len(rs.batches) != 1 {
+		t.Fatal("batch retry should be interrupted by ctx:", elapsed, rs.batches)
+	}
+}
+
+func TestCopyAndMoveMany(t *testing.T) {
+	rs := newFakeRsServer()
+	defer rs.Close()
+	rs.put("a", []byte("a"))
+	rs.put("b", []byte("b"))
+	rs.put("exists", []byte("exists"))
+	l := newTestBatchLister(rs, 3)
+
+	results, err := l.CopyMany([]KeyPair{{Src: "a", Dest: "a2"}, {Src: "b", Dest: "exists"}, {Src: "a", Dest: "a3", DestBucket: "other"}})
+	if !errors.Is(err, ErrAlreadyExists) {
+		t.Fatal("expect already exists error:", err)
+	}
+	if !results[0].OK() || results[1].Code != 614 || results[1].Dest != "exists" || !results[2].OK() {
+		t.Fatal("unexpected copy results:", results)
+	}
+	if n := rs.ops["/copy/"+encodedEntry("b")+"/"+encodedEntry("exists")]; n != 1 {
+		t.Fatal("614 should not be retried:", n)
+	}
+
+	if results, err = l.MoveMany([]KeyPair{{Src: "a2", Dest: "moved"}}); err != nil || !results[0].OK() {
+		t.Fatal("move failed:", err, results)
+	}
+	expected := "[bucket:a bucket:b bucket:exists bucket:moved other:a3]"
+	if got := fmt.Sprint(rs.keys()); got != expected {
+		t.Fatalf("unexpected files:\n%s\nexpect:\n%s", got, expected)
+	}
+}
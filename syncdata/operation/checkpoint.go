@@ -0,0 +1,118 @@
+package operation
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	q "github.com/ufilesdk-dev/us3-qiniu-go-sdk/api.v8/kodocli"
+	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/x/slog.v1"
+)
+
+// checkpointStore 把分片上传的进度保存在本地目录中，进程重启后可以继续上传
+type checkpointStore struct {
+	dir string
+}
+
+type checkpoint struct {
+	UploadId string   `json:"upload_id"`
+	PartSize int64    `json:"part_size"`
+	Parts    []q.Part `json:"parts"`
+
+	m    sync.Mutex
+	path string
+}
+
+func newCheckpointStore(dir string) *checkpointStore {
+	if dir == "" {
+		return nil
+	}
+	return &checkpointStore{dir: dir}
+}
+
+func (s *checkpointStore) pathOf(bucket, key string, fInfo os.FileInfo) string {
+	id := fmt.Sprintf("%s:%s:%d:%d", bucket, key, fInfo.Size(), fInfo.ModTime().UnixNano())
+	sum := sha1.Sum([]byte(id))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// load 返回之前保存的上传进度，如果不存在或者分片大小已经改变则返回 nil
+func (s *checkpointStore) load(bucket, key string, fInfo os.FileInfo, partSize int64) *checkpoint {
+	path := s.pathOf(bucket, key, fInfo)
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			elog.Log(slog.LevelWarn, "read checkpoint failed", slog.Op("checkpoint"), slog.Key(key), slog.F("path", path), slog.Err(err))
+		}
+		return nil
+	}
+	var cp checkpoint
+	if err = json.Unmarshal(raw, &cp); err != nil || cp.UploadId == "" || cp.PartSize != partSize {
+		elog.Log(slog.LevelWarn, "drop invalid checkpoint", slog.Op("checkpoint"), slog.Key(key), slog.F("path", path), slog.Err(err))
+		os.Remove(path)
+		return nil
+	}
+	cp.path = path
+	return &cp
+}
+
+// create 创建并保存一个新的上传进度，保存失败时返回 nil 和错误
+func (s *checkpointStore) create(bucket, key string, fInfo os.FileInfo, partSize int64, uploadId string) (*checkpoint, error) {
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return nil, err
+	}
+	cp := &checkpoint{
+		UploadId: uploadId,
+		PartSize: partSize,
+		path:     s.pathOf(bucket, key, fInfo),
+	}
+	cp.m.Lock()
+	defer cp.m.Unlock()
+	if err := cp.save(); err != nil {
+		return nil, err
+	}
+	return cp, nil
+}
+
+func (cp *checkpoint) parts() []q.Part {
+	cp.m.Lock()
+	defer cp.m.Unlock()
+	parts := make([]q.Part, len(cp.Parts))
+	copy(parts, cp.Parts)
+	return parts
+}
+
+func (cp *checkpoint) addPart(partNum int, etag string) error {
+	cp.m.Lock()
+	defer cp.m.Unlock()
+	cp.Parts = append(cp.Parts, q.Part{PartNumber: partNum, Etag: etag})
+	sort.Slice(cp.Parts, func(i, j int) bool {
+		return cp.Parts[i].PartNumber < cp.Parts[j].PartNumber
+	})
+	return cp.save()
+}
+
+// save 先写临时文件再改名，避免进程崩溃时留下不完整的 checkpoint
+func (cp *checkpoint) save() error {
+	b, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	tmp := cp.path + ".tmp"
+	if err = ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, cp.path)
+}
+
+func (cp *checkpoint) remove() {
+	if err := os.Remove(cp.path); err != nil && !os.IsNotExist(err) {
+		elog.Log(slog.LevelWarn, "remove checkpoint failed", slog.Op("checkpoint"), slog.F("path", cp.path), slog.Err(err))
+	}
+}
@@ -0,0 +1,244 @@
+package operation
+
+import (
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	q "github.com/ufilesdk-dev/us3-qiniu-go-sdk/api.v8/kodocli"
+)
+
+// fakeUpServer 模拟分片上传接口，failPart 对应的分片返回 failCode
+type fakeUpServer struct {
+	*httptest.Server
+
+	m         sync.Mutex
+	inits     int
+	uploadId  string
+	uploaded  []int
+	completed []q.Part
+	aborted   int
+	failPart  int
+	failCode  int
+	staleIds  map[string]bool
+}
+
+func newFakeUpServer() *fakeUpServer {
+	s := &fakeUpServer{uploadId: "upload-1", staleIds: map[string]bool{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+func (s *fakeUpServer) serve(w http.ResponseWriter, req *http.Request) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	// /buckets/<bucket>/objects/<key>/uploads[/<uploadId>[/<partNum>]]
+	segs := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	if len(segs) > 5 && s.staleIds[segs[5]] {
+		w.WriteHeader(612)
+		w.Write([]byte(`{"error":"no such uploadId"}`))
+		return
+	}
+	switch {
+	case req.Method == "POST" && len(segs) == 5:
+		s.inits++
+		fmt.Fprintf(w, `{"uploadId":%q}`, s.uploadId)
+	case req.Method == "PUT" && len(segs) == 7:
+		partNum, _ := strconv.Atoi(segs[6])
+		h := md5.New()
+		io.Copy(h, req.Body)
+		if partNum == s.failPart {
+			w.WriteHeader(s.failCode)
+			w.Write([]byte(`{"error":"injected"}`))
+			return
+		}
+		s.uploaded = append(s.uploaded, partNum)
+		fmt.Fprintf(w, `{"etag":"etag-%d","md5":"%x"}`, partNum, h.Sum(nil))
+	case req.Method == "POST" && len(segs) == 6:
+		var mp q.CompleteMultipart
+		json.NewDecoder(req.Body).Decode(&mp)
+		s.completed = mp.Parts
+		w.Write([]byte(`{}`))
+	case req.Method == "DELETE":
+		s.aborted++
+		w.Write([]byte(`{}`))
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+func writeTempFile(t *testing.T, size int) string {
+	path := filepath.Join(t.TempDir(), "data")
+	if err := ioutil.WriteFile(path, make([]byte, size), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func checkpointFiles(t *testing.T, dir string) []string {
+	names, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return names
+}
+
+func TestUploadResumeFromCheckpoint(t *testing.T) {
+	srv := newFakeUpServer()
+	defer srv.Close()
+	srv.failPart, srv.failCode = 3, http.StatusInternalServerError
+
+	dir := t.TempDir()
+	uploader := NewUploader(&Config{
+		UpHosts:       []string{srv.URL},
+		Bucket:        "bucket",
+		Ak:            "ak",
+		Sk:            "sk",
+		UpConcurrency: 1,
+		CheckpointDir: dir,
+		Retry:         RetryConfig{MaxAttempts: 1},
+	})
+	file := writeTempFile(t, 3*4*1024*1024)
+
+	if err := uploader.Upload(file, "key"); err == nil {
+		t.Fatal("expect upload to fail")
+	}
+	if srv.aborted != 0 {
+		t.Fatal("parts should be kept for resuming")
+	}
+	cps := checkpointFiles(t, dir)
+	if len(cps) != 1 {
+		t.Fatal("expect one checkpoint, but got:", cps)
+	}
+	var cp checkpoint
+	raw, _ := ioutil.ReadFile(cps[0])
+	if err := json.Unmarshal(raw, &cp); err != nil || cp.UploadId != "upload-1" || len(cp.Parts) != 2 {
+		t.Fatalf("unexpected checkpoint: %s, %v", raw, err)
+	}
+
+	srv.failPart = 0
+	srv.uploaded = nil
+	if err := uploader.Upload(file, "key"); err != nil {
+		t.Fatal("resume upload failed:", err)
+	}
+	if srv.inits != 1 {
+		t.Fatal("upload should be resumed instead of restarted, inits:", srv.inits)
+	}
+	if fmt.Sprint(srv.uploaded) != "[3]" {
+		t.Fatal("only the missing part should be uploaded, but got:", srv.uploaded)
+	}
+	if len(srv.completed) != 3 || srv.completed[0].Etag != "etag-1" || srv.completed[2].Etag != "etag-3" {
+		t.Fatal("unexpected complete parts:", srv.completed)
+	}
+	if cps = checkpointFiles(t, dir); len(cps) != 0 {
+		t.Fatal("checkpoint should be removed after upload, but got:", cps)
+	}
+}
+
+func TestUploadExpiredCheckpoint(t *testing.T) {
+	srv := newFakeUpServer()
+	defer srv.Close()
+	srv.staleIds["stale"] = true
+
+	dir := t.TempDir()
+	uploader := NewUploader(&Config{
+		UpHosts:       []string{srv.URL},
+		Bucket:        "bucket",
+		Ak:            "ak",
+		Sk:            "sk",
+		CheckpointDir: dir,
+		Retry:         RetryConfig{MaxAttempts: 2},
+	})
+	file := writeTempFile(t, 2*4*1024*1024)
+	fInfo, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cp, err := uploader.checkpoints.create("bucket", "key", fInfo, uploader.partSize, "stale")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cp.addPart(1, "etag-stale")
+
+	if err = uploader.Upload(file, "key"); err != nil {
+		t.Fatal("upload should restart with a new uploadId:", err)
+	}
+	if srv.inits != 1 || len(srv.uploaded) != 2 {
+		t.Fatalf("unexpected upload, inits: %d, uploaded: %v", srv.inits, srv.uploaded)
+	}
+	if cps := checkpointFiles(t, dir); len(cps) != 0 {
+		t.Fatal("checkpoint should be removed after upload, but got:", cps)
+	}
+}
+
+func TestCheckpointLoad(t *testing.T) {
+	store := newCheckpointStore(t.TempDir())
+	file := writeTempFile(t, 10)
+	fInfo, _ := os.Stat(file)
+
+	if cp := store.load("bucket", "key", fInfo, 4); cp != nil {
+		t.Fatal("expect no checkpoint")
+	}
+	cp, err := store.create("bucket", "key", fInfo, 4, "upload-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cp.addPart(2, "etag-2")
+	cp.addPart(1, "etag-1")
+
+	loaded := store.load("bucket", "key", fInfo, 4)
+	if loaded == nil || loaded.UploadId != "upload-1" || fmt.Sprint(loaded.parts()) != "[{1 etag-1} {2 etag-2}]" {
+		t.Fatal("unexpected checkpoint:", loaded)
+	}
+	if store.load("bucket", "other", fInfo, 4) != nil {
+		t.Fatal("checkpoint of another key should not be loaded")
+	}
+	if store.load("bucket", "key", fInfo, 8) != nil {
+		t.Fatal("checkpoint with another part size should be dropped")
+	}
+	if store.load("bucket", "key", fInfo, 4) != nil {
+		t.Fatal("dropped checkpoint should be removed")
+	}
+}
+
+func TestUploadCheckpointSaveFailure(t *testing.T) {
+	srv := newFakeUpServer()
+	defer srv.Close()
+
+	blocker := writeTempFile(t, 1)
+	uploader := NewUploader(&Config{
+		UpHosts:       []string{srv.URL},
+		Bucket:        "bucket",
+		Ak:            "ak",
+		Sk:            "sk",
+		CheckpointDir: filepath.Join(blocker, "checkpoints"), // 父路径是文件，无法创建目录
+		Retry:         RetryConfig{MaxAttempts: 1},
+	})
+	if err := uploader.Upload(writeTempFile(t, 2*4*1024*1024), "key"); err != nil {
+		t.Fatal("upload should fall back to upload without checkpoint:", err)
+	}
+	if srv.inits != 2 || srv.aborted != 1 || len(srv.completed) != 2 {
+		t.Fatalf("uploadId without checkpoint should be aborted, inits: %d, aborted: %d, completed: %v", srv.inits, srv.aborted, srv.completed)
+	}
+
+	dir := t.TempDir()
+	store := newCheckpointStore(dir)
+	fInfo, _ := os.Stat(blocker)
+	if err := os.Mkdir(store.pathOf("bucket", "key", fInfo)+".tmp", 0700); err != nil {
+		t.Fatal(err)
+	}
+	if cp, err := store.create("bucket", "key", fInfo, 4, "upload-1"); cp != nil || err == nil {
+		t.Fatal("checkpoint should not be returned when it can not be saved:", cp, err)
+	}
+}
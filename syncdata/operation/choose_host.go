@@ -1,11 +1,13 @@
 package operation
 
 import (
-	"container/ring"
 	"math/rand"
 	"os"
 	"sync"
 	"time"
+
+	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/x/hostpool.v1"
+	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/x/slog.v1"
 )
 
 var (
@@ -23,61 +25,42 @@ func shuffleHosts(hosts []string) {
 	}
 }
 
-type hostsScore struct {
-	m      sync.Mutex
-	scores *ring.Ring
+// newHostSelector 按 host_selector 配置创建节点选择策略，未配置或者配置无效时使用轮询
+func newHostSelector(c *Config) hostpool.Selector {
+	if c.HostSelector != "" && c.HostSelector != hostpool.RoundRobin {
+		sel, err := hostpool.New(c.HostSelector)
+		if err == nil {
+			return sel
+		}
+		elog.Log(slog.LevelWarn, "invalid host selector, use round robin", slog.F("host_selector", c.HostSelector), slog.Err(err))
+	}
+	return hostpool.NewRoundRobin(MaxFindHostsPrecent)
 }
 
 var (
 	MaxContinuousFailureTimes    = 5
 	MaxContinuousFailureDuration = 1 * time.Minute
 	MaxFindHostsPrecent          = 50
-	hostsScores                  sync.Map
-)
-
-func isHostNameValid(hostName string) bool {
-	if hs, ok := hostsScores.Load(hostName); ok {
-		return hs.(*hostsScore).isValid()
-	}
-	return true
-}
 
-func failHostName(hostName string) {
-	hs, _ := hostsScores.LoadOrStore(hostName, newHostsScore())
-	hs.(*hostsScore).fail()
-}
-
-func succeedHostName(hostName string) {
-	hostsScores.Delete(hostName)
-}
-
-func newHostsScore() *hostsScore {
-	return &hostsScore{
-		scores: ring.New(MaxContinuousFailureTimes),
-	}
-}
-
-func (hs *hostsScore) isValid() bool {
-	hs.m.Lock()
-	defer hs.m.Unlock()
+	hostPoolLock sync.Mutex
+)
 
-	for i := 0; i < hs.scores.Len(); i++ {
-		if t, ok := hs.scores.Value.(time.Time); ok {
-			if t.Add(MaxContinuousFailureDuration).Before(time.Now()) {
-				return true
-			}
-		} else {
-			return true
+// hostPool 返回 c 使用的节点 Pool，没有设置时按 MaxContinuousFailureTimes 和 MaxContinuousFailureDuration 创建
+// 并保存到 c.HostPool，使由同一个 Config 创建的 Uploader、Downloader、Lister 和 Queryer 共享节点的健康状态：
+// MaxContinuousFailureDuration 内连续失败 MaxContinuousFailureTimes 次的节点被熔断，
+// MaxContinuousFailureDuration 后或者探测成功后进入半开状态
+func (c *Config) hostPool() *hostpool.Pool {
+	hostPoolLock.Lock()
+	defer hostPoolLock.Unlock()
+	if c.HostPool == nil {
+		c.HostPool = hostpool.NewPool(hostpool.BreakerConfig{
+			MaxFailures: MaxContinuousFailureTimes,
+			Window:      MaxContinuousFailureDuration,
+			OpenTimeout: MaxContinuousFailureDuration,
+		})
+		if c.Metrics != nil {
+			c.HostPool.OnStateChange = c.Metrics.SetHostHealthy
 		}
-		hs.scores = hs.scores.Next()
 	}
-	return false
-}
-
-func (hs *hostsScore) fail() {
-	hs.m.Lock()
-	defer hs.m.Unlock()
-
-	hs.scores.Value = time.Now()
-	hs.scores = hs.scores.Next()
+	return c.HostPool
 }
@@ -3,8 +3,8 @@ package operation
 import (
 	"encoding/json"
 	"errors"
-	"fmt"
 	"io/ioutil"
+	"net/http"
 	"os"
 	"path"
 	"path/filepath"
@@ -13,6 +13,9 @@ import (
 
 	"github.com/fsnotify/fsnotify"
 	"github.com/pelletier/go-toml"
+	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/x/hostpool.v1"
+	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/x/metrics.v1"
+	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/x/slog.v1"
 )
 
 type Config struct {
@@ -27,12 +30,42 @@ type Config struct {
 	Delete        bool     `json:"delete" toml:"delete"`
 	UpConcurrency int      `json:"up_concurrency" toml:"up_concurrency"`
 
-	DownPath string `json:"down_path" toml:"down_path"`
-	Sim      bool   `json:"sim" toml:"sim"`
+	SyncConcurrency  int `json:"sync_concurrency" toml:"sync_concurrency"`
+	BatchConcurrency int `json:"batch_concurrency" toml:"batch_concurrency"`
+
+	DownPath        string `json:"down_path" toml:"down_path"`
+	Sim             bool   `json:"sim" toml:"sim"`
+	DownConcurrency int    `json:"down_concurrency" toml:"down_concurrency"`
+	DownPartSize    int64  `json:"down_part" toml:"down_part"`
+	VerifyHash      bool   `json:"verify_hash" toml:"verify_hash"`
 
 	IoHosts []string `json:"io_hosts" toml:"io_hosts"`
 
 	UcHosts []string `json:"uc_hosts" toml:"uc_hosts"`
+
+	CheckpointDir string `json:"checkpoint_dir" toml:"checkpoint_dir"`
+
+	// HostSelector 是选择节点的策略：round_robin（默认）、ewma、least_inflight 或者 p2c
+	HostSelector string `json:"host_selector" toml:"host_selector"`
+
+	// UseHTTPS 为 true 时从 uc 查询到的节点使用 https 访问
+	UseHTTPS bool      `json:"use_https" toml:"use_https"`
+	TLS      TLSConfig `json:"tls" toml:"tls"`
+
+	DownTransport TransportConfig `json:"down_transport" toml:"down_transport"`
+	UpTransport   TransportConfig `json:"up_transport" toml:"up_transport"`
+	UcTransport   TransportConfig `json:"uc_transport" toml:"uc_transport"`
+	// HostPool 记录节点的健康状态，为空时在第一次使用时创建。由同一个 Config 创建的对象共享同一个 HostPool，
+	// 不同的 Config（例如访问不同区域）使用各自的 HostPool，互不影响
+	HostPool *hostpool.Pool `json:"-" toml:"-"`
+	// Metrics 接收由这个 Config 创建的对象上报的监控数据，为空时上报到 metrics.Default()
+	Metrics metrics.Collector `json:"-" toml:"-"`
+
+	Filter FilterConfig `json:"filter" toml:"filter"`
+	Retry  RetryConfig  `json:"retry" toml:"retry"`
+	Probe  ProbeConfig  `json:"probe" toml:"probe"`
+
+	transports map[string]http.RoundTripper // cachedTransport 按配置缓存的 Transport
 }
 
 func dupStrings(s []string) []string {
@@ -59,6 +92,20 @@ func Load(file string) (*Config, error) {
 	} else {
 		return nil, errors.New("configuration format invalid!")
 	}
+	if err == nil {
+		_, err = NewFilter(&configuration.Filter)
+	}
+	if err == nil && configuration.HostSelector != "" {
+		_, err = hostpool.New(configuration.HostSelector)
+	}
+	if err == nil {
+		_, err = configuration.TLS.tlsConfig()
+	}
+	for _, tc := range []*TransportConfig{&configuration.DownTransport, &configuration.UpTransport, &configuration.UcTransport} {
+		if err == nil {
+			err = tc.validate()
+		}
+	}
 
 	return &configuration, err
 }
@@ -69,7 +116,7 @@ var confLock sync.Mutex
 func getConf() *Config {
 	up := os.Getenv("US3")
 	if up == "" {
-              fmt.Println("Getenv no US3")
+		elog.Log(slog.LevelWarn, "no config file", slog.F("env", "US3"))
 		return nil
 	}
 	confLock.Lock()
@@ -120,9 +167,11 @@ func watchConfig(filename string) {
 						(currentConfigFile != "" && currentConfigFile != realConfigFile) {
 						realConfigFile = currentConfigFile
 						c, err := Load(realConfigFile)
-						fmt.Printf("re reading config file: error %v\n", err)
 						if err == nil {
+							elog.Log(slog.LevelInfo, "config reloaded", slog.F("file", realConfigFile))
 							g_conf = c
+						} else {
+							elog.Log(slog.LevelError, "reload config failed", slog.F("file", realConfigFile), slog.Err(err))
 						}
 					} else if filepath.Clean(event.Name) == configFile &&
 						event.Op&fsnotify.Remove&fsnotify.Remove != 0 {
@@ -132,7 +181,7 @@ func watchConfig(filename string) {
 
 				case err, ok := <-watcher.Errors:
 					if ok { // 'Errors' channel is not closed
-						fmt.Printf("watcher error: %v\n", err)
+						elog.Log(slog.LevelError, "config watcher failed", slog.Err(err))
 					}
 					eventsWG.Done()
 					return
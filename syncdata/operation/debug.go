@@ -5,30 +5,32 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+
+	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/x/slog.v1"
 )
 
 func StartSimulateErrorServer(_ *Config) {
 	httpCode := ":10801"
 	errSocket := ":10082"
-	elog.Info("start error simulate")
+	elog.Log(slog.LevelInfo, "start error simulate", slog.F("http", httpCode), slog.F("socket", errSocket))
 	go simulateConnectionError(errSocket)
 	simulateHttpCode(httpCode)
 }
 
 func handleConnection(conn net.Conn) {
-	elog.Info("close connection", conn.RemoteAddr())
+	elog.Log(slog.LevelInfo, "close connection", slog.F("remote", conn.RemoteAddr().String()))
 	conn.Close()
 }
 
 func simulateConnectionError(addr string) {
 	ln, err := net.Listen("tcp", addr)
 	if err != nil {
-		elog.Info("listen failed", err)
+		elog.Log(slog.LevelError, "listen failed", slog.F("addr", addr), slog.Err(err))
 	}
 	for {
 		conn, err := ln.Accept()
 		if err != nil {
-			elog.Info("accept error", err)
+			elog.Log(slog.LevelWarn, "accept error", slog.Err(err))
 		}
 		go handleConnection(conn)
 	}
@@ -41,9 +43,9 @@ func (d *debug) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	path := r.URL.Path
 	seps := strings.Split(strings.TrimPrefix(path, "/"), "/")
 	code, err := strconv.ParseUint(seps[0], 10, 64)
-	elog.Info("request is", path)
+	elog.Log(slog.LevelInfo, "simulate request", slog.F("path", path))
 	if err != nil {
-		elog.Info("parse code failed", seps[0], err)
+		elog.Log(slog.LevelWarn, "parse code failed", slog.F("code", seps[0]), slog.Err(err))
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
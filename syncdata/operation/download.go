@@ -1,42 +1,41 @@
 package operation
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"net"
 	"net/http"
 	"os"
 	"strconv"
 	"strings"
-	"sync/atomic"
-	"time"
 
 	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/api.v7/auth/qbox"
+	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/x/hostpool.v1"
+	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/x/metrics.v1"
+	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/x/retry.v1"
+	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/x/slog.v1"
 )
 
-var downloadClient = &http.Client{
-	Transport: &http.Transport{
-		Proxy: http.ProxyFromEnvironment,
-		DialContext: (&net.Dialer{
-			Timeout:   1 * time.Second,
-			KeepAlive: 30 * time.Second,
-			DualStack: true,
-		}).DialContext,
-		MaxIdleConns:          100,
-		IdleConnTimeout:       90 * time.Second,
-		TLSHandshakeTimeout:   10 * time.Second,
-		ExpectContinueTimeout: 1 * time.Second,
-	},
-	Timeout: 10 * time.Minute,
-}
-
 type Downloader struct {
-	bucket      string
-	ioHosts     []string
-	credentials *qbox.Mac
-	queryer     *Queryer
+	bucket          string
+	ioHosts         []string
+	credentials     *qbox.Mac
+	queryer         *Queryer
+	progress        ProgressListener
+	downConcurrency int
+	downPartSize    int64
+	lister          *Lister
+	verifyHash      bool
+	upPartSize      int64
+	syncConcurrency int
+	retry           *retry.Policy
+	selector        hostpool.Selector
+	pool            *hostpool.Pool
+	metrics         metrics.Collector
+	useHTTPS        bool
+	client          *http.Client
 }
 
 func NewDownloader(c *Config) *Downloader {
@@ -48,11 +47,28 @@ func NewDownloader(c *Config) *Downloader {
 		queryer = NewQueryer(c)
 	}
 
+	part := c.DownPartSize * 1024 * 1024
+	if part < 4*1024*1024 {
+		part = 4 * 1024 * 1024
+	}
+
 	downloader := Downloader{
-		bucket:      c.Bucket,
-		ioHosts:     dupStrings(c.IoHosts),
-		credentials: mac,
-		queryer:     queryer,
+		bucket:          c.Bucket,
+		ioHosts:         dupStrings(c.IoHosts),
+		credentials:     mac,
+		queryer:         queryer,
+		downConcurrency: c.DownConcurrency,
+		downPartSize:    part,
+		lister:          NewLister(c),
+		verifyHash:      c.VerifyHash,
+		upPartSize:      uploadPartSize(c),
+		syncConcurrency: syncConcurrency(c),
+		retry:           c.Retry.policy(defaultDownRetryTimes),
+		selector:        newHostSelector(c),
+		pool:            c.hostPool(),
+		metrics:         c.Metrics,
+		useHTTPS:        c.UseHTTPS,
+		client:          c.DownTransport.client(c, downTransportDefaults),
 	}
 	shuffleHosts(downloader.ioHosts)
 	return &downloader
@@ -65,33 +81,92 @@ func NewDownloaderV2() *Downloader {
 	return NewDownloader(c)
 }
 
+// SetProgressListener 设置下载进度通知，传入 nil 关闭通知
+func (d *Downloader) SetProgressListener(listener ProgressListener) {
+	d.progress = listener
+}
+
+// DownloadFile 下载文件到 path，如果配置了 verify_hash，会先获取文件的 hash 并在下载完成后校验
 func (d *Downloader) DownloadFile(key, path string) (f *os.File, err error) {
-	for i := 0; i < 3; i++ {
-		f, err = d.downloadFileInner(key, path)
-		if err == nil {
-			return
+	return d.DownloadFileWithContext(context.Background(), key, path)
+}
+
+// DownloadFileWithContext 和 DownloadFile 相同，ctx 结束时会中断正在进行的请求和重试等待
+func (d *Downloader) DownloadFileWithContext(ctx context.Context, key, path string) (f *os.File, err error) {
+	if !d.verifyHash {
+		return d.downloadFile(ctx, key, path)
+	}
+	entry, err := d.lister.stat(ctx, key)
+	if err != nil {
+		return nil, wrapError("download", key, "", err)
+	}
+	return d.downloadFileWithHash(ctx, key, path, entry.Hash)
+}
+
+// DownloadFileWithHash 下载文件到 path 并校验 etag，hash 通常来自 Stat 或者 ListItem。
+// 校验失败时会删除本地文件重新下载一次，仍然失败则返回包装了 *HashMismatchError 的 *Error
+func (d *Downloader) DownloadFileWithHash(key, path, hash string) (f *os.File, err error) {
+	return d.downloadFileWithHash(context.Background(), key, path, hash)
+}
+
+func (d *Downloader) downloadFileWithHash(ctx context.Context, key, path, hash string) (f *os.File, err error) {
+	if !isEtagV1(hash) {
+		elog.Log(slog.LevelWarn, "skip hash verification", slog.Op("download"), slog.Key(key), slog.F("hash", hash))
+		return d.downloadFile(ctx, key, path)
+	}
+	for i := 0; i < 2; i++ {
+		f, err = d.downloadFile(ctx, key, path)
+		if err != nil {
+			return nil, err
+		}
+		if err = verifyEtag(f, key, hash); err == nil {
+			return f, nil
+		}
+		f.Close()
+		if !errors.Is(err, ErrHashMismatch) {
+			return nil, wrapError("download", key, "", err)
+		}
+		elog.Log(slog.LevelWarn, "download again", slog.Op("download"), slog.Key(key), slog.Err(err))
+		if rmErr := os.Remove(path); rmErr != nil {
+			return nil, wrapError("download", key, "", rmErr)
 		}
 	}
+	return nil, wrapError("download", key, "", err)
+}
+
+func (d *Downloader) downloadFile(ctx context.Context, key, path string) (f *os.File, err error) {
+	if d.downConcurrency > 1 {
+		return d.downloadFileParallel(ctx, key, path)
+	}
+	err = d.retry.Do(ctx, func(i int) (err error) {
+		if i > 0 {
+			collector(d.metrics).IncRetry("download")
+		}
+		f, err = d.downloadFileInner(ctx, key, path)
+		return
+	})
 	return
 }
 
 func (d *Downloader) DownloadBytes(key string) (data []byte, err error) {
-	for i := 0; i < 3; i++ {
-		data, err = d.downloadBytesInner(key)
-		if err == nil {
-			break
+	err = d.retry.Do(context.Background(), func(i int) (err error) {
+		if i > 0 {
+			collector(d.metrics).IncRetry("download")
 		}
-	}
+		data, err = d.downloadBytesInner(key)
+		return
+	})
 	return
 }
 
 func (d *Downloader) DownloadRangeBytes(key string, offset, size int64) (l int64, data []byte, err error) {
-	for i := 0; i < 3; i++ {
-		l, data, err = d.downloadRangeBytesInner(key, offset, size)
-		if err == nil {
-			break
+	err = d.retry.Do(context.Background(), func(i int) (err error) {
+		if i > 0 {
+			collector(d.metrics).IncRetry("download")
 		}
-	}
+		l, data, err = d.downloadRangeBytesInner(key, offset, size)
+		return
+	})
 	return
 }
 
@@ -105,113 +180,130 @@ func fileExists(filename string) bool {
 	return !info.IsDir()
 }
 
-var curIoHostIndex uint32 = 0
-
 func (d *Downloader) nextHost() string {
 	ioHosts := d.ioHosts
 	if d.queryer != nil {
-		if hosts := d.queryer.QueryIoHosts(false); len(hosts) > 0 {
+		if hosts := d.queryer.QueryIoHosts(d.useHTTPS); len(hosts) > 0 {
 			shuffleHosts(hosts)
 			ioHosts = hosts
 		}
 	}
-	switch len(ioHosts) {
-	case 0:
+	if len(ioHosts) == 0 {
 		panic("No Io hosts is configured")
-	case 1:
-		return ioHosts[0]
-	default:
-		var ioHost string
-		for i := 0; i <= len(ioHosts)*MaxFindHostsPrecent/100; i++ {
-			index := int(atomic.AddUint32(&curIoHostIndex, 1) - 1)
-			ioHost = ioHosts[index%len(ioHosts)]
-			if isHostNameValid(ioHost) {
-				break
-			}
-		}
-		return ioHost
 	}
+	return d.selector.Select(ioHosts, d.pool.Usable)
 }
 
-func (d *Downloader) downloadFileInner(key, path string) (*os.File, error) {
+func (d *Downloader) downloadFileInner(ctx context.Context, key, path string) (f *os.File, err error) {
 	if strings.HasPrefix(key, "/") {
 		key = strings.TrimPrefix(key, "/")
 	}
-	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
 	if err != nil {
-		return nil, err
+		return nil, wrapError("download", key, "", err)
 	}
-	length, err := f.Seek(0, io.SeekEnd)
+	defer func() {
+		if err != nil {
+			file.Close()
+		}
+	}()
+	length, err := file.Seek(0, io.SeekEnd)
 	if err != nil {
-		return nil, err
+		return nil, wrapError("download", key, "", err)
 	}
 	host := d.nextHost()
+	start := startRequest(d.selector, host)
+	defer func() {
+		observeRequest(d.metrics, d.selector, "download", host, 0, start, err)
+		err = wrapError("download", key, host, err)
+	}()
 
-	fmt.Println("remote path", key)
+	elog.Log(slog.LevelDebug, "download", slog.Op("download"), slog.Key(key), slog.Host(host))
 	url := fmt.Sprintf("%s/getfile/%s/%s/%s", host, d.credentials.AccessKey, d.bucket, key)
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
-		failHostName(host)
+		d.pool.Fail(host)
 		return nil, err
 	}
+	req = req.WithContext(ctx)
 	req.Header.Set("Accept-Encoding", "")
 	if length != 0 {
 		r := fmt.Sprintf("bytes=%d-", length)
 		req.Header.Set("Range", r)
-		fmt.Println("continue download")
+		elog.Log(slog.LevelInfo, "continue download", slog.Op("download"), slog.Key(key), slog.F("offset", length))
 	}
 
-	response, err := downloadClient.Do(req)
+	response, err := d.client.Do(req)
 	if err != nil {
-		failHostName(host)
+		d.pool.Fail(host)
 		return nil, err
 	}
 	defer response.Body.Close()
 	if response.StatusCode == http.StatusRequestedRangeNotSatisfiable {
-		succeedHostName(host)
-		return f, nil
+		d.pool.Succeed(host)
+		return file, nil
 	}
 	if response.StatusCode != http.StatusOK && response.StatusCode != http.StatusPartialContent {
-		failHostName(host)
-		return nil, errors.New(response.Status)
+		d.pool.Fail(host)
+		return nil, responseError("download", key, host, response)
 	}
-	succeedHostName(host)
+	d.pool.Succeed(host)
 	ctLength := response.ContentLength
-	n, err := io.Copy(f, response.Body)
+	total := int64(-1)
+	if ctLength >= 0 {
+		total = length + ctLength
+	}
+	progress := newProgressTracker(d.progress, key, total)
+	progress.adjust(length)
+	// 写本地文件失败时 err 是 *os.PathError，不会被重试
+	n, err := io.Copy(file, progress.reader(response.Body))
+	collector(d.metrics).AddBytes(metrics.Down, n)
 	if err != nil {
 		return nil, err
 	}
 	if ctLength != n {
-		elog.Warn("download length not equal", ctLength, n)
+		elog.Log(slog.LevelWarn, "download length not equal", slog.Op("download"), slog.Key(key), slog.Host(host), slog.F("expected", ctLength), slog.Bytes(n))
 	}
-	f.Seek(0, io.SeekStart)
-	return f, nil
+	progress.finish()
+	file.Seek(0, io.SeekStart)
+	return file, nil
 }
 
-func (d *Downloader) downloadBytesInner(key string) ([]byte, error) {
+func (d *Downloader) downloadBytesInner(key string) (data []byte, err error) {
 	if strings.HasPrefix(key, "/") {
 		key = strings.TrimPrefix(key, "/")
 	}
 	host := d.nextHost()
+	start := startRequest(d.selector, host)
+	defer func() {
+		observeRequest(d.metrics, d.selector, "download", host, 0, start, err)
+		err = wrapError("download", key, host, err)
+	}()
 
 	url := fmt.Sprintf("%s/getfile/%s/%s/%s", host, d.credentials.AccessKey, d.bucket, key)
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
-	response, err := downloadClient.Do(req)
+	response, err := d.client.Do(req)
 	if err != nil {
-		failHostName(host)
+		d.pool.Fail(host)
 		return nil, err
 	}
 	defer response.Body.Close()
 
 	if response.StatusCode != http.StatusOK {
-		failHostName(host)
-		return nil, errors.New(response.Status)
+		d.pool.Fail(host)
+		return nil, responseError("download", key, host, response)
 	}
-	succeedHostName(host)
-	return ioutil.ReadAll(response.Body)
+	d.pool.Succeed(host)
+	progress := newProgressTracker(d.progress, key, response.ContentLength)
+	data, err = ioutil.ReadAll(progress.reader(response.Body))
+	collector(d.metrics).AddBytes(metrics.Down, int64(len(data)))
+	if err == nil {
+		progress.finish()
+	}
+	return data, err
 }
 
 func generateRange(offset, size int64) string {
@@ -221,48 +313,56 @@ func generateRange(offset, size int64) string {
 	return fmt.Sprintf("bytes=%d-%d", offset, offset+size)
 }
 
-func (d *Downloader) downloadRangeBytesInner(key string, offset, size int64) (int64, []byte, error) {
+func (d *Downloader) downloadRangeBytesInner(key string, offset, size int64) (l int64, b []byte, err error) {
 	if strings.HasPrefix(key, "/") {
 		key = strings.TrimPrefix(key, "/")
 	}
 	host := d.nextHost()
+	start := startRequest(d.selector, host)
+	defer func() {
+		observeRequest(d.metrics, d.selector, "download_range", host, 0, start, err)
+		err = wrapError("download", key, host, err)
+	}()
 
 	url := fmt.Sprintf("%s/getfile/%s/%s/%s", host, d.credentials.AccessKey, d.bucket, key)
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
-		failHostName(host)
+		d.pool.Fail(host)
 		return -1, nil, err
 	}
 
 	req.Header.Set("Range", generateRange(offset, size))
-	response, err := downloadClient.Do(req)
+	response, err := d.client.Do(req)
 	if err != nil {
-		failHostName(host)
+		d.pool.Fail(host)
 		return -1, nil, err
 	}
 	defer response.Body.Close()
 
 	if response.StatusCode != http.StatusPartialContent {
-		failHostName(host)
-		return -1, nil, errors.New(response.Status)
+		d.pool.Fail(host)
+		return -1, nil, responseError("download", key, host, response)
 	}
 
 	rangeResponse := response.Header.Get("Content-Range")
 	if rangeResponse == "" {
-		failHostName(host)
-		return -1, nil, errors.New("no content range")
+		d.pool.Fail(host)
+		return -1, nil, badResponseError("download", key, host, response, errors.New("no content range"))
 	}
 
-	l, err := getTotalLength(rangeResponse)
+	l, err = getTotalLength(rangeResponse)
 	if err != nil {
-		failHostName(host)
-		return -1, nil, err
+		d.pool.Fail(host)
+		return -1, nil, badResponseError("download", key, host, response, err)
 	}
-	b, err := ioutil.ReadAll(response.Body)
+	progress := newProgressTracker(d.progress, key, response.ContentLength)
+	b, err = ioutil.ReadAll(progress.reader(response.Body))
+	collector(d.metrics).AddBytes(metrics.Down, int64(len(b)))
 	if err != nil {
-		failHostName(host)
+		d.pool.Fail(host)
 	} else {
-		succeedHostName(host)
+		d.pool.Succeed(host)
+		progress.finish()
 	}
 	return l, b, err
 }
@@ -0,0 +1,175 @@
+package operation
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/x/slog.v1"
+)
+
+// downloadingSuffix 是下载中的临时文件后缀，下载完成后再改名，中断后可以从临时文件继续下载。
+// 临时文件对应的远端文件的 hash 记录在 downloadingHashSuffix 文件中，远端文件变化后临时文件会被丢弃
+const (
+	downloadingSuffix     = ".downloading"
+	downloadingHashSuffix = downloadingSuffix + ".hash"
+)
+
+// PlanPrefix 比较远端 prefix 下的文件和本地目录 dir，返回需要执行的操作。
+// prefix 和 Syncer.Plan 一样被当作目录，key 去掉 prefix 后按 '/' 划分目录层级作为本地路径，
+// 大小和 qetag 都一致的文件会被跳过
+func (d *Downloader) PlanPrefix(prefix, dir string, opts SyncOptions) ([]SyncOp, error) {
+	prefix = dirPrefix(prefix)
+	items, err := d.lister.listItems(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var ops []SyncOp
+	remote := make(map[string]struct{}, len(items))
+	for _, item := range items {
+		path, ok := localPathOf(dir, prefix, item.Key)
+		if !ok {
+			elog.Log(slog.LevelWarn, "skip key", slog.Op("download_prefix"), slog.Key(item.Key))
+			continue
+		}
+		remote[path] = struct{}{}
+
+		op := SyncOp{Action: SyncDownload, Key: item.Key, Path: path, Size: item.Fsize, hash: item.Hash}
+		info, err := os.Stat(path)
+		switch {
+		case os.IsNotExist(err):
+			op.Reason = "new"
+		case err != nil:
+			return nil, err
+		case !info.Mode().IsRegular():
+			op.Reason = "new"
+		case info.Size() != item.Fsize:
+			op.Reason = "size"
+		default:
+			same, err := sameEtag(path, info.Size(), d.upPartSize, item.Hash)
+			if err != nil {
+				return nil, err
+			}
+			if same {
+				continue
+			}
+			op.Reason = "hash"
+		}
+		ops = append(ops, op)
+	}
+
+	if opts.Delete {
+		var gone []SyncOp
+		err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if os.IsNotExist(err) && path == dir {
+				return filepath.SkipDir
+			}
+			if err != nil {
+				return err
+			}
+			if !info.Mode().IsRegular() || strings.HasSuffix(path, downloadingSuffix) || strings.HasSuffix(path, downloadingHashSuffix) {
+				return nil
+			}
+			rel, err := filepath.Rel(dir, path)
+			if err != nil {
+				return err
+			}
+			// 不满足过滤条件的本地文件不在同步范围内
+			if !matchFilter(d.lister.filter, localFilterItem(keyOfLocalPath(prefix, rel), info)) {
+				return nil
+			}
+			if _, ok := remote[path]; !ok {
+				gone = append(gone, SyncOp{Action: SyncDelete, Path: path, Size: info.Size(), Reason: "gone"})
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		sort.Slice(gone, func(i, j int) bool { return gone[i].Path < gone[j].Path })
+		ops = append(ops, gone...)
+	}
+	return ops, nil
+}
+
+// DownloadPrefix 把远端 prefix 下的文件下载到本地目录 dir，保留 key 中以 '/' 分隔的目录结构。
+// 文件先下载到临时文件，中断后再次执行会继续下载；并发度由 sync_concurrency 配置。
+// 单个文件失败不会中断同步，失败的操作会设置 Err，同时返回汇总的错误
+func (d *Downloader) DownloadPrefix(prefix, dir string, opts SyncOptions) ([]SyncOp, error) {
+	ops, err := d.PlanPrefix(prefix, dir, opts)
+	if err != nil || opts.DryRun {
+		return ops, err
+	}
+	return ops, runSyncOps(ops, d.syncConcurrency, func(op *SyncOp) error {
+		if op.Action == SyncDelete {
+			return os.Remove(op.Path)
+		}
+		return d.downloadTo(op.Key, op.Path, op.hash)
+	})
+}
+
+func (d *Downloader) downloadTo(key, path, hash string) (err error) {
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return
+	}
+	tmp := path + downloadingSuffix
+	if err = prepareDownloading(path, hash); err != nil {
+		return
+	}
+	var f *os.File
+	if d.verifyHash {
+		f, err = d.DownloadFileWithHash(key, tmp, hash)
+	} else {
+		f, err = d.downloadFile(context.Background(), key, tmp)
+	}
+	if err != nil {
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return
+	}
+	if err = os.Rename(tmp, path); err != nil {
+		return
+	}
+	os.Remove(path + downloadingHashSuffix)
+	return nil
+}
+
+// prepareDownloading 检查 path 的临时文件记录的 hash，和远端文件的 hash 不一致或者没有记录时删除临时文件，
+// 避免远端文件变化后拼接出错误的内容，然后记录新的 hash
+func prepareDownloading(path, hash string) error {
+	hashFile := path + downloadingHashSuffix
+	if old, err := ioutil.ReadFile(hashFile); err != nil || string(old) != hash {
+		if err = os.Remove(path + downloadingSuffix); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return ioutil.WriteFile(hashFile, []byte(hash), 0644)
+}
+
+// localPathOf 把 key 映射为 dir 下的本地路径，key 以 '/' 结尾（目录占位）或者会越出 dir 时返回 false
+func localPathOf(dir, prefix, key string) (string, bool) {
+	rel := strings.TrimPrefix(strings.TrimPrefix(key, prefix), "/")
+	if rel == "" || strings.HasSuffix(rel, "/") {
+		return "", false
+	}
+	for _, part := range strings.Split(rel, "/") {
+		if part == ".." {
+			return "", false
+		}
+	}
+	return filepath.Join(dir, filepath.FromSlash(rel)), true
+}
+
+// keyOfLocalPath 是 localPathOf 的逆映射，把相对 dir 的本地路径 rel 还原为 key
+func keyOfLocalPath(prefix, rel string) string {
+	rel = filepath.ToSlash(rel)
+	if prefix == "" || strings.HasSuffix(prefix, "/") {
+		return prefix + rel
+	}
+	return prefix + "/" + rel
+}
@@ -0,0 +1,159 @@
+package operation
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestPrefixDownloader(rsSrv *fakeRsServer, ioSrv *fakeIoServer, files map[string]string) *Downloader {
+	for key, content := range files {
+		rsSrv.put(key, []byte(content))
+		ioSrv.put(key, []byte(content))
+	}
+	c := newTestConfig(rsSrv)
+	c.IoHosts = []string{ioSrv.URL}
+	return NewDownloader(c)
+}
+
+func TestDownloadPrefix(t *testing.T) {
+	rsSrv, ioSrv := newFakeRsServer(), newFakeIoServer()
+	defer rsSrv.Close()
+	defer ioSrv.Close()
+	d := newTestPrefixDownloader(rsSrv, ioSrv, map[string]string{
+		"p/same":     "same",
+		"p/size":     "new size",
+		"p/hash":     "new1",
+		"p/dir/":     "",
+		"p/dir/file": "0123456789",
+		"p/../evil":  "outside",
+		"other":      "out of prefix",
+	})
+
+	dir := t.TempDir()
+	fileHash, _ := Etag(strings.NewReader("0123456789"))
+	writeFiles(t, dir, map[string]string{
+		"same":                      "same",
+		"size":                      "old",
+		"size.downloading":          "no hash recorded",
+		"hash":                      "old1",
+		"hash.downloading":          "stale",
+		"hash.downloading.hash":     "stale hash",
+		"gone":                      "gone",
+		"dir/file.downloading":      "01234",
+		"dir/file.downloading.hash": fileHash,
+	})
+
+	ops, err := d.PlanPrefix("p/", dir, SyncOptions{Delete: true})
+	if err != nil {
+		t.Fatal("plan failed:", err)
+	}
+	expected := fmt.Sprintf("download %[1]s/dir/file new, download %[1]s/hash hash, download %[1]s/size size, delete %[1]s/gone gone", filepath.ToSlash(dir))
+	if got := opsString(ops); got != expected {
+		t.Fatalf("unexpected plan:\n%s\nexpect:\n%s", got, expected)
+	}
+
+	if ops, err = d.DownloadPrefix("p/", dir, SyncOptions{Delete: true}); err != nil {
+		t.Fatal("download prefix failed:", err)
+	}
+	for name, content := range map[string]string{"same": "same", "size": "new size", "hash": "new1", "dir/file": "0123456789"} {
+		got, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+		if err != nil || string(got) != content {
+			t.Fatalf("unexpected content of %s: %q, %v", name, got, err)
+		}
+	}
+	for _, name := range []string{"gone", "dir/file.downloading", "dir/file.downloading.hash", "hash.downloading.hash", "../evil"} {
+		if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(name))); !os.IsNotExist(err) {
+			t.Fatal("file should not exist:", name, err)
+		}
+	}
+	if n := ioSrv.count("p/dir/file", "bytes=5-"); n != 1 {
+		t.Fatal("download should continue from the temporary file, requests:", ioSrv.requests)
+	}
+	if ioSrv.count("p/size", "") != 1 || ioSrv.count("p/hash", "") != 1 {
+		t.Fatal("temporary files of other versions should be discarded, requests:", ioSrv.requests)
+	}
+	if ops, err = d.PlanPrefix("p/", dir, SyncOptions{Delete: true}); err != nil || len(ops) != 0 {
+		t.Fatal("nothing should be left to download:", opsString(ops), err)
+	}
+}
+
+func TestPlanPrefixDelete(t *testing.T) {
+	rsSrv, ioSrv := newFakeRsServer(), newFakeIoServer()
+	defer rsSrv.Close()
+	defer ioSrv.Close()
+	d := newTestPrefixDownloader(rsSrv, ioSrv, map[string]string{"p/a": "a", "p2/b": "sibling"})
+	filter, err := NewFilter(&FilterConfig{Exclude: []string{"p/keep/"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.lister.filter = filter
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a": "a", "gone": "gone", "keep/file": "excluded"})
+	ops, err := d.PlanPrefix("p", dir, SyncOptions{Delete: true})
+	if err != nil {
+		t.Fatal("plan failed:", err)
+	}
+	expected := fmt.Sprintf("delete %s/gone gone", filepath.ToSlash(dir))
+	if got := opsString(ops); got != expected {
+		t.Fatalf("unexpected plan:\n%s\nexpect:\n%s", got, expected)
+	}
+}
+
+func TestDownloadPrefixFailure(t *testing.T) {
+	rsSrv, ioSrv := newFakeRsServer(), newFakeIoServer()
+	defer rsSrv.Close()
+	defer ioSrv.Close()
+	d := newTestPrefixDownloader(rsSrv, ioSrv, map[string]string{"p/a": "a", "p/b": "b"})
+	ioSrv.hook = func(key, rangeHeader string, n int) int {
+		if key == "p/b" {
+			return 404
+		}
+		return 0
+	}
+
+	dir := t.TempDir()
+	ops, err := d.DownloadPrefix("p/", dir, SyncOptions{})
+	if err == nil || !strings.Contains(err.Error(), "1 of 2") {
+		t.Fatal("unexpected error:", err)
+	}
+	if len(ops) != 2 || ops[0].Err != nil || ops[1].Err == nil {
+		t.Fatal("failed op should be marked:", opsString(ops))
+	}
+	if got, err := ioutil.ReadFile(filepath.Join(dir, "a")); err != nil || string(got) != "a" {
+		t.Fatal("other files should still be downloaded:", string(got), err)
+	}
+}
+
+func TestLocalPathOf(t *testing.T) {
+	cases := []struct {
+		key  string
+		path string
+		ok   bool
+	}{
+		{"p/a", "a", true},
+		{"p/a/b", "a/b", true},
+		{"p//a", "a", true},
+		{"p/a/", "", false},
+		{"p/", "", false},
+		{"p/../a", "", false},
+		{"p/a/../../b", "", false},
+	}
+	for _, c := range cases {
+		path, ok := localPathOf("dir", "p/", c.key)
+		if ok != c.ok || (ok && path != filepath.Join("dir", filepath.FromSlash(c.path))) {
+			t.Errorf("localPathOf(%q) = %q, %v", c.key, path, ok)
+		}
+	}
+
+	for _, prefix := range []string{"", "p", "p/"} {
+		path, ok := localPathOf("dir", prefix, keyOfLocalPath(prefix, filepath.Join("a", "b")))
+		if !ok || path != filepath.Join("dir", "a", "b") {
+			t.Errorf("keyOfLocalPath(%q) should be the inverse of localPathOf: %q, %v", prefix, path, ok)
+		}
+	}
+}
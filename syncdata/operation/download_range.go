@@ -0,0 +1,210 @@
+package operation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/x/metrics.v1"
+	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/x/slog.v1"
+)
+
+type fileRange struct {
+	offset int64
+	size   int64
+}
+
+// downloadFileParallel 把文件切分成多个区间，从不同的 io 节点并发下载后写入预先分配好的本地文件，
+// 失败时只重试失败的区间
+func (d *Downloader) downloadFileParallel(ctx context.Context, key, path string) (*os.File, error) {
+	key = strings.TrimPrefix(key, "/")
+
+	var total int64
+	err := d.retry.Do(ctx, func(i int) (err error) {
+		if i > 0 {
+			collector(d.metrics).IncRetry("download")
+		}
+		total, err = d.queryLength(ctx, key)
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, wrapError("download", key, "", err)
+	}
+	if err = f.Truncate(total); err != nil {
+		f.Close()
+		return nil, wrapError("download", key, "", err)
+	}
+
+	var ranges []fileRange
+	for offset := int64(0); offset < total; offset += d.downPartSize {
+		size := d.downPartSize
+		if size > total-offset {
+			size = total - offset
+		}
+		ranges = append(ranges, fileRange{offset: offset, size: size})
+	}
+
+	// 每次尝试只下载之前失败的区间，遇到 404 等不可重试的错误时立即停止
+	progress := newProgressTracker(d.progress, key, total)
+	err = d.retry.Do(ctx, func(i int) error {
+		if i > 0 {
+			elog.Log(slog.LevelInfo, "retry", slog.Op("download_range"), slog.Key(key), slog.F("attempt", i), slog.F("ranges", len(ranges)), slog.Err(err))
+			collector(d.metrics).IncRetry("download_range")
+		}
+		ranges, err = d.downloadRanges(ctx, key, f, ranges, progress)
+		return err
+	})
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	progress.finish()
+	f.Seek(0, io.SeekStart)
+	return f, nil
+}
+
+// downloadRanges 并发下载 ranges，返回失败的区间以及其中一个错误，有不可重试的错误时优先返回它
+func (d *Downloader) downloadRanges(ctx context.Context, key string, f *os.File, ranges []fileRange, progress *progressTracker) (failed []fileRange, err error) {
+	var (
+		wg   sync.WaitGroup
+		lock sync.Mutex
+		ch   = make(chan fileRange)
+	)
+	for i := 0; i < d.downConcurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for r := range ch {
+				if rangeErr := d.downloadRangeTo(ctx, key, f, r, progress); rangeErr != nil {
+					lock.Lock()
+					failed = append(failed, r)
+					if err == nil || d.retry.ShouldRetry(err) {
+						err = rangeErr
+					}
+					lock.Unlock()
+				}
+			}
+		}()
+	}
+	for _, r := range ranges {
+		ch <- r
+	}
+	close(ch)
+	wg.Wait()
+	return
+}
+
+func (d *Downloader) downloadRangeTo(ctx context.Context, key string, f *os.File, r fileRange, progress *progressTracker) (err error) {
+	host := d.nextHost()
+	start := startRequest(d.selector, host)
+	defer func() {
+		observeRequest(d.metrics, d.selector, "download_range", host, 0, start, err)
+		err = wrapError("download", key, host, err)
+	}()
+	url := fmt.Sprintf("%s/getfile/%s/%s/%s", host, d.credentials.AccessKey, d.bucket, key)
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept-Encoding", "")
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", r.offset, r.offset+r.size-1))
+	response, err := d.client.Do(req)
+	if err != nil {
+		d.pool.Fail(host)
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusPartialContent {
+		d.pool.Fail(host)
+		return responseError("download", key, host, response)
+	}
+
+	w := &offsetWriter{w: f, offset: r.offset}
+	n, err := io.Copy(w, progress.reader(io.LimitReader(response.Body, r.size)))
+	collector(d.metrics).AddBytes(metrics.Down, n)
+	if err == nil && n != r.size {
+		err = fmt.Errorf("range %d-%d short read: %d", r.offset, r.offset+r.size-1, n)
+	}
+	if err != nil {
+		d.pool.Fail(host)
+		progress.add(-n)
+		return err
+	}
+	d.pool.Succeed(host)
+	return nil
+}
+
+// queryLength 通过只请求第一个字节的 Range 请求获取文件总长度
+func (d *Downloader) queryLength(ctx context.Context, key string) (l int64, err error) {
+	host := d.nextHost()
+	start := startRequest(d.selector, host)
+	defer func() {
+		observeRequest(d.metrics, d.selector, "query_length", host, 0, start, err)
+		err = wrapError("download", key, host, err)
+	}()
+	url := fmt.Sprintf("%s/getfile/%s/%s/%s", host, d.credentials.AccessKey, d.bucket, key)
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return -1, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept-Encoding", "")
+	req.Header.Set("Range", "bytes=0-0")
+	response, err := d.client.Do(req)
+	if err != nil {
+		d.pool.Fail(host)
+		return -1, err
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusRequestedRangeNotSatisfiable: // 空文件
+		d.pool.Succeed(host)
+		return 0, nil
+	case http.StatusOK:
+		if response.ContentLength < 0 {
+			d.pool.Fail(host)
+			return -1, badResponseError("download", key, host, response, errors.New("unknown content length"))
+		}
+		d.pool.Succeed(host)
+		return response.ContentLength, nil
+	case http.StatusPartialContent:
+	default:
+		d.pool.Fail(host)
+		return -1, responseError("download", key, host, response)
+	}
+	rangeResponse := response.Header.Get("Content-Range")
+	if rangeResponse == "" {
+		d.pool.Fail(host)
+		return -1, badResponseError("download", key, host, response, errors.New("no content range"))
+	}
+	l, err = getTotalLength(rangeResponse)
+	if err != nil {
+		d.pool.Fail(host)
+		return -1, badResponseError("download", key, host, response, err)
+	}
+	d.pool.Succeed(host)
+	return l, nil
+}
+
+type offsetWriter struct {
+	w      io.WriterAt
+	offset int64
+}
+
+func (w *offsetWriter) Write(p []byte) (n int, err error) {
+	n, err = w.w.WriteAt(p, w.offset)
+	w.offset += int64(n)
+	return
+}
@@ -0,0 +1,283 @@
+package operation
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeIoServer 模拟 io 的 getfile 接口，支持 Range 请求。
+// hook 返回非 0 的状态码时直接以这个状态码响应，用于注入错误
+type fakeIoServer struct {
+	*httptest.Server
+
+	m        sync.Mutex
+	files    map[string][]byte
+	requests map[string]int // key 和 Range 头到请求次数
+	hook     func(key, rangeHeader string, n int) int
+}
+
+func newFakeIoServer() *fakeIoServer {
+	s := &fakeIoServer{files: map[string][]byte{}, requests: map[string]int{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+func (s *fakeIoServer) serve(w http.ResponseWriter, req *http.Request) {
+	// /getfile/<ak>/<bucket>/<key>
+	parts := strings.SplitN(strings.TrimPrefix(req.URL.Path, "/getfile/"), "/", 3)
+	if len(parts) != 3 {
+		http.NotFound(w, req)
+		return
+	}
+	key, rangeHeader := parts[2], req.Header.Get("Range")
+	s.m.Lock()
+	id := key + " " + rangeHeader
+	s.requests[id]++
+	n := s.requests[id]
+	data, ok := s.files[key]
+	hook := s.hook
+	s.m.Unlock()
+
+	if hook != nil {
+		if code := hook(key, rangeHeader, n); code != 0 {
+			w.WriteHeader(code)
+			fmt.Fprintf(w, `{"error":"injected %d"}`, code)
+			return
+		}
+	}
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error":"no such file or directory"}`))
+		return
+	}
+	http.ServeContent(w, req, key, time.Time{}, bytes.NewReader(data))
+}
+
+func (s *fakeIoServer) put(key string, data []byte) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.files[key] = data
+}
+
+func (s *fakeIoServer) count(key, rangeHeader string) int {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.requests[key+" "+rangeHeader]
+}
+
+func (s *fakeIoServer) total() (n int) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	for _, c := range s.requests {
+		n += c
+	}
+	return
+}
+
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	rand.Read(b)
+	return b
+}
+
+func newTestDownloader(srv *fakeIoServer, concurrency int, retry RetryConfig) *Downloader {
+	return NewDownloader(&Config{
+		IoHosts:         []string{srv.URL},
+		Bucket:          "bucket",
+		Ak:              "ak",
+		Sk:              "sk",
+		DownConcurrency: concurrency,
+		Retry:           retry,
+	})
+}
+
+const testPartSize = 4 * 1024 * 1024
+
+func TestDownloadFileParallel(t *testing.T) {
+	srv := newFakeIoServer()
+	defer srv.Close()
+	data := randomBytes(2*testPartSize + 100)
+	srv.put("key", data)
+	failed := fmt.Sprintf("bytes=%d-%d", testPartSize, 2*testPartSize-1)
+	srv.hook = func(key, rangeHeader string, n int) int {
+		if rangeHeader == failed && n == 1 {
+			return http.StatusServiceUnavailable
+		}
+		return 0
+	}
+
+	d := newTestDownloader(srv, 2, RetryConfig{MaxAttempts: 3})
+	path := filepath.Join(t.TempDir(), "file")
+	f, err := d.DownloadFile("key", path)
+	if err != nil {
+		t.Fatal("download failed:", err)
+	}
+	f.Close()
+	got, _ := ioutil.ReadFile(path)
+	if !bytes.Equal(got, data) {
+		t.Fatal("downloaded data mismatch")
+	}
+	if n := srv.count("key", failed); n != 2 {
+		t.Fatal("failed range should be retried once, but got:", n)
+	}
+	for _, r := range []string{
+		fmt.Sprintf("bytes=0-%d", testPartSize-1),
+		fmt.Sprintf("bytes=%d-%d", 2*testPartSize, 2*testPartSize+99),
+		"bytes=0-0",
+	} {
+		if n := srv.count("key", r); n != 1 {
+			t.Fatalf("range %s should be requested once, but got: %d", r, n)
+		}
+	}
+}
+
+func TestDownloadFileParallelNotRetryable(t *testing.T) {
+	srv := newFakeIoServer()
+	defer srv.Close()
+	srv.put("key", randomBytes(2*testPartSize))
+	srv.hook = func(key, rangeHeader string, n int) int {
+		if rangeHeader != "bytes=0-0" {
+			return http.StatusForbidden
+		}
+		return 0
+	}
+
+	d := newTestDownloader(srv, 2, RetryConfig{MaxAttempts: 3})
+	_, err := d.DownloadFile("key", filepath.Join(t.TempDir(), "file"))
+	if !errors.Is(err, ErrUnauthorized) {
+		t.Fatal("expect unauthorized error, but got:", err)
+	}
+	if n := srv.total(); n != 3 {
+		t.Fatal("non-retryable errors should not be retried, requests:", n)
+	}
+
+	_, err = d.DownloadFile("missing", filepath.Join(t.TempDir(), "file"))
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatal("expect not found error, but got:", err)
+	}
+	if n := srv.count("missing", "bytes=0-0"); n != 1 {
+		t.Fatal("querying the length of a missing file should not be retried, requests:", n)
+	}
+}
+
+func TestDownloadFileParallelCancel(t *testing.T) {
+	srv := newFakeIoServer()
+	defer srv.Close()
+	srv.put("key", randomBytes(2*testPartSize))
+	srv.hook = func(key, rangeHeader string, n int) int {
+		if rangeHeader != "bytes=0-0" {
+			return http.StatusServiceUnavailable
+		}
+		return 0
+	}
+
+	d := newTestDownloader(srv, 2, RetryConfig{MaxAttempts: 3, InitialBackoff: 60 * 1000})
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := d.DownloadFileWithContext(ctx, "key", filepath.Join(t.TempDir(), "file"))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("expect deadline exceeded, but got:", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Fatal("backoff should be interrupted by ctx, elapsed:", elapsed)
+	}
+
+	srv.hook = func(key, rangeHeader string, n int) int {
+		return http.StatusServiceUnavailable
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	_, err = d.DownloadFileWithContext(ctx, "key", filepath.Join(t.TempDir(), "file"))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("expect deadline exceeded, but got:", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Fatal("querying length should be interrupted by ctx, elapsed:", elapsed)
+	}
+}
+
+func TestDownloadFileResume(t *testing.T) {
+	srv := newFakeIoServer()
+	defer srv.Close()
+	data := randomBytes(1000)
+	srv.put("key", data)
+
+	path := filepath.Join(t.TempDir(), "file")
+	if err := ioutil.WriteFile(path, data[:300], 0644); err != nil {
+		t.Fatal(err)
+	}
+	d := newTestDownloader(srv, 1, RetryConfig{})
+	f, err := d.DownloadFile("key", path)
+	if err != nil {
+		t.Fatal("download failed:", err)
+	}
+	f.Close()
+	got, _ := ioutil.ReadFile(path)
+	if !bytes.Equal(got, data) {
+		t.Fatal("downloaded data mismatch")
+	}
+	if n := srv.count("key", "bytes=300-"); n != 1 {
+		t.Fatal("download should continue from the local file, requests:", srv.requests)
+	}
+}
+
+func TestDownloadFileLocalError(t *testing.T) {
+	srv := newFakeIoServer()
+	defer srv.Close()
+	srv.put("key", randomBytes(2*testPartSize))
+
+	// 父目录不存在，本地文件无法创建，重试也不会成功
+	path := filepath.Join(t.TempDir(), "missing", "file")
+	for _, concurrency := range []int{1, 2} {
+		d := newTestDownloader(srv, concurrency, RetryConfig{MaxAttempts: 3, InitialBackoff: 60 * 1000})
+		start := time.Now()
+		_, err := d.DownloadFile("key", path)
+		if err == nil || errors.Is(err, ErrNetwork) {
+			t.Fatal("expect local error, but got:", err)
+		}
+		if d.retry.ShouldRetry(err) || time.Since(start) > 10*time.Second {
+			t.Fatal("local error should not be retried:", concurrency, err, time.Since(start))
+		}
+	}
+}
+
+func TestDownloadFileStatContext(t *testing.T) {
+	rs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer rs.Close()
+
+	d := NewDownloader(&Config{
+		UpHosts:    []string{rs.URL},
+		RsHosts:    []string{rs.URL},
+		IoHosts:    []string{rs.URL},
+		Bucket:     "bucket",
+		Ak:         "ak",
+		Sk:         "sk",
+		VerifyHash: true,
+		Retry:      RetryConfig{MaxAttempts: 3, InitialBackoff: 60 * 1000},
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := d.DownloadFileWithContext(ctx, "key", filepath.Join(t.TempDir(), "file"))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("expect deadline exceeded, but got:", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Fatal("stat retries should be interrupted by ctx, elapsed:", elapsed)
+	}
+}
@@ -0,0 +1,183 @@
+package operation
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/x/errors.v1"
+	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/x/rpc.v7"
+)
+
+// 可以通过 errors.Is(err, ErrXxx) 判断 Uploader、Downloader、Lister 和 Queryer 返回的错误类别
+var (
+	ErrNotFound      = errors.New("not found")      // 404、612
+	ErrAlreadyExists = errors.New("already exists") // 614
+	ErrUnauthorized  = errors.New("unauthorized")   // 401、403
+	ErrThrottled     = errors.New("throttled")      // 429、509
+	ErrServer        = errors.New("server error")   // 5xx
+	ErrNetwork       = errors.New("network error")  // 连接失败、连接断开等没有收到响应的错误，不包括超时
+	ErrTimeout       = errors.New("timeout")        // 请求超时，包括 context.DeadlineExceeded
+)
+
+// Error 是 syncdata 各操作返回的错误，可以通过 errors.As 取出服务端返回的详细信息。
+// 数据校验失败时 Err 是 *HashMismatchError
+type Error struct {
+	Op      string // 操作名，例如 upload、download、stat、list、batch、query
+	Key     string // 操作的文件名或者前缀，没有时为空
+	Host    string // 出错的节点，没有时为空
+	Code    int    // HTTP 状态码，没有收到有效的响应时为 0
+	ErrCode int    // 服务端返回的错误码（errno）
+	Reqid   string // 响应头中的 X-Reqid
+	Err     error
+}
+
+func (e *Error) Error() string {
+	var b strings.Builder
+	b.WriteString(e.Op)
+	if e.Key != "" {
+		b.WriteString(" " + e.Key)
+	}
+	if e.Host != "" {
+		b.WriteString(" on " + e.Host)
+	}
+	if e.Code != 0 {
+		fmt.Fprintf(&b, " (code %d", e.Code)
+		if e.Reqid != "" {
+			b.WriteString(", reqid " + e.Reqid)
+		}
+		b.WriteString(")")
+	}
+	if e.Err != nil {
+		b.WriteString(": " + e.Err.Error())
+	}
+	return b.String()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// HttpCode 返回 HTTP 状态码，没有收到响应时按 x/errors.v1 的规则返回（网络错误为 599），用于重试策略判断。
+// 本地文件读写失败时返回 0，这类错误和节点无关，不会被重试
+func (e *Error) HttpCode() int {
+	if e.Code != 0 {
+		return e.Code
+	}
+	if isLocalError(e.Err) {
+		return 0
+	}
+	code, _ := errors.HttpCodeOf(e.Err)
+	return code
+}
+
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.Code == http.StatusNotFound || e.Code == 612
+	case ErrAlreadyExists:
+		return e.Code == 614
+	case ErrUnauthorized:
+		return e.Code == http.StatusUnauthorized || e.Code == http.StatusForbidden
+	case ErrThrottled:
+		return e.Code == http.StatusTooManyRequests || e.Code == 509
+	case ErrServer:
+		return e.Code/100 == 5 && e.Code != 509
+	case ErrNetwork:
+		return e.Code == 0 && isNetworkError(e.Err)
+	case ErrTimeout:
+		return e.Code == 0 && isTimeout(e.Err)
+	}
+	return false
+}
+
+// wrapError 把 err 包装成 *Error，err 已经是 *Error 时只补充缺少的字段
+func wrapError(op, key, host string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if e, ok := err.(*Error); ok {
+		if e.Key == "" {
+			e.Key = key
+		}
+		if e.Host == "" {
+			e.Host = host
+		}
+		return e
+	}
+	e := &Error{Op: op, Key: key, Host: host, Err: err}
+	var info *rpc.ErrorInfo
+	if errors.As(err, &info) {
+		e.Code, e.ErrCode, e.Reqid = info.Code, info.Errno, info.Reqid
+	} else if code, _ := errors.HttpCodeOf(err); code != 599 && code != 499 {
+		e.Code = code
+	}
+	if e.Host == "" {
+		var ue *url.Error
+		if errors.As(err, &ue) {
+			e.Host = hostOfURL(ue.URL)
+		}
+	}
+	return e
+}
+
+// responseError 根据非 2xx 的响应生成错误，会读取 JSON 格式的响应体中的错误信息
+func responseError(op, key, host string, resp *http.Response) error {
+	err := rpc.ResponseError(resp)
+	if info, ok := err.(*rpc.ErrorInfo); ok && info.Err == "" {
+		info.Err = resp.Status
+	}
+	return wrapError(op, key, host, err)
+}
+
+// badResponseError 用于状态码正确但是响应内容不符合预期的情况，Code 为 0，可以重试
+func badResponseError(op, key, host string, resp *http.Response, err error) error {
+	return &Error{Op: op, Key: key, Host: host, Reqid: resp.Header.Get("X-Reqid"), Err: err}
+}
+
+// reqidOf 返回错误对应的请求的 X-Reqid，没有时返回空字符串
+func reqidOf(err error) string {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Reqid
+	}
+	return ""
+}
+
+// isLocalError 判断 err 是否是本地文件系统的错误，网络错误不会包含 *os.PathError
+func isLocalError(err error) bool {
+	var pe *os.PathError
+	var le *os.LinkError
+	return errors.As(err, &pe) || errors.As(err, &le)
+}
+
+func isNetworkError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || isTimeout(err) || isLocalError(err) || errors.As(err, new(*tlsConfigError)) {
+		return false
+	}
+	var ne net.Error
+	return errors.As(err, &ne)
+}
+
+func isTimeout(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+func hostOfURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" {
+		return ""
+	}
+	return u.Scheme + "://" + u.Host
+}
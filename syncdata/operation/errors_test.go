@@ -0,0 +1,88 @@
+package operation
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/x/rpc.v7"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestErrorIs(t *testing.T) {
+	refused := &url.Error{Op: "Get", URL: "http://up.example.com/x", Err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}}
+	deadline := &url.Error{Op: "Get", URL: "http://up.example.com/x", Err: context.DeadlineExceeded}
+	cases := []struct {
+		err      error
+		expected error
+	}{
+		{&rpc.ErrorInfo{Code: 612}, ErrNotFound},
+		{&rpc.ErrorInfo{Code: http.StatusNotFound}, ErrNotFound},
+		{&rpc.ErrorInfo{Code: 614}, ErrAlreadyExists},
+		{&rpc.ErrorInfo{Code: http.StatusForbidden}, ErrUnauthorized},
+		{&rpc.ErrorInfo{Code: 509}, ErrThrottled},
+		{&rpc.ErrorInfo{Code: http.StatusTooManyRequests}, ErrThrottled},
+		{&rpc.ErrorInfo{Code: http.StatusBadGateway}, ErrServer},
+		{refused, ErrNetwork},
+		{deadline, ErrTimeout},
+		{&url.Error{Op: "Get", URL: "http://up.example.com/x", Err: timeoutError{}}, ErrTimeout},
+		{context.DeadlineExceeded, ErrTimeout},
+	}
+	all := []error{ErrNotFound, ErrAlreadyExists, ErrUnauthorized, ErrThrottled, ErrServer, ErrNetwork, ErrTimeout}
+	for _, c := range cases {
+		err := wrapError("test", "key", "", c.err)
+		for _, target := range all {
+			if errors.Is(err, target) != (target == c.expected) {
+				t.Fatalf("errors.Is(%v, %v) should be %v", err, target, target == c.expected)
+			}
+		}
+	}
+
+	if err := wrapError("test", "key", "", deadline); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("original error should be unwrapped:", err)
+	}
+	if err := wrapError("test", "key", "", context.Canceled); errors.Is(err, ErrNetwork) || errors.Is(err, ErrTimeout) {
+		t.Fatal("canceled should not be network error or timeout:", err)
+	}
+}
+
+func TestErrorAs(t *testing.T) {
+	err := wrapError("stat", "key", "", &url.Error{Op: "Get", URL: "http://rs.example.com/stat/x", Err: timeoutError{}})
+	var e *Error
+	if !errors.As(err, &e) || e.Op != "stat" || e.Key != "key" || e.Host != "http://rs.example.com" || e.Code != 0 {
+		t.Fatalf("unexpected error: %+v", e)
+	}
+	if e.HttpCode() != 599 {
+		t.Fatal("error without response should be treated as 599:", e.HttpCode())
+	}
+
+	err = wrapError("stat", "key", "http://rs.example.com", &rpc.ErrorInfo{Code: 612, Errno: 1, Reqid: "reqid", Err: "no such file"})
+	if !errors.As(err, &e) || e.Code != 612 || e.ErrCode != 1 || e.Reqid != "reqid" || reqidOf(err) != "reqid" {
+		t.Fatalf("unexpected error: %+v", e)
+	}
+	if again := wrapError("batch", "other", "", err); again != err {
+		t.Fatal("*Error should not be wrapped again:", again)
+	}
+}
+
+func TestClientTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer srv.Close()
+	client := &http.Client{Timeout: 50 * time.Millisecond}
+	_, err := client.Get(srv.URL)
+	if err = wrapError("download", "key", "", err); !errors.Is(err, ErrTimeout) || errors.Is(err, ErrNetwork) {
+		t.Fatal("client timeout should be ErrTimeout:", err)
+	}
+}
@@ -0,0 +1,84 @@
+package operation
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/x/qetag.v1"
+)
+
+const (
+	etagSmallPrefix   = 0x16
+	etagLargePrefix   = 0x96
+	etagPartsPrefix   = 0x9e
+	etagDecodedLength = 1 + sha1.Size
+)
+
+var ErrHashMismatch = errors.New("hash mismatch")
+
+// HashMismatchError 表示下载的数据和服务端记录的 hash 不一致，可以通过 errors.Is(err, ErrHashMismatch) 判断
+type HashMismatchError struct {
+	Key      string
+	Expected string
+	Actual   string
+}
+
+func (e *HashMismatchError) Error() string {
+	return fmt.Sprintf("%s: %s expected %s, got %s", ErrHashMismatch, e.Key, e.Expected, e.Actual)
+}
+
+func (e *HashMismatchError) Unwrap() error {
+	return ErrHashMismatch
+}
+
+// Etag 计算 r 中数据的七牛 etag，算法见 x/qetag.v1
+func Etag(r io.Reader) (string, error) {
+	return qetag.Etag(r)
+}
+
+// isEtagV1 判断 hash 是否是按 4MB 分块计算的 etag，分片上传时使用了其他分片大小的文件 hash 无法在本地重新计算
+func isEtagV1(hash string) bool {
+	b, err := base64.URLEncoding.DecodeString(hash)
+	if err != nil || len(b) != etagDecodedLength {
+		return false
+	}
+	return b[0] == etagSmallPrefix || b[0] == etagLargePrefix
+}
+
+func verifyEtag(f io.ReadSeeker, key, expected string) error {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	actual, err := Etag(f)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if actual != expected {
+		return &HashMismatchError{Key: key, Expected: expected, Actual: actual}
+	}
+	return nil
+}
+
+// localEtag 按 remoteHash 的格式计算本地数据的 etag，用于判断本地文件和远端文件是否一致。
+// 超过 partSize 的文件是按 partSize 分片上传的，此时 hash 可能是自定义分片大小的 etag
+func localEtag(r io.ReaderAt, size, partSize int64, remoteHash string) (string, error) {
+	b, err := base64.URLEncoding.DecodeString(remoteHash)
+	if err != nil || len(b) != etagDecodedLength || b[0] != etagPartsPrefix || size <= partSize || partSize <= 0 {
+		return qetag.EtagReaderAt(r, size, 0)
+	}
+	var parts []int64
+	for offset := int64(0); offset < size; offset += partSize {
+		part := partSize
+		if part > size-offset {
+			part = size - offset
+		}
+		parts = append(parts, part)
+	}
+	return qetag.EtagWithParts(r, size, parts, 0)
+}
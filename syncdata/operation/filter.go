@@ -0,0 +1,344 @@
+package operation
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FilterConfig 描述批量操作时对文件的过滤条件，所有条件同时满足才会被选中。
+//
+// Include/Exclude 是 glob 规则，语法和 .gitignore 类似：
+// '*' 匹配 '/' 以外的任意字符，'?' 匹配 '/' 以外的单个字符，'**' 匹配任意层级目录；
+// 不含 '/' 的规则匹配任意层级的文件名，否则从根开始匹配；以 '/' 结尾的规则只匹配目录下的文件；
+// 以 '!' 开头的规则表示取反，同一列表中后面的规则优先
+type FilterConfig struct {
+	Include      []string `json:"include" toml:"include"`             // 非空时只选中匹配的文件
+	Exclude      []string `json:"exclude" toml:"exclude"`             // 排除匹配的文件
+	IncludeRegex []string `json:"include_regex" toml:"include_regex"` // 和 Include 任意一个匹配即可
+	ExcludeRegex []string `json:"exclude_regex" toml:"exclude_regex"`
+	MinSize      int64    `json:"min_size" toml:"min_size"` // 单位字节，0 表示不限制
+	MaxSize      int64    `json:"max_size" toml:"max_size"`
+	After        string   `json:"after" toml:"after"` // 上传时间不早于，RFC3339 或者 2006-01-02 格式
+	Before       string   `json:"before" toml:"before"`
+	IgnoreFile   string   `json:"ignore_file" toml:"ignore_file"` // .us3ignore 文件，内容追加到 Exclude
+}
+
+// FilterItem 是被过滤的对象，可以是远端文件也可以是本地文件
+type FilterItem struct {
+	Key     string    // key 或者本地文件的相对路径，以 '/' 分隔
+	Size    int64     // 小于 0 表示未知，不参与大小过滤
+	PutTime time.Time // 零值表示未知，不参与时间过滤
+}
+
+// Filter 判断文件是否被选中
+type Filter interface {
+	Match(item FilterItem) bool
+}
+
+// IgnoreFileName 是目录中的忽略规则文件，Syncer 同步目录时会自动读取
+const IgnoreFileName = ".us3ignore"
+
+var errEmptyFilter = errors.New("empty filter")
+
+// NewFilter 根据配置生成 Filter，没有任何过滤条件时返回 nil, nil
+func NewFilter(c *FilterConfig) (Filter, error) {
+	f := &filter{minSize: c.MinSize, maxSize: c.MaxSize}
+	var err error
+	if f.include, err = compileGlobs(c.Include); err != nil {
+		return nil, err
+	}
+	exclude := c.Exclude
+	if c.IgnoreFile != "" {
+		lines, err := LoadIgnoreFile(c.IgnoreFile)
+		if err != nil {
+			return nil, err
+		}
+		exclude = append(append([]string{}, exclude...), lines...)
+	}
+	if f.exclude, err = compileGlobs(exclude); err != nil {
+		return nil, err
+	}
+	if f.includeRegex, err = compileRegexps(c.IncludeRegex); err != nil {
+		return nil, err
+	}
+	if f.excludeRegex, err = compileRegexps(c.ExcludeRegex); err != nil {
+		return nil, err
+	}
+	if f.after, err = parseFilterTime(c.After); err != nil {
+		return nil, err
+	}
+	if f.before, err = parseFilterTime(c.Before); err != nil {
+		return nil, err
+	}
+	if f.empty() {
+		return nil, nil
+	}
+	return f, nil
+}
+
+// NewFilterFromQuery 从 URL 参数生成 Filter，参数名和 FilterConfig 的 json 名一致，
+// include、exclude、include_regex、exclude_regex 可以出现多次
+func NewFilterFromQuery(q url.Values) (Filter, error) {
+	c := FilterConfig{
+		Include:      q["include"],
+		Exclude:      q["exclude"],
+		IncludeRegex: q["include_regex"],
+		ExcludeRegex: q["exclude_regex"],
+		After:        q.Get("after"),
+		Before:       q.Get("before"),
+	}
+	var err error
+	if s := q.Get("min_size"); s != "" {
+		if c.MinSize, err = strconv.ParseInt(s, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid min_size %q", s)
+		}
+	}
+	if s := q.Get("max_size"); s != "" {
+		if c.MaxSize, err = strconv.ParseInt(s, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid max_size %q", s)
+		}
+	}
+	return NewFilter(&c)
+}
+
+// AndFilter 返回同时满足所有 filters 的 Filter，忽略其中的 nil
+func AndFilter(filters ...Filter) Filter {
+	var fs andFilter
+	for _, f := range filters {
+		if f != nil {
+			fs = append(fs, f)
+		}
+	}
+	switch len(fs) {
+	case 0:
+		return nil
+	case 1:
+		return fs[0]
+	}
+	return fs
+}
+
+// LoadIgnoreFile 读取 .us3ignore 格式的文件，忽略空行和以 '#' 开头的注释
+func LoadIgnoreFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var patterns []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, line)
+	}
+	return patterns, scanner.Err()
+}
+
+// listFilterItem 把列举结果转换为 FilterItem
+func listFilterItem(key string, size, putTime int64) FilterItem {
+	item := FilterItem{Key: key, Size: size}
+	if putTime > 0 {
+		item.PutTime = time.Unix(0, putTime*100) // putTime 的单位是 100 纳秒
+	}
+	return item
+}
+
+// localFilterItem 把 dir 下的本地文件转换为 FilterItem，Key 是相对 dir 的路径
+func localFilterItem(rel string, info os.FileInfo) FilterItem {
+	return FilterItem{Key: filepath.ToSlash(rel), Size: info.Size(), PutTime: info.ModTime()}
+}
+
+func matchFilter(f Filter, item FilterItem) bool {
+	return f == nil || f.Match(item)
+}
+
+type andFilter []Filter
+
+func (fs andFilter) Match(item FilterItem) bool {
+	for _, f := range fs {
+		if !f.Match(item) {
+			return false
+		}
+	}
+	return true
+}
+
+type filter struct {
+	include      globRules
+	exclude      globRules
+	includeRegex []*regexp.Regexp
+	excludeRegex []*regexp.Regexp
+	minSize      int64
+	maxSize      int64
+	after        time.Time
+	before       time.Time
+}
+
+func (f *filter) empty() bool {
+	return len(f.include) == 0 && len(f.exclude) == 0 && len(f.includeRegex) == 0 && len(f.excludeRegex) == 0 &&
+		f.minSize == 0 && f.maxSize == 0 && f.after.IsZero() && f.before.IsZero()
+}
+
+func (f *filter) Match(item FilterItem) bool {
+	key := strings.TrimPrefix(item.Key, "/")
+	if len(f.include) > 0 || len(f.includeRegex) > 0 {
+		if !f.include.match(key) && !matchAnyRegexp(f.includeRegex, key) {
+			return false
+		}
+	}
+	if f.exclude.match(key) || matchAnyRegexp(f.excludeRegex, key) {
+		return false
+	}
+	if item.Size >= 0 {
+		if f.minSize > 0 && item.Size < f.minSize || f.maxSize > 0 && item.Size > f.maxSize {
+			return false
+		}
+	}
+	if !item.PutTime.IsZero() {
+		if !f.after.IsZero() && item.PutTime.Before(f.after) || !f.before.IsZero() && !item.PutTime.Before(f.before) {
+			return false
+		}
+	}
+	return true
+}
+
+func matchAnyRegexp(res []*regexp.Regexp, key string) bool {
+	for _, re := range res {
+		if re.MatchString(key) {
+			return true
+		}
+	}
+	return false
+}
+
+func compileRegexps(exprs []string) ([]*regexp.Regexp, error) {
+	var res []*regexp.Regexp
+	for _, expr := range exprs {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+func parseFilterTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", s, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q", s)
+	}
+	return t, nil
+}
+
+type globRule struct {
+	re     *regexp.Regexp
+	negate bool
+}
+
+type globRules []globRule
+
+// match 返回最后一条匹配的规则是否为正向规则
+func (rules globRules) match(key string) bool {
+	matched := false
+	for _, rule := range rules {
+		if rule.re.MatchString(key) {
+			matched = !rule.negate
+		}
+	}
+	return matched
+}
+
+func compileGlobs(patterns []string) (globRules, error) {
+	var rules globRules
+	for _, pattern := range patterns {
+		rule, err := compileGlob(pattern)
+		if err == errEmptyFilter {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func compileGlob(pattern string) (rule globRule, err error) {
+	if strings.HasPrefix(pattern, "!") {
+		rule.negate = true
+		pattern = pattern[1:]
+	}
+	dirOnly := strings.HasSuffix(pattern, "/")
+	pattern = strings.TrimSuffix(pattern, "/")
+	anchored := strings.Contains(pattern, "/")
+	pattern = strings.TrimPrefix(pattern, "/")
+	if pattern == "" {
+		return rule, errEmptyFilter
+	}
+
+	var b strings.Builder
+	if anchored {
+		b.WriteString("^")
+	} else {
+		b.WriteString("^(?:.*/)?")
+	}
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case strings.HasPrefix(pattern[i:], "**/"):
+			b.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '[':
+			j := strings.IndexByte(pattern[i:], ']')
+			if j < 0 {
+				return rule, fmt.Errorf("invalid glob %q", pattern)
+			}
+			class := pattern[i+1 : i+j]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + class + "]")
+			i += j
+		default:
+			j := strings.IndexAny(pattern[i:], "*?[")
+			if j < 0 {
+				j = len(pattern) - i
+			}
+			b.WriteString(regexp.QuoteMeta(pattern[i : i+j]))
+			i += j - 1
+		}
+	}
+	// 匹配目录时选中目录下的所有文件
+	if dirOnly {
+		b.WriteString("/.*$")
+	} else {
+		b.WriteString("(?:/.*)?$")
+	}
+	rule.re, err = regexp.Compile(b.String())
+	return
+}
@@ -0,0 +1,228 @@
+package operation
+
+import (
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestGlobRules(t *testing.T) {
+	cases := []struct {
+		patterns []string
+		key      string
+		matched  bool
+	}{
+		// 不含 '/' 的规则匹配任意层级的文件名
+		{[]string{"*.log"}, "a.log", true},
+		{[]string{"*.log"}, "x/y/a.log", true},
+		{[]string{"*.log"}, "a.log.txt", false},
+		{[]string{"*.log"}, "x.log/a", true},
+		{[]string{"?.txt"}, "a.txt", true},
+		{[]string{"?.txt"}, "ab.txt", false},
+		{[]string{"a*"}, "x/ab/c", true},
+		{[]string{"*"}, "a/b", true},
+
+		// '**' 匹配任意层级目录
+		{[]string{"**/tmp"}, "tmp", true},
+		{[]string{"**/tmp"}, "a/b/tmp", true},
+		{[]string{"**/tmp"}, "a/tmp/f", true},
+		{[]string{"a/**/b"}, "a/b", true},
+		{[]string{"a/**/b"}, "a/x/y/b", true},
+		{[]string{"a/**/b"}, "x/a/b", false},
+		{[]string{"a/**"}, "a/x/y", true},
+		{[]string{"a/**"}, "b/a/x", false},
+
+		// 含有 '/' 的规则从根开始匹配
+		{[]string{"doc/*.txt"}, "doc/a.txt", true},
+		{[]string{"doc/*.txt"}, "x/doc/a.txt", false},
+		{[]string{"doc/*.txt"}, "doc/sub/a.txt", false},
+		{[]string{"/foo"}, "foo", true},
+		{[]string{"/foo"}, "foo/bar", true},
+		{[]string{"/foo"}, "x/foo", false},
+
+		// 以 '/' 结尾的规则只匹配目录下的文件
+		{[]string{"foo/"}, "foo/a", true},
+		{[]string{"foo/"}, "x/foo/a", true},
+		{[]string{"foo/"}, "foo", false},
+		{[]string{"a/foo/"}, "a/foo/b", true},
+		{[]string{"a/foo/"}, "x/a/foo/b", false},
+
+		// 字符类
+		{[]string{"file[0-9]"}, "file1", true},
+		{[]string{"file[0-9]"}, "filea", false},
+		{[]string{"file[!0-9]"}, "filea", true},
+		{[]string{"file[!0-9]"}, "file1", false},
+
+		// 特殊字符按字面匹配
+		{[]string{"a+b.(c)"}, "a+b.(c)", true},
+		{[]string{"a.b"}, "axb", false},
+
+		// 以 '!' 开头的规则取反，后面的规则优先
+		{[]string{"*.log", "!keep.log"}, "a.log", true},
+		{[]string{"*.log", "!keep.log"}, "keep.log", false},
+		{[]string{"*.log", "!keep.log"}, "x/keep.log", false},
+		{[]string{"!keep.log", "*.log"}, "keep.log", true},
+		{[]string{"!keep.log"}, "keep.log", false},
+		{[]string{"logs/", "!logs/keep/"}, "logs/a", true},
+		{[]string{"logs/", "!logs/keep/"}, "logs/keep/a", false},
+
+		// 空规则被忽略
+		{[]string{"", "!", "/"}, "a", false},
+	}
+	for _, c := range cases {
+		rules, err := compileGlobs(c.patterns)
+		if err != nil {
+			t.Errorf("compile %q failed: %v", c.patterns, err)
+			continue
+		}
+		if got := rules.match(c.key); got != c.matched {
+			t.Errorf("%q match %q = %v, expect %v", c.patterns, c.key, got, c.matched)
+		}
+	}
+}
+
+func TestCompileGlobError(t *testing.T) {
+	for _, pattern := range []string{"[abc", "a/[b"} {
+		if _, err := compileGlobs([]string{pattern}); err == nil {
+			t.Errorf("expect error for %q", pattern)
+		}
+	}
+}
+
+func TestFilterMatch(t *testing.T) {
+	now := time.Now()
+	cases := []struct {
+		config FilterConfig
+		item   FilterItem
+		match  bool
+	}{
+		{FilterConfig{Include: []string{"*.jpg"}}, FilterItem{Key: "a/b.jpg"}, true},
+		{FilterConfig{Include: []string{"*.jpg"}}, FilterItem{Key: "a/b.png"}, false},
+		{FilterConfig{Include: []string{"*.jpg"}}, FilterItem{Key: "/b.jpg"}, true},
+		{FilterConfig{Include: []string{"*.jpg"}, IncludeRegex: []string{`\.png$`}}, FilterItem{Key: "b.png"}, true},
+		{FilterConfig{Include: []string{"*.jpg"}, Exclude: []string{"tmp/"}}, FilterItem{Key: "tmp/b.jpg"}, false},
+		{FilterConfig{ExcludeRegex: []string{`^tmp`}}, FilterItem{Key: "tmp.txt"}, false},
+		{FilterConfig{ExcludeRegex: []string{`^tmp`}}, FilterItem{Key: "a/tmp.txt"}, true},
+
+		{FilterConfig{MinSize: 10}, FilterItem{Key: "a", Size: 9}, false},
+		{FilterConfig{MinSize: 10}, FilterItem{Key: "a", Size: 10}, true},
+		{FilterConfig{MaxSize: 10}, FilterItem{Key: "a", Size: 11}, false},
+		{FilterConfig{MinSize: 10}, FilterItem{Key: "a", Size: -1}, true},
+
+		{FilterConfig{After: "2020-01-02"}, FilterItem{Key: "a", PutTime: now}, true},
+		{FilterConfig{After: now.Add(time.Hour).Format(time.RFC3339)}, FilterItem{Key: "a", PutTime: now}, false},
+		{FilterConfig{Before: "2020-01-02"}, FilterItem{Key: "a", PutTime: now}, false},
+		{FilterConfig{Before: "2020-01-02T00:00:00Z"}, FilterItem{Key: "a", PutTime: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}, true},
+		{FilterConfig{Before: "2020-01-02"}, FilterItem{Key: "a"}, true},
+	}
+	for _, c := range cases {
+		f, err := NewFilter(&c.config)
+		if err != nil {
+			t.Errorf("new filter %+v failed: %v", c.config, err)
+			continue
+		}
+		if got := f.Match(c.item); got != c.match {
+			t.Errorf("%+v match %+v = %v, expect %v", c.config, c.item, got, c.match)
+		}
+	}
+}
+
+func TestNewFilter(t *testing.T) {
+	if f, err := NewFilter(&FilterConfig{Exclude: []string{""}}); f != nil || err != nil {
+		t.Fatal("expect nil filter without conditions:", f, err)
+	}
+	for _, c := range []FilterConfig{
+		{Include: []string{"[a"}},
+		{ExcludeRegex: []string{"("}},
+		{After: "yesterday"},
+		{Before: "2020/01/01"},
+		{IgnoreFile: filepath.Join(t.TempDir(), "missing")},
+	} {
+		if _, err := NewFilter(&c); err == nil {
+			t.Errorf("expect error for %+v", c)
+		}
+	}
+}
+
+func TestNewFilterFromQuery(t *testing.T) {
+	f, err := NewFilterFromQuery(url.Values{})
+	if f != nil || err != nil {
+		t.Fatal("expect nil filter without conditions:", f, err)
+	}
+
+	q := url.Values{"include": {"*.jpg", "*.png"}, "exclude": {"tmp/"}, "min_size": {"10"}, "max_size": {"100"}}
+	if f, err = NewFilterFromQuery(q); err != nil {
+		t.Fatal(err)
+	}
+	for key, match := range map[string]bool{"a.jpg": true, "a.png": true, "a.gif": false, "tmp/a.jpg": false} {
+		if f.Match(FilterItem{Key: key, Size: 50}) != match {
+			t.Errorf("match %q should be %v", key, match)
+		}
+	}
+	if f.Match(FilterItem{Key: "a.jpg", Size: 101}) {
+		t.Error("max_size should be applied")
+	}
+
+	for _, q := range []url.Values{
+		{"min_size": {"ten"}},
+		{"max_size": {"1k"}},
+		{"after": {"yesterday"}},
+		{"include_regex": {"("}},
+	} {
+		if _, err = NewFilterFromQuery(q); err == nil {
+			t.Errorf("expect error for %v", q)
+		}
+	}
+}
+
+func TestAndFilter(t *testing.T) {
+	if AndFilter() != nil || AndFilter(nil, nil) != nil {
+		t.Fatal("expect nil filter")
+	}
+	jpg, _ := NewFilter(&FilterConfig{Include: []string{"*.jpg"}})
+	if AndFilter(nil, jpg, nil) != jpg {
+		t.Fatal("single filter should be returned as is")
+	}
+	big, _ := NewFilter(&FilterConfig{MinSize: 10})
+	f := AndFilter(jpg, nil, big)
+	if !f.Match(FilterItem{Key: "a.jpg", Size: 10}) || f.Match(FilterItem{Key: "a.jpg", Size: 9}) || f.Match(FilterItem{Key: "a.png", Size: 10}) {
+		t.Fatal("all filters should be matched")
+	}
+	if !matchFilter(nil, FilterItem{Key: "a"}) {
+		t.Fatal("nil filter should match everything")
+	}
+}
+
+func TestIgnoreFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), IgnoreFileName)
+	content := "# comment\n\n  *.log  \n!keep.log\nbuild/\n"
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	lines, err := LoadIgnoreFile(path)
+	if err != nil || len(lines) != 3 || lines[0] != "*.log" {
+		t.Fatal("unexpected ignore rules:", lines, err)
+	}
+
+	f, err := NewFilter(&FilterConfig{Exclude: []string{"*.tmp"}, IgnoreFile: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, match := range map[string]bool{"a.txt": true, "a.tmp": false, "a.log": false, "x/keep.log": true, "build/a.txt": false} {
+		if f.Match(FilterItem{Key: key, Size: -1}) != match {
+			t.Errorf("match %q should be %v", key, match)
+		}
+	}
+}
+
+func TestListFilterItem(t *testing.T) {
+	item := listFilterItem("a", 1, 16000000000000000)
+	if !item.PutTime.Equal(time.Unix(1600000000, 0)) {
+		t.Fatal("put time should be in 100ns:", item.PutTime)
+	}
+	if item = listFilterItem("a", 1, 0); !item.PutTime.IsZero() {
+		t.Fatal("unknown put time should be zero:", item.PutTime)
+	}
+}
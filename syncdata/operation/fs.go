@@ -22,6 +22,8 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/x/slog.v1"
 )
 
 // A Dir implements FileSystem using the native file system restricted to a
@@ -111,7 +113,7 @@ func logf(r *http.Request, format string, args ...interface{}) {
 	if s != nil && s.ErrorLog != nil {
 		s.ErrorLog.Printf(format, args...)
 	} else {
-		elog.Info(fmt.Sprintf(format, args...))
+		elog.Log(slog.LevelWarn, fmt.Sprintf(format, args...), slog.Op("download"))
 	}
 }
 
@@ -240,7 +242,7 @@ func serveContent(w http.ResponseWriter, r *http.Request, name string, modtime t
 			if err == errNoOverlap {
 				w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
 			}
-			fmt.Println("range parse failed", 2, rangeReq, err.Error())
+			elog.Log(slog.LevelDebug, "range parse failed", slog.F("range", rangeReq), slog.Err(err))
 			http.Error(w, err.Error(), http.StatusRequestedRangeNotSatisfiable)
 			return
 		}
@@ -266,7 +268,7 @@ func serveContent(w http.ResponseWriter, r *http.Request, name string, modtime t
 			// multipart responses."
 			ra := ranges[0]
 			if _, err := content.Seek(ra.start, io.SeekStart); err != nil {
-				fmt.Println("range failed", 1)
+				elog.Log(slog.LevelDebug, "range seek failed", slog.F("start", ra.start), slog.Err(err))
 				http.Error(w, err.Error(), http.StatusRequestedRangeNotSatisfiable)
 				return
 			}
@@ -789,7 +791,7 @@ func parseRange(s string, size int64) ([]httpRange, error) {
 			// range start relative to the end of the file.
 			i, err := strconv.ParseInt(end, 10, 64)
 			if err != nil {
-				fmt.Println("parsed failed i0", start)
+				elog.Log(slog.LevelDebug, "invalid range end", slog.F("range", ra))
 				return nil, errors.New("invalid range")
 			}
 			if i > size {
@@ -800,14 +802,14 @@ func parseRange(s string, size int64) ([]httpRange, error) {
 		} else {
 			i, err := strconv.ParseInt(start, 10, 64)
 			if err != nil || i < 0 {
-				fmt.Println("parsed failed i1", start)
+				elog.Log(slog.LevelDebug, "invalid range start", slog.F("range", ra))
 				return nil, errors.New("invalid range")
 			}
 			if i >= size {
 				// If the range begins after the size of the content,
 				// then it does not overlap.
 				noOverlap = true
-				println("overlap", i, size, start, end)
+				elog.Log(slog.LevelDebug, "range not overlap", slog.F("range", ra), slog.F("size", size))
 				continue
 			}
 			r.start = i
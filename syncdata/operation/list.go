@@ -1,11 +1,18 @@
 package operation
 
 import (
+	"context"
 	"encoding/json"
 	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/api.v7/auth/qbox"
 	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/api.v7/kodo"
+	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/x/hostpool.v1"
+	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/x/httputil.v1"
+	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/x/metrics.v1"
+	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/x/retry.v1"
+	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/x/slog.v1"
 	"io"
-	"sync/atomic"
+	"net/http"
+	"strings"
 )
 
 type Lister struct {
@@ -15,252 +22,244 @@ type Lister struct {
 	rsfHosts    []string
 	credentials *qbox.Mac
 	queryer     *Queryer
+	filter      Filter
+	retry       *retry.Policy
+	batchRetry  *retry.Policy
+	rsSelector  hostpool.Selector
+	rsfSelector hostpool.Selector
+	pool        *hostpool.Pool
+	metrics     metrics.Collector
+	useHTTPS    bool
+	transport   http.RoundTripper
+
+	batchConcurrency int
 }
 
+// StatStatus 表示单个文件的查询结果
+type StatStatus string
+
+const (
+	StatOK       StatStatus = "ok"
+	StatNotFound StatStatus = "not_found" // 文件不存在
+	StatError    StatStatus = "error"     // 查询失败，可以重试
+)
+
+// FileStat 是 ListStat 中单个文件的查询结果，失败时 Size 为 -1，通过 Status 区分文件不存在和查询失败
 type FileStat struct {
-	Name string `json:"name"`
-	Size int64  `json:"size"`
+	Name     string        `json:"name"`
+	Size     int64         `json:"size"`
+	Hash     string        `json:"hash,omitempty"`
+	PutTime  int64         `json:"put_time,omitempty"` // 单位 100 纳秒
+	MimeType string        `json:"mime_type,omitempty"`
+	EndUser  string        `json:"end_user,omitempty"`
+	Type     kodo.FileType `json:"type"` // kodo.TypeNormal、TypeLine 或 TypeArchive
+	Status   StatStatus    `json:"status"`
+	Code     int           `json:"code"`
+	Error    string        `json:"error,omitempty"`
 }
 
+func (s *FileStat) NotFound() bool {
+	return s.Status == StatNotFound
+}
+
+func newFileStat(name string, ret *kodo.BatchStatItemRet) *FileStat {
+	if ret.Code != 200 {
+		status := StatError
+		if ret.Code == 612 {
+			status = StatNotFound
+		}
+		return &FileStat{Name: name, Size: -1, Status: status, Code: ret.Code, Error: ret.Error}
+	}
+	return &FileStat{
+		Name:     name,
+		Size:     ret.Data.Fsize,
+		Hash:     ret.Data.Hash,
+		PutTime:  ret.Data.PutTime,
+		MimeType: ret.Data.MimeType,
+		EndUser:  ret.Data.EndUser,
+		Type:     ret.Data.Type,
+		Status:   StatOK,
+		Code:     ret.Code,
+	}
+}
+
+// batchStat 查询 r 中 JSON 数组列出的文件，请求格式不正确时返回 nil。
+// 整批查询失败的文件在结果中标记为 StatError，这里只记录日志
 func (l *Lister) batchStat(r io.Reader) []*FileStat {
 	j := json.NewDecoder(r)
 	var fl []string
 	err := j.Decode(&fl)
 	if err != nil {
-		elog.Error(err)
+		elog.Log(slog.LevelWarn, "invalid stat request", slog.Op("stat"), slog.Err(err))
 		return nil
 	}
-	return l.ListStat(fl)
+	stats, err := l.ListStat(fl)
+	if err != nil {
+		elog.Log(slog.LevelWarn, "stat failed", slog.Op("batchStat"), slog.Err(err))
+	}
+	return stats
 }
 
-var curRsHostIndex uint32 = 0
-
 func (l *Lister) nextRsHost() string {
 	rsHosts := l.rsHosts
 	if l.queryer != nil {
-		if hosts := l.queryer.QueryRsHosts(false); len(hosts) > 0 {
+		if hosts := l.queryer.QueryRsHosts(l.useHTTPS); len(hosts) > 0 {
 			shuffleHosts(hosts)
 			rsHosts = hosts
 		}
 	}
-	switch len(rsHosts) {
-	case 0:
+	if len(rsHosts) == 0 {
 		panic("No Rs hosts is configured")
-	case 1:
-		return rsHosts[0]
-	default:
-		var rsHost string
-		for i := 0; i <= len(rsHosts)*MaxFindHostsPrecent/100; i++ {
-			index := int(atomic.AddUint32(&curRsHostIndex, 1) - 1)
-			rsHost = rsHosts[index%len(rsHosts)]
-			if isHostNameValid(rsHost) {
-				break
-			}
-		}
-		return rsHost
 	}
+	return l.rsSelector.Select(rsHosts, l.pool.Usable)
 }
 
-var curRsfHostIndex uint32 = 0
-
 func (l *Lister) nextRsfHost() string {
 	rsfHosts := l.rsfHosts
 	if l.queryer != nil {
-		if hosts := l.queryer.QueryRsfHosts(false); len(hosts) > 0 {
+		if hosts := l.queryer.QueryRsfHosts(l.useHTTPS); len(hosts) > 0 {
 			shuffleHosts(hosts)
 			rsfHosts = hosts
 		}
 	}
-	switch len(rsfHosts) {
-	case 0:
+	if len(rsfHosts) == 0 {
 		panic("No Rsf hosts is configured")
-	case 1:
-		return rsfHosts[0]
-	default:
-		var rsfHost string
-		for i := 0; i <= len(rsfHosts)*MaxFindHostsPrecent/100; i++ {
-			index := int(atomic.AddUint32(&curRsfHostIndex, 1) - 1)
-			rsfHost = rsfHosts[index%len(rsfHosts)]
-			if isHostNameValid(rsfHost) {
-				break
-			}
-		}
-		return rsfHost
 	}
+	return l.rsfSelector.Select(rsfHosts, l.pool.Usable)
 }
 
-func (l *Lister) Rename(fromKey, toKey string) error {
-	host := l.nextRsHost()
-	bucket := l.newBucket(host, "")
-	err := bucket.Move(nil, fromKey, toKey)
-	if err != nil {
-		failHostName(host)
-		elog.Info("rename retry 0", host, err)
-		host = l.nextRsHost()
-		bucket = l.newBucket(host, "")
-		err = bucket.Move(nil, fromKey, toKey)
-		if err != nil {
-			failHostName(host)
-			elog.Info("rename retry 1", host, err)
-			return err
+// rsCall 在 rs 域名上执行 fn，失败时换一个域名按重试策略重试，ctx 结束时停止重试，返回的错误是 *Error
+func (l *Lister) rsCall(ctx context.Context, op, key string, fn func(bucket kodo.Bucket) error) error {
+	return l.retry.Do(ctx, func(i int) error {
+		host := l.nextRsHost()
+		start := startRequest(l.rsSelector, host)
+		err := wrapError(op, key, host, fn(l.newBucket(host, "")))
+		observeRequest(l.metrics, l.rsSelector, op, host, i, start, err)
+		// 4xx 和 612 等是请求本身的错误，不是域名不可用
+		if code := httputil.DetectCode(err); err == nil || code/100 == 4 || code == 612 {
+			l.pool.Succeed(host)
 		} else {
-			succeedHostName(host)
+			l.pool.Fail(host)
 		}
-	} else {
-		succeedHostName(host)
-	}
-	return nil
+		if err != nil {
+			elog.Log(slog.LevelInfo, "request failed", slog.Op(op), slog.Key(key), slog.Host(host), slog.Reqid(reqidOf(err)), slog.F("attempt", i), slog.Err(err))
+		}
+		return err
+	})
+}
+
+func (l *Lister) Rename(fromKey, toKey string) error {
+	return l.rsCall(context.Background(), "rename", fromKey, func(bucket kodo.Bucket) error {
+		return bucket.Move(nil, fromKey, toKey)
+	})
 }
 
 func (l *Lister) MoveTo(fromKey, toBucket, toKey string) error {
-	host := l.nextRsHost()
-	bucket := l.newBucket(host, "")
-	err := bucket.MoveEx(nil, fromKey, toBucket, toKey)
-	if err != nil {
-		failHostName(host)
-		elog.Info("move retry 0", host, err)
-		host = l.nextRsHost()
-		bucket = l.newBucket(host, "")
-		err = bucket.MoveEx(nil, fromKey, toBucket, toKey)
-		if err != nil {
-			failHostName(host)
-			elog.Info("move retry 1", host, err)
-			return err
-		} else {
-			succeedHostName(host)
-		}
-	} else {
-		succeedHostName(host)
-	}
-	return nil
+	return l.rsCall(context.Background(), "move", fromKey, func(bucket kodo.Bucket) error {
+		return bucket.MoveEx(nil, fromKey, toBucket, toKey)
+	})
 }
 
 func (l *Lister) Copy(fromKey, toKey string) error {
-	host := l.nextRsHost()
-	bucket := l.newBucket(host, "")
-	err := bucket.Copy(nil, fromKey, toKey)
-	if err != nil {
-		failHostName(host)
-		elog.Info("copy retry 0", host, err)
-		host = l.nextRsHost()
-		bucket = l.newBucket(host, "")
-		err = bucket.Copy(nil, fromKey, toKey)
-		if err != nil {
-			failHostName(host)
-			elog.Info("copy retry 1", host, err)
-			return err
-		} else {
-			succeedHostName(host)
-		}
-	} else {
-		succeedHostName(host)
-	}
-	return nil
+	return l.rsCall(context.Background(), "copy", fromKey, func(bucket kodo.Bucket) error {
+		return bucket.Copy(nil, fromKey, toKey)
+	})
 }
 
 func (l *Lister) Delete(key string) error {
-	host := l.nextRsHost()
-	bucket := l.newBucket(host, "")
-	err := bucket.Delete(nil, key)
-	if err != nil {
-		failHostName(host)
-		elog.Info("delete retry 0", host, err)
-		host = l.nextRsHost()
-		bucket = l.newBucket(host, "")
-		err = bucket.Delete(nil, key)
-		if err != nil {
-			failHostName(host)
-			elog.Info("delete retry 1", host, err)
-			return err
-		} else {
-			succeedHostName(host)
-		}
-	} else {
-		succeedHostName(host)
-	}
-	return nil
+	return l.rsCall(context.Background(), "delete", key, func(bucket kodo.Bucket) error {
+		return bucket.Delete(nil, key)
+	})
+}
+
+func (l *Lister) stat(ctx context.Context, key string) (entry kodo.Entry, err error) {
+	key = strings.TrimPrefix(key, "/")
+	err = l.rsCall(ctx, "stat", key, func(bucket kodo.Bucket) (err error) {
+		entry, err = bucket.Stat(ctx, key)
+		return
+	})
+	return
 }
 
-func (l *Lister) ListStat(paths []string) []*FileStat {
-	host := l.nextRsHost()
-	bucket := l.newBucket(host, "")
+// ListStat 查询 paths 中每个文件的信息，结果和 paths 一一对应。
+// 有整批查询失败时这些文件被标记为 StatError，同时返回第一个失败的错误（*Error），其他文件的结果仍然有效
+func (l *Lister) ListStat(paths []string) ([]*FileStat, error) {
 	var stats []*FileStat
+	var first error
 	for i := 0; i < len(paths); i += 1000 {
 		size := 1000
 		if size > len(paths)-i {
 			size = len(paths) - i
 		}
 		array := paths[i : i+size]
-		r, err := bucket.BatchStat(nil, array...)
+		var r []kodo.BatchStatItemRet
+		err := l.rsCall(context.Background(), "batchStat", "", func(bucket kodo.Bucket) (err error) {
+			r, err = bucket.BatchStat(nil, array...)
+			return
+		})
 		if err != nil {
-			failHostName(host)
-			elog.Info("batchStat retry 0", host, err)
-			host = l.nextRsHost()
-			bucket = l.newBucket(host, "")
-			r, err = bucket.BatchStat(nil, paths[i:i+size]...)
-			if err != nil {
-				failHostName(host)
-				elog.Info("batchStat retry 1", host, err)
-				return []*FileStat{}
-			} else {
-				succeedHostName(host)
+			// 整批失败时逐个标记为查询失败，调用方可以只重试这些文件，网络错误的 Code 为 599
+			if first == nil {
+				first = err
 			}
-		} else {
-			succeedHostName(host)
+			code := httputil.DetectCode(err)
+			for _, name := range array {
+				stats = append(stats, &FileStat{Name: name, Size: -1, Status: StatError, Code: code, Error: err.Error()})
+			}
+			continue
 		}
-		for j, v := range r {
-			if v.Code != 200 {
-				stats = append(stats, &FileStat{
-					Name: array[j],
-					Size: -1,
-				})
-				elog.Warn("bad file", array[j])
-			} else {
-				stats = append(stats, &FileStat{
-					Name: array[j],
-					Size: v.Data.Fsize,
-				})
+		for j := range r {
+			stat := newFileStat(array[j], &r[j])
+			if stat.Status != StatOK {
+				elog.Log(slog.LevelWarn, "bad file", slog.Op("stat"), slog.Key(array[j]), slog.F("code", r[j].Code), slog.F("error", r[j].Error))
 			}
+			stats = append(stats, stat)
 		}
 	}
-	return stats
+	return stats, first
 }
 
-func (l *Lister) ListPrefix(prefix string) []string {
-	rsHost := l.nextRsHost()
-	rsfHost := l.nextRsfHost()
-	bucket := l.newBucket(rsHost, rsfHost)
-	var files []string
-	marker := ""
-	for {
-		r, _, out, err := bucket.List(nil, prefix, "", marker, 1000)
-		if err != nil && err != io.EOF {
-			failHostName(rsfHost)
-			elog.Info("ListPrefix retry 0", rsfHost, err)
-			rsfHost = l.nextRsfHost()
-			bucket = l.newBucket(rsHost, rsfHost)
-			r, _, out, err = bucket.List(nil, prefix, "", "", 1000)
-			if err != nil {
-				failHostName(rsfHost)
-				elog.Info("ListPrefix retry 1", rsfHost, err)
-				return []string{}
-			} else {
-				succeedHostName(rsfHost)
-			}
-		} else {
-			succeedHostName(rsfHost)
-		}
-		elog.Info("list len", marker, len(r))
-		for _, v := range r {
-			files = append(files, v.Key)
-		}
+// ListPrefix 列举 prefix 下的所有文件名，失败时返回 *Error
+func (l *Lister) ListPrefix(prefix string) ([]string, error) {
+	items, err := l.listItems(prefix)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]string, 0, len(items))
+	for _, v := range items {
+		files = append(files, v.Key)
+	}
+	return files, nil
+}
+
+// SetFilter 设置列举时使用的过滤条件，传入 nil 列举所有文件
+func (l *Lister) SetFilter(f Filter) {
+	l.filter = f
+}
 
-		if out == "" {
-			break
+// ListPrefixWithFilter 列举 prefix 下同时满足 f 和配置中过滤条件的文件
+func (l *Lister) ListPrefixWithFilter(prefix string, f Filter) ([]kodo.ListItem, error) {
+	items, err := l.listItems(prefix)
+	if err != nil || f == nil {
+		return items, err
+	}
+	selected := items[:0]
+	for _, item := range items {
+		if f.Match(listFilterItem(item.Key, item.Fsize, item.PutTime)) {
+			selected = append(selected, item)
 		}
-		marker = out
 	}
-	return files
+	return selected, nil
+}
+
+// listItems 列举 prefix 下满足过滤条件的所有文件，包括大小和 hash 等信息
+func (l *Lister) listItems(prefix string) ([]kodo.ListItem, error) {
+	var items []kodo.ListItem
+	it := l.NewListIterator(prefix, "")
+	for it.Next() {
+		items = append(items, it.Item())
+	}
+	return items, it.Err()
 }
 
 func NewLister(c *Config) *Lister {
@@ -272,6 +271,11 @@ func NewLister(c *Config) *Lister {
 		queryer = NewQueryer(c)
 	}
 
+	filter, err := NewFilter(&c.Filter)
+	if err != nil {
+		elog.Log(slog.LevelError, "invalid filter", slog.Err(err))
+	}
+
 	lister := Lister{
 		bucket:      c.Bucket,
 		rsHosts:     dupStrings(c.RsHosts),
@@ -279,6 +283,20 @@ func NewLister(c *Config) *Lister {
 		rsfHosts:    dupStrings(c.RsfHosts),
 		credentials: mac,
 		queryer:     queryer,
+		filter:      filter,
+		retry:       c.Retry.policy(defaultRsRetryTimes),
+		batchRetry:  c.Retry.policy(defaultBatchRetryTimes),
+		rsSelector:  newHostSelector(c),
+		rsfSelector: newHostSelector(c),
+		pool:        c.hostPool(),
+		metrics:     c.Metrics,
+		useHTTPS:    c.UseHTTPS,
+		transport:   transportWithTLS(c),
+
+		batchConcurrency: c.BatchConcurrency,
+	}
+	if lister.batchConcurrency <= 0 {
+		lister.batchConcurrency = 4
 	}
 	shuffleHosts(lister.rsHosts)
 	shuffleHosts(lister.rsfHosts)
@@ -301,11 +319,12 @@ func (l *Lister) newBucket(host, rsfHost string) kodo.Bucket {
 		RSHost:    host,
 		RSFHost:   rsfHost,
 		UpHosts:   l.upHosts,
+		Transport: l.transport,
 	}
 	client := kodo.NewWithoutZone(&cfg)
 	b, err := client.BucketWithSafe(l.bucket)
 	if err != nil {
-		elog.Error("Get Bucket(%s) failed: %+v", l.bucket, err)
+		elog.Log(slog.LevelError, "get bucket failed", slog.F("bucket", l.bucket), slog.Err(err))
 	}
 	return b
 }
@@ -0,0 +1,154 @@
+package operation
+
+import (
+	"context"
+	"io"
+
+	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/api.v7/kodo"
+	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/x/slog.v1"
+)
+
+const listPageLimit = 1000
+
+// ListIterator 逐页列举 prefix 下的文件，内存中最多只保留一页结果。
+//
+//	it := lister.NewListIterator(prefix, "")
+//	for it.Next() {
+//		item := it.Item()
+//		...
+//	}
+//	if err := it.Err(); err != nil {
+//		// 可以保存 it.Marker()，之后用它创建新的 ListIterator 继续列举
+//	}
+type ListIterator struct {
+	lister     *Lister
+	prefix     string
+	pageMarker string // 获取当前页使用的 marker
+	nextMarker string // 获取下一页使用的 marker，为空表示没有下一页
+	page       []kodo.ListItem
+	pos        int
+	err        error
+	started    bool
+}
+
+// NewListIterator 从 marker 开始列举 prefix 下满足过滤条件的文件，marker 为空表示从头开始
+func (l *Lister) NewListIterator(prefix, marker string) *ListIterator {
+	return &ListIterator{
+		lister:     l,
+		prefix:     prefix,
+		pageMarker: marker,
+		nextMarker: marker,
+	}
+}
+
+// Next 移动到下一个文件，没有更多文件或者出错时返回 false
+func (it *ListIterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	it.pos++
+	for it.pos >= len(it.page) {
+		if it.started && it.nextMarker == "" {
+			return false
+		}
+		if !it.fetch() {
+			return false
+		}
+	}
+	return true
+}
+
+// Item 返回当前文件，只有 Next 返回 true 之后才有效
+func (it *ListIterator) Item() kodo.ListItem {
+	return it.page[it.pos]
+}
+
+// Err 返回导致列举中止的错误，正常结束时返回 nil
+func (it *ListIterator) Err() error {
+	return it.err
+}
+
+// Marker 返回可以用来继续列举的 marker，用它创建的 ListIterator 不会遗漏当前文件之后的任何文件，
+// 但是可能重复返回当前页中已经处理过的文件。返回空字符串并且 Err 为 nil 表示已经列举完毕
+func (it *ListIterator) Marker() string {
+	if it.pos < len(it.page)-1 || it.err != nil {
+		return it.pageMarker
+	}
+	return it.nextMarker
+}
+
+// fetch 获取下一页
+func (it *ListIterator) fetch() bool {
+	l := it.lister
+	r, _, out, err := l.listPage(it.prefix, "", it.nextMarker)
+	if err != nil {
+		it.err = err
+		return false
+	}
+	it.started = true
+	it.pageMarker, it.nextMarker = it.nextMarker, out
+	it.page, it.pos = it.page[:0], 0
+	for _, v := range r {
+		if matchFilter(l.filter, listFilterItem(v.Key, v.Fsize, v.PutTime)) {
+			it.page = append(it.page, v)
+		}
+	}
+	return true
+}
+
+// listPage 获取一页列举结果，失败时换一个 rsf 节点用同一个 marker 重试
+func (l *Lister) listPage(prefix, delimiter, marker string) (items []kodo.ListItem, prefixes []string, out string, err error) {
+	err = l.retry.Do(context.Background(), func(i int) (err error) {
+		rsfHost := l.nextRsfHost()
+		bucket := l.newBucket(l.nextRsHost(), rsfHost)
+		start := startRequest(l.rsfSelector, rsfHost)
+		items, prefixes, out, err = bucket.List(nil, prefix, delimiter, marker, listPageLimit)
+		if err == io.EOF {
+			observeRequest(l.metrics, l.rsfSelector, "list", rsfHost, i, start, nil)
+		} else {
+			observeRequest(l.metrics, l.rsfSelector, "list", rsfHost, i, start, err)
+		}
+		if err != nil && err != io.EOF {
+			err = wrapError("list", prefix, rsfHost, err)
+			l.pool.Fail(rsfHost)
+			elog.Log(slog.LevelInfo, "request failed", slog.Op("list"), slog.Key(prefix), slog.Host(rsfHost), slog.Reqid(reqidOf(err)), slog.F("marker", marker), slog.F("attempt", i), slog.Err(err))
+			return err
+		}
+		l.pool.Succeed(rsfHost)
+		elog.Log(slog.LevelDebug, "list page", slog.Key(prefix), slog.Host(rsfHost), slog.F("marker", marker), slog.F("items", len(items)), slog.F("prefixes", len(prefixes)))
+		return nil
+	})
+	return
+}
+
+// DirList 是按目录列举的结果
+type DirList struct {
+	CommonPrefixes []string        `json:"common_prefixes"` // 下一级“子目录”，以 delimiter 结尾
+	Items          []kodo.ListItem `json:"items"`           // 当前层级满足过滤条件的文件
+}
+
+// ListDir 列举 prefix 下一级的“子目录”和文件，delimiter 为空时使用 "/"。
+// 查看 a/ 目录时 prefix 应该是 "a/"
+func (l *Lister) ListDir(prefix, delimiter string) (*DirList, error) {
+	if delimiter == "" {
+		delimiter = "/"
+	}
+	ret := &DirList{CommonPrefixes: []string{}, Items: []kodo.ListItem{}}
+	marker := ""
+	for {
+		items, prefixes, out, err := l.listPage(prefix, delimiter, marker)
+		if err != nil {
+			return nil, err
+		}
+		ret.CommonPrefixes = append(ret.CommonPrefixes, prefixes...)
+		for _, v := range items {
+			if matchFilter(l.filter, listFilterItem(v.Key, v.Fsize, v.PutTime)) {
+				ret.Items = append(ret.Items, v)
+			}
+		}
+		if out == "" {
+			return ret, nil
+		}
+		marker = out
+	}
+}
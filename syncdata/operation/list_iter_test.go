@@ -0,0 +1,174 @@
+package operation
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func newTestLister(rs *fakeRsServer, attempts int, keys ...string) *Lister {
+	for _, key := range keys {
+		rs.put(key, []byte(key))
+	}
+	c := newTestConfig(rs)
+	c.Retry.MaxAttempts = attempts
+	return NewLister(c)
+}
+
+func iterKeys(it *ListIterator, n int) (keys []string) {
+	for (n < 0 || len(keys) < n) && it.Next() {
+		keys = append(keys, it.Item().Key)
+	}
+	return
+}
+
+func TestListIterator(t *testing.T) {
+	rs := newFakeRsServer()
+	defer rs.Close()
+	rs.pageSize = 2
+	l := newTestLister(rs, 1, "p/1", "p/2", "p/3", "p/4", "p/5", "q/1")
+
+	it := l.NewListIterator("p/", "")
+	if keys := iterKeys(it, -1); fmt.Sprint(keys) != "[p/1 p/2 p/3 p/4 p/5]" || it.Err() != nil {
+		t.Fatal("unexpected list result:", keys, it.Err())
+	}
+	if it.Marker() != "" {
+		t.Fatal("marker should be empty after listing all files:", it.Marker())
+	}
+	if fmt.Sprint(rs.lists) != "[ p/2 p/4]" {
+		t.Fatal("each page should be requested once:", rs.lists)
+	}
+	if it.Next() {
+		t.Fatal("Next should keep returning false")
+	}
+
+	it = l.NewListIterator("none/", "")
+	if it.Next() || it.Err() != nil || it.Marker() != "" {
+		t.Fatal("empty prefix should end without error")
+	}
+}
+
+func TestListIteratorMarker(t *testing.T) {
+	rs := newFakeRsServer()
+	defer rs.Close()
+	rs.pageSize = 2
+	l := newTestLister(rs, 1, "1", "2", "3", "4", "5")
+
+	// 在页中间停止时 Marker 指向当前页的开头，续传可能重复当前页但不会遗漏
+	it := l.NewListIterator("", "")
+	keys := iterKeys(it, 3)
+	if marker := it.Marker(); marker != "2" {
+		t.Fatal("marker should point to the start of current page:", marker)
+	}
+	resumed := iterKeys(l.NewListIterator("", it.Marker()), -1)
+	if fmt.Sprint(keys, resumed) != "[1 2 3] [3 4 5]" {
+		t.Fatal("unexpected resumed result:", keys, resumed)
+	}
+
+	// 处理完当前页的最后一个文件后 Marker 指向下一页
+	it = l.NewListIterator("", "")
+	iterKeys(it, 4)
+	if marker := it.Marker(); marker != "4" {
+		t.Fatal("marker should point to the next page:", marker)
+	}
+}
+
+func TestListIteratorError(t *testing.T) {
+	rs := newFakeRsServer()
+	defer rs.Close()
+	rs.pageSize = 2
+	l := newTestLister(rs, 1, "1", "2", "3", "4", "5")
+
+	it := l.NewListIterator("", "")
+	keys := iterKeys(it, 2)
+	rs.m.Lock()
+	rs.failLists = 1
+	rs.m.Unlock()
+	if it.Next() {
+		t.Fatal("Next should stop on error")
+	}
+	if !errors.Is(it.Err(), ErrServer) {
+		t.Fatal("expect server error, but got:", it.Err())
+	}
+	if it.Next() {
+		t.Fatal("Next should keep returning false after error")
+	}
+
+	resumed := iterKeys(l.NewListIterator("", it.Marker()), -1)
+	seen := map[string]bool{}
+	for _, key := range append(keys, resumed...) {
+		seen[key] = true
+	}
+	if len(seen) != 5 {
+		t.Fatal("no file should be missed after resuming:", keys, resumed)
+	}
+}
+
+func TestListIteratorRetry(t *testing.T) {
+	rs := newFakeRsServer()
+	defer rs.Close()
+	rs.pageSize = 2
+	l := newTestLister(rs, 2, "1", "2", "3")
+	rs.failLists = 1
+
+	it := l.NewListIterator("", "")
+	if keys := iterKeys(it, -1); len(keys) != 3 || it.Err() != nil {
+		t.Fatal("list should succeed after retry:", keys, it.Err())
+	}
+	if fmt.Sprint(rs.lists) != "[  2]" {
+		t.Fatal("failed page should be retried with the same marker:", rs.lists)
+	}
+}
+
+func TestListIteratorFilter(t *testing.T) {
+	rs := newFakeRsServer()
+	defer rs.Close()
+	rs.pageSize = 2
+	l := newTestLister(rs, 1, "a.jpg", "b.png", "c.png", "d.jpg")
+	f, _ := NewFilter(&FilterConfig{Include: []string{"*.jpg"}})
+	l.SetFilter(f)
+
+	if keys := iterKeys(l.NewListIterator("", ""), -1); fmt.Sprint(keys) != "[a.jpg d.jpg]" {
+		t.Fatal("unexpected filtered result:", keys)
+	}
+	items, err := l.ListPrefixWithFilter("", AndFilter(nil))
+	if err != nil || len(items) != 2 {
+		t.Fatal("configured filter should be applied:", items, err)
+	}
+}
+
+func TestListDir(t *testing.T) {
+	rs := newFakeRsServer()
+	defer rs.Close()
+	rs.pageSize = 2
+	l := newTestLister(rs, 1, "a/1", "a/b/1", "a/b/2", "a/c/1", "a/d.jpg", "a/e.png", "b/1")
+	f, _ := NewFilter(&FilterConfig{Exclude: []string{"*.png"}})
+	l.SetFilter(f)
+
+	ret, err := l.ListDir("a/", "")
+	if err != nil {
+		t.Fatal("list dir failed:", err)
+	}
+	var items []string
+	for _, item := range ret.Items {
+		items = append(items, item.Key)
+	}
+	if fmt.Sprint(ret.CommonPrefixes, items) != "[a/b/ a/c/] [a/1 a/d.jpg]" {
+		t.Fatal("unexpected dir list:", ret.CommonPrefixes, items)
+	}
+	if len(rs.lists) < 2 {
+		t.Fatal("all pages should be listed:", rs.lists)
+	}
+
+	if ret, err = l.ListDir("", "/"); err != nil || fmt.Sprint(ret.CommonPrefixes) != "[a/ b/]" || len(ret.Items) != 0 {
+		t.Fatal("unexpected root dir list:", ret, err)
+	}
+	if ret, err = l.ListDir("none/", ""); err != nil || ret.CommonPrefixes == nil || ret.Items == nil {
+		t.Fatal("empty dir should return empty lists:", ret, err)
+	}
+
+	rs.failLists = 1
+	if _, err = l.ListDir("a/", ""); !errors.Is(err, ErrServer) {
+		t.Fatal("expect server error, but got:", err)
+	}
+}
@@ -0,0 +1,81 @@
+package operation
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestListStat(t *testing.T) {
+	rs := newFakeRsServer()
+	defer rs.Close()
+	l := newTestLister(rs, 1, "a", "b")
+	rs.opHook = func(op string, n int) int {
+		if op == "/stat/"+encodedEntry("b") {
+			return 599
+		}
+		return 0
+	}
+
+	stats, err := l.ListStat([]string{"a", "missing", "b"})
+	if err != nil || len(stats) != 3 {
+		t.Fatal("expect one result for each file:", stats, err)
+	}
+	a := stats[0]
+	if a.Name != "a" || a.Status != StatOK || a.Size != 1 || a.Hash == "" || a.PutTime == 0 || a.Code != 200 {
+		t.Fatalf("unexpected stat: %+v", a)
+	}
+	if missing := stats[1]; !missing.NotFound() || missing.Size != -1 || missing.Code != 612 {
+		t.Fatalf("unexpected stat: %+v", missing)
+	}
+	if b := stats[2]; b.Status != StatError || b.NotFound() || b.Size != -1 || b.Code != 599 || b.Error == "" {
+		t.Fatalf("unexpected stat: %+v", b)
+	}
+}
+
+func TestListStatBatches(t *testing.T) {
+	rs := newFakeRsServer()
+	defer rs.Close()
+	l := newTestLister(rs, 1, "a")
+	rs.failBatches = 1
+
+	paths := make([]string, batchLimit+1)
+	for i := range paths {
+		paths[i] = fmt.Sprint("key-", i)
+	}
+	paths[batchLimit] = "a"
+	stats, err := l.ListStat(paths)
+	var e *Error
+	if !errors.As(err, &e) || e.Op != "batchStat" || e.Code != 503 || !errors.Is(err, ErrServer) {
+		t.Fatal("whole batch failure should be returned:", err)
+	}
+	if fmt.Sprint(rs.batches) != fmt.Sprint([]int{batchLimit, 1}) {
+		t.Fatal("paths should be split by batch limit:", rs.batches)
+	}
+	if len(stats) != len(paths) {
+		t.Fatal("expect one result for each file:", len(stats))
+	}
+	for _, stat := range stats[:batchLimit] {
+		if stat.Status != StatError || stat.Code != 503 || stat.Size != -1 {
+			t.Fatalf("files in the failed batch should be marked as error: %+v", stat)
+		}
+	}
+	if last := stats[batchLimit]; last.Name != "a" || last.Status != StatOK {
+		t.Fatalf("unexpected stat: %+v", last)
+	}
+}
+
+func TestListPrefix(t *testing.T) {
+	rs := newFakeRsServer()
+	defer rs.Close()
+	l := newTestLister(rs, 1, "p/1", "p/2", "q")
+
+	files, err := l.ListPrefix("p/")
+	if err != nil || fmt.Sprint(files) != "[p/1 p/2]" {
+		t.Fatal("list prefix failed:", files, err)
+	}
+	rs.failLists = 1
+	if files, err = l.ListPrefix("p/"); !errors.Is(err, ErrServer) || files != nil {
+		t.Fatal("list error should be returned:", files, err)
+	}
+}
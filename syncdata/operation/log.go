@@ -2,18 +2,21 @@ package operation
 
 import (
 	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/api.v8/kodocli"
+	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/x/slog.v1"
 )
 
-// elog is embedded logger
-var elog kodocli.Ilog
+// elog is embedded logger，既可以按级别输出（elog.Info(v...)），也可以输出带字段的结构化日志（elog.Log）。
+// 默认通过 kodocli.NewLogger() 输出到标准错误
+var elog = slog.ToLeveled(slog.FromLeveled(kodocli.NewLogger()))
 
+// SetLogger 设置 operation 和 kodocli 使用的日志，结构化日志的字段以 k=v 的形式附加在消息后面
 func SetLogger(logger kodocli.Ilog) {
-	elog = logger
+	elog.Logger = slog.FromLeveled(logger)
 	kodocli.SetLogger(logger)
 }
 
-func init() {
-	if elog == nil {
-		elog = kodocli.NewLogger()
-	}
+// SetStructuredLogger 设置 operation 和 kodocli 使用的结构化日志，例如 slog.NewJSON(os.Stderr, slog.LevelInfo)
+func SetStructuredLogger(logger slog.Logger) {
+	elog.Logger = logger
+	kodocli.SetLogger(slog.ToLeveled(logger))
 }
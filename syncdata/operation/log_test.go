@@ -0,0 +1,116 @@
+package operation
+
+import (
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/api.v8/kodocli"
+	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/x/slog.v1"
+)
+
+type logEntry struct {
+	level  slog.Level
+	msg    string
+	fields map[string]interface{}
+}
+
+// logRecorder 记录结构化日志，用于检查日志的级别和字段
+type logRecorder struct {
+	m       sync.Mutex
+	entries []logEntry
+}
+
+func (r *logRecorder) Log(level slog.Level, msg string, fields ...slog.Field) {
+	e := logEntry{level: level, msg: msg, fields: map[string]interface{}{}}
+	for _, f := range fields {
+		e.fields[f.Key] = f.Value
+	}
+	r.m.Lock()
+	r.entries = append(r.entries, e)
+	r.m.Unlock()
+}
+
+func (r *logRecorder) find(msg string) *logEntry {
+	r.m.Lock()
+	defer r.m.Unlock()
+	for i := range r.entries {
+		if r.entries[i].msg == msg {
+			return &r.entries[i]
+		}
+	}
+	return nil
+}
+
+func useLogger(t *testing.T, logger slog.Logger) {
+	saved := elog.Logger
+	SetStructuredLogger(logger)
+	t.Cleanup(func() {
+		elog.Logger = saved
+		kodocli.SetLogger(kodocli.NewLogger())
+	})
+}
+
+func TestStructuredLogger(t *testing.T) {
+	r := &logRecorder{}
+	useLogger(t, r)
+	rs := newFakeRsServer()
+	defer rs.Close()
+	rs.failLists = 1
+	l := newTestLister(rs, 2, "p/a")
+
+	if _, err := l.ListPrefix("p/"); err != nil {
+		t.Fatal("list failed:", err)
+	}
+	e := r.find("request failed")
+	if e == nil || e.level != slog.LevelInfo {
+		t.Fatal("failed request should be logged:", r.entries)
+	}
+	if e.fields["op"] != "list" || e.fields["key"] != "p/" || e.fields["host"] != rs.URL || e.fields["attempt"] != 0 || e.fields["error"] == nil {
+		t.Fatalf("unexpected fields: %v", e.fields)
+	}
+	if e = r.find("list page"); e == nil || e.level != slog.LevelDebug || e.fields["items"] != 1 {
+		t.Fatal("list page should be logged at debug level:", r.entries)
+	}
+
+	if _, err := l.ListStat([]string{"p/a", "p/missing"}); err != nil {
+		t.Fatal("stat failed:", err)
+	}
+	if e = r.find("bad file"); e == nil || e.level != slog.LevelWarn || e.fields["key"] != "p/missing" || e.fields["code"] != 612 {
+		t.Fatal("bad file should be logged with fields:", r.entries)
+	}
+}
+
+func TestLoggerNoStdout(t *testing.T) {
+	useLogger(t, &logRecorder{})
+	stdout := os.Stdout
+	f, err := ioutil.TempFile(t.TempDir(), "stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	os.Stdout = f
+	defer func() { os.Stdout = stdout }()
+
+	rs := newFakeRsServer()
+	defer rs.Close()
+	ioSrv := newFakeIoServer()
+	defer ioSrv.Close()
+	data := randomBytes(2*testPartSize + 10)
+	ioSrv.put("key", data)
+	rs.put("key", data)
+	d := newTestDownloader(ioSrv, 2, RetryConfig{MaxAttempts: 1})
+	d.lister = NewLister(newTestConfig(rs))
+	if _, err = d.DownloadFile("key", t.TempDir()+"/key"); err != nil {
+		t.Fatal("download failed:", err)
+	}
+	if _, err = d.lister.ListPrefix(""); err != nil {
+		t.Fatal("list failed:", err)
+	}
+
+	os.Stdout = stdout
+	if out, _ := ioutil.ReadFile(f.Name()); len(out) != 0 {
+		t.Fatalf("library code should not write to stdout: %q", out)
+	}
+}
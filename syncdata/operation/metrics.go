@@ -0,0 +1,36 @@
+package operation
+
+import (
+	"time"
+
+	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/x/hostpool.v1"
+	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/x/httputil.v1"
+	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/x/metrics.v1"
+	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/x/slog.v1"
+)
+
+// startRequest 通知 sel 即将向 host 发出请求，返回请求的开始时间
+func startRequest(sel hostpool.Selector, host string) time.Time {
+	sel.Start(host)
+	return time.Now()
+}
+
+// collector 返回 m，为空时返回 metrics.Default()。对象中保存的是配置的 Collector，
+// 因此创建对象之后调用 metrics.SetDefault 仍然生效
+func collector(m metrics.Collector) metrics.Collector {
+	if m != nil {
+		return m
+	}
+	return metrics.Default()
+}
+
+// observeRequest 把请求的结果反馈给 sel 并上报到 collector(m)，attempt 大于 0 时同时记录一次重试
+func observeRequest(m metrics.Collector, sel hostpool.Selector, op, host string, attempt int, start time.Time, err error) {
+	m, code, elapsed := collector(m), httputil.DetectCode(err), time.Since(start)
+	sel.Done(host, elapsed, hostpool.HostFailed(err))
+	m.ObserveRequest(op, host, code, elapsed)
+	elog.Log(slog.LevelDebug, "request", slog.Op(op), slog.Host(host), slog.F("code", code), slog.Duration(elapsed))
+	if attempt > 0 {
+		m.IncRetry(op)
+	}
+}
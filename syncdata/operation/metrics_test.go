@@ -0,0 +1,73 @@
+package operation
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/x/metrics.v1"
+)
+
+func metricsText(t *testing.T, reg *metrics.Registry) string {
+	var b bytes.Buffer
+	if _, err := reg.WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
+
+func TestConfigMetrics(t *testing.T) {
+	rs := newFakeRsServer()
+	defer rs.Close()
+	rs.put("a", []byte("a"))
+	rs.failLists = 1
+	reg := metrics.NewRegistry()
+	c := newTestConfig(rs)
+	c.Retry.MaxAttempts = 2
+	c.Metrics = reg
+
+	if _, err := NewLister(c).ListPrefix(""); err != nil {
+		t.Fatal("list failed:", err)
+	}
+	text := metricsText(t, reg)
+	for _, expected := range []string{
+		`us3_requests_total{op="list",host="` + rs.URL + `",code="503"} 1`,
+		`us3_requests_total{op="list",host="` + rs.URL + `",code="200"} 1`,
+		`us3_retries_total{op="list"} 1`,
+	} {
+		if !strings.Contains(text, expected) {
+			t.Fatalf("metrics should contain %s:\n%s", expected, text)
+		}
+	}
+	if metrics.Default() != metrics.Nop {
+		t.Fatal("default collector should not be changed")
+	}
+}
+
+func TestUploadRetryMetrics(t *testing.T) {
+	srv := newFakeUpServer()
+	defer srv.Close()
+	srv.failPart, srv.failCode = 2, http.StatusInternalServerError
+	reg := metrics.NewRegistry()
+	uploader := NewUploader(&Config{
+		UpHosts:       []string{srv.URL},
+		Bucket:        "bucket",
+		Ak:            "ak",
+		Sk:            "sk",
+		UpConcurrency: 1,
+		Retry:         RetryConfig{MaxAttempts: 2, InitialBackoff: 1},
+		Metrics:       reg,
+	})
+
+	if err := uploader.Upload(writeTempFile(t, 2*4*1024*1024), "key"); err == nil {
+		t.Fatal("expect upload to fail")
+	}
+	text := metricsText(t, reg)
+	if strings.Contains(text, `us3_retries_total{op="upload"}`) {
+		t.Fatalf("whole upload retries should not be counted again:\n%s", text)
+	}
+	if !strings.Contains(text, `us3_retries_total{op="upload_part"}`) {
+		t.Fatalf("part retries should be counted:\n%s", text)
+	}
+}
@@ -0,0 +1,86 @@
+package operation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/api.v7/kodo"
+)
+
+var ErrTooManyObjects = errors.New("too many objects")
+
+// PrefixOptions 控制按前缀批量操作的行为
+type PrefixOptions struct {
+	DryRun     bool                  // 只列举并返回将要执行的操作，结果中的 Code 为 0
+	MaxObjects int                   // 前缀下的文件数超过该值时不执行任何操作，0 表示不限制
+	DestBucket string                // 复制、移动的目标 bucket，为空表示当前 bucket
+	OnProgress func(done, total int) // 每批完成后通知已经完成的文件数，可能被并发调用
+}
+
+// DeletePrefix 删除 prefix 下满足过滤条件的所有文件，为了避免误删整个 bucket，prefix 不能为空
+func (l *Lister) DeletePrefix(prefix string, opts PrefixOptions) ([]BatchResult, error) {
+	if prefix == "" {
+		return nil, errors.New("delete: empty prefix")
+	}
+	keys, err := l.listKeysForPrefixOp(prefix, opts)
+	if err != nil {
+		return nil, err
+	}
+	if opts.DryRun {
+		results := make([]BatchResult, len(keys))
+		for i, key := range keys {
+			results[i].Key = key
+		}
+		return results, nil
+	}
+	return l.deleteMany(context.Background(), keys, opts.OnProgress)
+}
+
+// CopyPrefix 把 srcPrefix 下的文件复制到 dstPrefix 下，key 中 srcPrefix 之后的部分保持不变
+func (l *Lister) CopyPrefix(srcPrefix, dstPrefix string, opts PrefixOptions) ([]BatchResult, error) {
+	return l.pairsPrefix("copy", kodo.URICopy, srcPrefix, dstPrefix, opts)
+}
+
+// MovePrefix 把 srcPrefix 下的文件移动到 dstPrefix 下，key 中 srcPrefix 之后的部分保持不变
+func (l *Lister) MovePrefix(srcPrefix, dstPrefix string, opts PrefixOptions) ([]BatchResult, error) {
+	return l.pairsPrefix("move", kodo.URIMove, srcPrefix, dstPrefix, opts)
+}
+
+func (l *Lister) pairsPrefix(name string, uri func(bucketSrc, keySrc, bucketDest, keyDest string) string, srcPrefix, dstPrefix string,
+	opts PrefixOptions) ([]BatchResult, error) {
+
+	if srcPrefix == dstPrefix && (opts.DestBucket == "" || opts.DestBucket == l.bucket) {
+		return nil, fmt.Errorf("%s: source and destination are the same", name)
+	}
+	keys, err := l.listKeysForPrefixOp(srcPrefix, opts)
+	if err != nil {
+		return nil, err
+	}
+	pairs := make([]KeyPair, len(keys))
+	for i, key := range keys {
+		pairs[i] = KeyPair{Src: key, Dest: dstPrefix + strings.TrimPrefix(key, srcPrefix), DestBucket: opts.DestBucket}
+	}
+	if opts.DryRun {
+		results := make([]BatchResult, len(pairs))
+		for i, pair := range pairs {
+			results[i].Key, results[i].Dest = pair.Src, pair.Dest
+		}
+		return results, nil
+	}
+	return l.batchPairs(context.Background(), name, uri, pairs, opts.OnProgress)
+}
+
+// listKeysForPrefixOp 列举 prefix 下的所有 key，超过 MaxObjects 时返回 ErrTooManyObjects
+func (l *Lister) listKeysForPrefixOp(prefix string, opts PrefixOptions) ([]string, error) {
+	var keys []string
+	it := l.NewListIterator(prefix, "")
+	for it.Next() {
+		keys = append(keys, it.Item().Key)
+		if opts.MaxObjects > 0 && len(keys) > opts.MaxObjects {
+			return nil, fmt.Errorf("%w: more than %d objects under %q", ErrTooManyObjects, opts.MaxObjects, prefix)
+		}
+	}
+	return keys, it.Err()
+}
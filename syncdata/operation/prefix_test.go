@@ -0,0 +1,62 @@
+package operation
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestDeletePrefix(t *testing.T) {
+	rs := newFakeRsServer()
+	defer rs.Close()
+	l := newTestLister(rs, 1, "p/1", "p/2.log", "p/a/3", "q/1")
+	f, _ := NewFilter(&FilterConfig{Exclude: []string{"*.log"}})
+	l.SetFilter(f)
+
+	if _, err := l.DeletePrefix("", PrefixOptions{}); err == nil {
+		t.Fatal("empty prefix should be rejected")
+	}
+	results, err := l.DeletePrefix("p/", PrefixOptions{DryRun: true})
+	if err != nil || len(results) != 2 || results[0].Key != "p/1" || results[0].Code != 0 || len(rs.batches) != 0 {
+		t.Fatal("unexpected dry run:", results, err)
+	}
+	if _, err = l.DeletePrefix("p/", PrefixOptions{MaxObjects: 1}); !errors.Is(err, ErrTooManyObjects) {
+		t.Fatal("expect too many objects:", err)
+	}
+
+	var progress []int
+	results, err = l.DeletePrefix("p/", PrefixOptions{OnProgress: func(done, total int) { progress = append(progress, done, total) }})
+	if err != nil || len(results) != 2 || !results[1].OK() {
+		t.Fatal("delete prefix failed:", results, err)
+	}
+	if fmt.Sprint(progress) != "[2 2]" {
+		t.Fatal("unexpected progress:", progress)
+	}
+	if got := fmt.Sprint(rs.keys()); got != "[bucket:p/2.log bucket:q/1]" {
+		t.Fatal("filtered files should be kept:", got)
+	}
+}
+
+func TestCopyAndMovePrefix(t *testing.T) {
+	rs := newFakeRsServer()
+	defer rs.Close()
+	l := newTestLister(rs, 1, "src/1", "src/a/2", "other")
+
+	if _, err := l.CopyPrefix("src/", "src/", PrefixOptions{}); err == nil {
+		t.Fatal("copying to the same prefix should be rejected")
+	}
+	results, err := l.CopyPrefix("src/", "dst/", PrefixOptions{DryRun: true})
+	if err != nil || len(results) != 2 || results[1].Key != "src/a/2" || results[1].Dest != "dst/a/2" {
+		t.Fatal("unexpected dry run:", results, err)
+	}
+	if _, err = l.CopyPrefix("src/", "dst/", PrefixOptions{}); err != nil {
+		t.Fatal("copy prefix failed:", err)
+	}
+	if _, err = l.MovePrefix("src/", "src/", PrefixOptions{DestBucket: "backup"}); err != nil {
+		t.Fatal("move prefix to another bucket failed:", err)
+	}
+	expected := "[backup:src/1 backup:src/a/2 bucket:dst/1 bucket:dst/a/2 bucket:other]"
+	if got := fmt.Sprint(rs.keys()); got != expected {
+		t.Fatalf("unexpected files:\n%s\nexpect:\n%s", got, expected)
+	}
+}
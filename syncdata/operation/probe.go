@@ -0,0 +1,50 @@
+package operation
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/x/hostpool.v1"
+)
+
+// ProbeConfig 是后台探测节点的配置，时间的单位都是毫秒。没有配置 interval 时不探测，
+// 熔断的节点在 1 分钟后自动进入半开状态；探测时熔断的节点只有探测成功后才会重新启用
+type ProbeConfig struct {
+	Interval int64 `json:"interval" toml:"interval"`
+	Timeout  int64 `json:"timeout" toml:"timeout"`
+}
+
+// HostsHealth 返回由 c 创建的对象使用的节点健康状态表
+func HostsHealth(c *Config) []hostpool.HostHealth {
+	return c.hostPool().Health()
+}
+
+// StartProber 按 c.Probe 在后台探测 c 中配置的以及从 uc 查询到的节点，探测结果记录在 c.HostPool 中，返回停止探测的函数。
+// 从 uc 查询到的节点包括备用区域，主区域的节点全部熔断后也会继续探测，恢复后重新使用主区域。
+// 没有配置 probe.interval 时不探测，返回的函数什么也不做
+func StartProber(c *Config) (stop func()) {
+	if c.Probe.Interval <= 0 {
+		return func() {}
+	}
+	var queryer *Queryer
+	if len(c.UcHosts) > 0 {
+		queryer = NewQueryer(c)
+	}
+	interval := time.Duration(c.Probe.Interval) * time.Millisecond
+	timeout := time.Duration(c.Probe.Timeout) * time.Millisecond
+
+	probe := hostpool.HTTPProbe(&http.Client{Transport: transportWithTLS(c)})
+	hosts := func() []string {
+		var hosts []string
+		for _, h := range [][]string{c.UpHosts, c.RsHosts, c.RsfHosts, c.IoHosts, c.UcHosts} {
+			hosts = append(hosts, h...)
+		}
+		if queryer != nil {
+			for _, service := range []string{"up", "rs", "rsf", "io"} {
+				hosts = append(hosts, queryer.QueryAllHosts(c.UseHTTPS, service)...)
+			}
+		}
+		return hosts
+	}
+	return hostpool.NewProber(c.hostPool(), hosts, probe, interval, timeout).Start().Stop
+}
@@ -0,0 +1,143 @@
+package operation
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// Progress 描述一次上传或下载的进度
+type Progress struct {
+	Key   string        `json:"key"`
+	Done  int64         `json:"done"`  // 已经传输的字节数
+	Total int64         `json:"total"` // 总字节数，未知时为 -1
+	Rate  float64       `json:"rate"`  // 当前速率，单位 bytes/s
+	ETA   time.Duration `json:"eta"`   // 预计剩余时间，未知时为 -1
+}
+
+// ProgressListener 接收进度通知，可能被多个 goroutine 并发调用，应该尽快返回
+type ProgressListener func(p Progress)
+
+const (
+	progressInterval = 200 * time.Millisecond
+	progressSmooth   = 0.3
+)
+
+type progressTracker struct {
+	m          sync.Mutex
+	key        string
+	total      int64
+	done       int64
+	rate       float64
+	sampleDone int64
+	sampleAt   time.Time
+	listener   ProgressListener
+}
+
+func newProgressTracker(listener ProgressListener, key string, total int64) *progressTracker {
+	if listener == nil {
+		return nil
+	}
+	return &progressTracker{
+		key:      key,
+		total:    total,
+		sampleAt: time.Now(),
+		listener: listener,
+	}
+}
+
+// uploadCallback 适配 kodocli 的 OnProgress，uploaded 是本次上传尝试的累计值
+func (t *progressTracker) uploadCallback() func(fsize, uploaded int64) {
+	if t == nil {
+		return nil
+	}
+	return func(_, uploaded int64) {
+		t.report(uploaded, false, false)
+	}
+}
+
+func (t *progressTracker) add(n int64) {
+	if t == nil {
+		return
+	}
+	t.report(n, true, false)
+}
+
+// adjust 调整已经传输的字节数但不计入速率，用于续传时计入本地已有的数据，
+// 或者分片下载失败时扣除这个分片已经传输的数据
+func (t *progressTracker) adjust(n int64) {
+	if t == nil {
+		return
+	}
+	t.m.Lock()
+	t.done += n
+	t.sampleDone += n
+	t.m.Unlock()
+}
+
+func (t *progressTracker) finish() {
+	if t == nil {
+		return
+	}
+	if t.total >= 0 {
+		t.report(t.total, false, true)
+	} else {
+		t.report(0, true, true)
+	}
+}
+
+// report 更新进度，delta 为 true 时 n 是增量，否则是累计值
+func (t *progressTracker) report(n int64, delta, force bool) {
+	t.m.Lock()
+	if delta {
+		t.done += n
+	} else {
+		t.done = n
+	}
+	done := t.done
+	now := time.Now()
+	elapsed := now.Sub(t.sampleAt)
+	if !force && elapsed < progressInterval {
+		t.m.Unlock()
+		return
+	}
+	if elapsed > 0 {
+		rate := float64(done-t.sampleDone) / elapsed.Seconds()
+		if rate < 0 {
+			rate = 0
+		}
+		if t.rate == 0 {
+			t.rate = rate
+		} else {
+			t.rate = progressSmooth*rate + (1-progressSmooth)*t.rate
+		}
+	}
+	t.sampleDone, t.sampleAt = done, now
+
+	p := Progress{Key: t.key, Done: done, Total: t.total, Rate: t.rate, ETA: -1}
+	if t.total >= 0 && t.rate > 0 {
+		p.ETA = time.Duration(float64(t.total-done) / t.rate * float64(time.Second))
+	}
+	t.m.Unlock()
+	t.listener(p)
+}
+
+func (t *progressTracker) reader(r io.Reader) io.Reader {
+	if t == nil {
+		return r
+	}
+	return &progressReader{r: r, tracker: t}
+}
+
+type progressReader struct {
+	r       io.Reader
+	tracker *progressTracker
+}
+
+func (r *progressReader) Read(b []byte) (n int, err error) {
+	n, err = r.r.Read(b)
+	if n > 0 {
+		r.tracker.add(int64(n))
+	}
+	return
+}
@@ -0,0 +1,116 @@
+package operation
+
+import (
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type progressRecorder struct {
+	m      sync.Mutex
+	events []Progress
+}
+
+func (r *progressRecorder) listen(p Progress) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.events = append(r.events, p)
+}
+
+func (r *progressRecorder) last() Progress {
+	r.m.Lock()
+	defer r.m.Unlock()
+	if len(r.events) == 0 {
+		return Progress{}
+	}
+	return r.events[len(r.events)-1]
+}
+
+func TestProgressNilListener(t *testing.T) {
+	tracker := newProgressTracker(nil, "key", 10)
+	if tracker != nil {
+		t.Fatal("tracker should be nil without listener")
+	}
+	tracker.add(1)
+	tracker.adjust(1)
+	tracker.finish()
+	if tracker.uploadCallback() != nil {
+		t.Fatal("upload callback should be nil without listener")
+	}
+	if r := strings.NewReader("data"); tracker.reader(r) != r {
+		t.Fatal("reader should not be wrapped without listener")
+	}
+}
+
+func TestProgressInterval(t *testing.T) {
+	var rec progressRecorder
+	tracker := newProgressTracker(rec.listen, "key", 100)
+	for i := 0; i < 10; i++ {
+		tracker.add(1)
+	}
+	if len(rec.events) != 0 {
+		t.Fatal("progress should not be reported within the interval:", rec.events)
+	}
+	time.Sleep(progressInterval)
+	tracker.add(10)
+	if p := rec.last(); p.Done != 20 || p.Total != 100 || p.Rate <= 0 || p.ETA <= 0 {
+		t.Fatal("unexpected progress:", p)
+	}
+	tracker.finish()
+	if p := rec.last(); p.Done != 100 || p.ETA != 0 || len(rec.events) != 2 {
+		t.Fatal("unexpected progress after finish:", rec.events)
+	}
+}
+
+func TestProgressAdjust(t *testing.T) {
+	var rec progressRecorder
+	tracker := newProgressTracker(rec.listen, "key", 1<<20)
+	tracker.adjust(1 << 19)
+	time.Sleep(progressInterval)
+	tracker.add(10)
+	p := rec.last()
+	if p.Done != 1<<19+10 {
+		t.Fatal("resumed bytes should be counted in progress:", p)
+	}
+	if max := 10 / progressInterval.Seconds(); p.Rate > max {
+		t.Fatalf("resumed bytes should not be counted in rate, expect <= %v, but got: %v", max, p.Rate)
+	}
+
+	tracker.adjust(-10)
+	time.Sleep(progressInterval)
+	tracker.add(10)
+	if p = rec.last(); p.Done != 1<<19+10 || p.Rate <= 0 {
+		t.Fatal("unexpected progress after rollback:", p)
+	}
+}
+
+func TestProgressUploadCallback(t *testing.T) {
+	var rec progressRecorder
+	tracker := newProgressTracker(rec.listen, "key", -1)
+	callback := tracker.uploadCallback()
+	callback(-1, 5)
+	time.Sleep(progressInterval)
+	callback(-1, 8)
+	if p := rec.last(); p.Done != 8 || p.Total != -1 || p.ETA != -1 {
+		t.Fatal("upload progress should be cumulative:", p)
+	}
+	tracker.finish()
+	if p := rec.last(); p.Done != 8 {
+		t.Fatal("unexpected progress after finish:", p)
+	}
+}
+
+func TestProgressReader(t *testing.T) {
+	var rec progressRecorder
+	tracker := newProgressTracker(rec.listen, "key", 4)
+	data, err := ioutil.ReadAll(tracker.reader(strings.NewReader("data")))
+	if err != nil || string(data) != "data" {
+		t.Fatal("read failed:", string(data), err)
+	}
+	tracker.finish()
+	if p := rec.last(); p.Key != "key" || p.Done != 4 {
+		t.Fatal("unexpected progress:", p)
+	}
+}
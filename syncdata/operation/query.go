@@ -1,10 +1,10 @@
 package operation
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"net"
 	"net/http"
 	"net/url"
 	"os"
@@ -15,24 +15,12 @@ import (
 	"time"
 
 	"github.com/kirsle/configdir"
+	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/x/hostpool.v1"
+	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/x/metrics.v1"
+	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/x/retry.v1"
+	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/x/slog.v1"
 )
 
-var queryClient = &http.Client{
-	Transport: &http.Transport{
-		Proxy: http.ProxyFromEnvironment,
-		DialContext: (&net.Dialer{
-			Timeout:   500 * time.Millisecond,
-			KeepAlive: 30 * time.Second,
-			DualStack: true,
-		}).DialContext,
-		MaxIdleConns:          100,
-		IdleConnTimeout:       90 * time.Second,
-		TLSHandshakeTimeout:   10 * time.Second,
-		ExpectContinueTimeout: 1 * time.Second,
-	},
-	Timeout: 1 * time.Second,
-}
-
 var (
 	cacheMap         sync.Map
 	cacheUpdaterLock sync.Mutex
@@ -42,9 +30,14 @@ var (
 
 type (
 	Queryer struct {
-		ak      string
-		bucket  string
-		ucHosts []string
+		ak       string
+		bucket   string
+		ucHosts  []string
+		retry    *retry.Policy
+		selector hostpool.Selector
+		pool     *hostpool.Pool
+		metrics  metrics.Collector
+		client   *http.Client
 	}
 
 	cache struct {
@@ -75,42 +68,92 @@ func init() {
 
 func NewQueryer(c *Config) *Queryer {
 	queryer := Queryer{
-		ak:      c.Ak,
-		bucket:  c.Bucket,
-		ucHosts: dupStrings(c.UcHosts),
+		ak:       c.Ak,
+		bucket:   c.Bucket,
+		ucHosts:  dupStrings(c.UcHosts),
+		retry:    c.Retry.policy(defaultUcRetryTimes),
+		selector: newHostSelector(c),
+		pool:     c.hostPool(),
+		metrics:  c.Metrics,
+		client:   c.UcTransport.client(c, ucTransportDefaults),
 	}
 	shuffleHosts(queryer.ucHosts)
 	return &queryer
 }
 
+// ForBucket 返回查询 ak 和 bucket 的 Queryer，它和 queryer 共享 uc 节点、重试策略和节点的健康状态，
+// 一个 Queryer 因此可以同时为多个空间和 AK 查询节点，查询结果按空间和 AK 分别缓存
+func (queryer *Queryer) ForBucket(ak, bucket string) *Queryer {
+	q := *queryer
+	q.ak, q.bucket = ak, bucket
+	return &q
+}
+
 func (queryer *Queryer) QueryUpHosts(https bool) (urls []string) {
-	if cache, err := queryer.query(); err == nil {
-		domains := cache.CachedHosts.Hosts[0].Up.Domains
-		urls = queryer.fromDomainsToUrls(https, domains)
-	}
-	return
+	return queryer.queryHosts(https, "up")
 }
 
 func (queryer *Queryer) QueryIoHosts(https bool) (urls []string) {
-	if cache, err := queryer.query(); err == nil {
-		domains := cache.CachedHosts.Hosts[0].Io.Domains
-		urls = queryer.fromDomainsToUrls(https, domains)
-	}
-	return
+	return queryer.queryHosts(https, "io")
 }
 
 func (queryer *Queryer) QueryRsHosts(https bool) (urls []string) {
-	if cache, err := queryer.query(); err == nil {
-		domains := cache.CachedHosts.Hosts[0].Rs.Domains
-		urls = queryer.fromDomainsToUrls(https, domains)
+	return queryer.queryHosts(https, "rs")
+}
+
+func (queryer *Queryer) QueryRsfHosts(https bool) (urls []string) {
+	return queryer.queryHosts(https, "rsf")
+}
+
+// QueryAllHosts 返回 service（up、io、rs 或 rsf）所有区域的节点，主区域在前，备用区域在后。
+// 和 QueryUpHosts 等不同，它不考虑节点的健康状态，用于后台探测，使主区域的节点全部熔断后仍然可以被探测并恢复
+func (queryer *Queryer) QueryAllHosts(https bool, service string) (urls []string) {
+	cache, err := queryer.query()
+	if err != nil {
+		return
+	}
+	domainsOf := serviceDomains[service]
+	if domainsOf == nil {
+		return
+	}
+	for i := range cache.CachedHosts.Hosts {
+		urls = append(urls, queryer.fromDomainsToUrls(https, domainsOf(&cache.CachedHosts.Hosts[i]))...)
 	}
 	return
 }
 
-func (queryer *Queryer) QueryRsfHosts(https bool) (urls []string) {
-	if cache, err := queryer.query(); err == nil {
-		domains := cache.CachedHosts.Hosts[0].Rsf.Domains
-		urls = queryer.fromDomainsToUrls(https, domains)
+// serviceDomains 从 uc 返回的一组节点中取出各个服务的域名
+var serviceDomains = map[string]func(h *cachedHost) []string{
+	"up":  func(h *cachedHost) []string { return h.Up.Domains },
+	"io":  func(h *cachedHost) []string { return h.Io.Domains },
+	"rs":  func(h *cachedHost) []string { return h.Rs.Domains },
+	"rsf": func(h *cachedHost) []string { return h.Rsf.Domains },
+}
+
+// queryHosts 按 uc 返回的顺序（主区域在前，备用区域在后）返回第一组有可用节点的节点，
+// 所有组的节点都被熔断时返回第一组非空的节点
+func (queryer *Queryer) queryHosts(https bool, service string) (urls []string) {
+	cache, err := queryer.query()
+	if err != nil {
+		return
+	}
+	domainsOf := serviceDomains[service]
+	for i := range cache.CachedHosts.Hosts {
+		group := queryer.fromDomainsToUrls(https, domainsOf(&cache.CachedHosts.Hosts[i]))
+		if len(group) == 0 {
+			continue
+		}
+		if urls == nil {
+			urls = group
+		}
+		for _, host := range group {
+			if queryer.pool.Usable(host) {
+				if i > 0 {
+					elog.Log(slog.LevelDebug, "use backup host group", slog.Op("query"), slog.F("bucket", queryer.bucket), slog.F("service", service), slog.F("group", i))
+				}
+				return group
+			}
+		}
 	}
 	return
 }
@@ -159,36 +202,37 @@ func (queryer *Queryer) query() (*cache, error) {
 }
 
 func (queryer *Queryer) mustQuery() (c *cache, err error) {
-	var resp *http.Response
-
 	query := make(url.Values, 2)
 	query.Set("ak", queryer.ak)
 	query.Set("bucket", queryer.bucket)
 
-	for i := 0; i < 10; i++ {
+	var emptyErr error
+	err = queryer.retry.Do(context.Background(), func(i int) (err error) {
 		ucHost := queryer.nextUcHost()
+		start := startRequest(queryer.selector, ucHost)
+		defer func() { observeRequest(queryer.metrics, queryer.selector, "query", ucHost, i, start, err) }()
 		url := fmt.Sprintf("%s/v4/query?%s", ucHost, query.Encode())
-		resp, err = queryClient.Get(url)
+		resp, err := queryer.client.Get(url)
 		if err != nil {
-			failHostName(ucHost)
-			continue
+			queryer.pool.Fail(ucHost)
+			return wrapError("query", queryer.bucket, ucHost, err)
 		}
 		defer resp.Body.Close()
 
 		if resp.StatusCode/100 != 2 {
-			failHostName(ucHost)
-			err = fmt.Errorf("uc queryV4 status code error: %d", resp.StatusCode)
-			continue
+			queryer.pool.Fail(ucHost)
+			return responseError("query", queryer.bucket, ucHost, resp)
 		}
 
 		c = new(cache)
 		if err = json.NewDecoder(resp.Body).Decode(&c.CachedHosts); err != nil {
-			failHostName(ucHost)
-			continue
+			queryer.pool.Fail(ucHost)
+			return badResponseError("query", queryer.bucket, ucHost, resp, err)
 		}
 		if len(c.CachedHosts.Hosts) == 0 {
-			failHostName(ucHost)
-			return nil, errors.New("uc queryV4 returns empty hosts")
+			queryer.pool.Fail(ucHost)
+			emptyErr = badResponseError("query", queryer.bucket, ucHost, resp, errors.New("uc queryV4 returns empty hosts"))
+			return nil
 		}
 		minTTL := c.CachedHosts.Hosts[0].Ttl
 		for _, host := range c.CachedHosts.Hosts[1:] { // 取出 Hosts 内最小的 TTL
@@ -197,8 +241,11 @@ func (queryer *Queryer) mustQuery() (c *cache, err error) {
 			}
 		}
 		c.CacheExpiredAt = time.Now().Add(time.Duration(minTTL) * time.Second)
-		succeedHostName(ucHost)
-		break
+		queryer.pool.Succeed(ucHost)
+		return nil
+	})
+	if err == nil {
+		err = emptyErr
 	}
 	if err != nil {
 		c = nil
@@ -239,25 +286,11 @@ func (queryer *Queryer) cacheKey() string {
 	return fmt.Sprintf("%s:%s", queryer.bucket, queryer.ak)
 }
 
-var curUcHostIndex uint32 = 0
-
 func (queryer *Queryer) nextUcHost() string {
-	switch len(queryer.ucHosts) {
-	case 0:
+	if len(queryer.ucHosts) == 0 {
 		panic("No Uc hosts is configured")
-	case 1:
-		return queryer.ucHosts[0]
-	default:
-		var ucHost string
-		for i := 0; i <= len(queryer.ucHosts)*MaxFindHostsPrecent/100; i++ {
-			index := int(atomic.AddUint32(&curUcHostIndex, 1) - 1)
-			ucHost = queryer.ucHosts[index%len(queryer.ucHosts)]
-			if isHostNameValid(ucHost) {
-				break
-			}
-		}
-		return ucHost
 	}
+	return queryer.selector.Select(queryer.ucHosts, queryer.pool.Usable)
 }
 
 func SetCacheDirectoryAndLoad(path string) error {
@@ -0,0 +1,175 @@
+package operation
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeUcServer 模拟 uc 的 /v4/query 接口，每个空间返回两组节点：主区域 <bucket>-up0a、<bucket>-up0b，
+// 备用区域 <bucket>-up1；设置了 groups 时返回 groups
+type fakeUcServer struct {
+	*httptest.Server
+
+	m       sync.Mutex
+	queries map[string]int // 每个 "bucket:ak" 的查询次数
+	groups  [][]string
+}
+
+func newFakeUcServer() *fakeUcServer {
+	s := &fakeUcServer{queries: map[string]int{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+func (s *fakeUcServer) serve(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path != "/v4/query" {
+		http.NotFound(w, req)
+		return
+	}
+	bucket, ak := req.URL.Query().Get("bucket"), req.URL.Query().Get("ak")
+	s.m.Lock()
+	s.queries[bucket+":"+ak]++
+	s.m.Unlock()
+	group := func(domains ...string) cachedHost {
+		h := cachedHost{Ttl: 3600}
+		h.Up.Domains = domains
+		h.Io.Domains = domains
+		return h
+	}
+	hosts := []cachedHost{group(bucket+"-up0a", bucket+"-up0b"), group(bucket + "-up1")}
+	if s.groups != nil {
+		hosts = hosts[:0]
+		for _, domains := range s.groups {
+			hosts = append(hosts, group(domains...))
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cachedHosts{Hosts: hosts})
+}
+
+func (s *fakeUcServer) count(bucket, ak string) int {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.queries[bucket+":"+ak]
+}
+
+func newTestQueryer(t *testing.T, uc *fakeUcServer, bucket string) (*Queryer, *Config) {
+	if err := SetCacheDirectoryAndLoad(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	c := &Config{UcHosts: []string{uc.URL}, Bucket: bucket, Ak: "ak", Retry: RetryConfig{MaxAttempts: 1}}
+	return NewQueryer(c), c
+}
+
+func TestQueryHostGroupFailover(t *testing.T) {
+	uc := newFakeUcServer()
+	defer uc.Close()
+	queryer, c := newTestQueryer(t, uc, "failover")
+
+	if hosts := fmt.Sprint(queryer.QueryUpHosts(false)); hosts != "[http://failover-up0a http://failover-up0b]" {
+		t.Fatal("primary group should be used first:", hosts)
+	}
+	if hosts := fmt.Sprint(queryer.QueryIoHosts(true)); hosts != "[https://failover-up0a https://failover-up0b]" {
+		t.Fatal("https should be used:", hosts)
+	}
+
+	for i := 0; i < MaxContinuousFailureTimes; i++ {
+		c.hostPool().Fail("http://failover-up0a")
+	}
+	if hosts := fmt.Sprint(queryer.QueryUpHosts(false)); hosts != "[http://failover-up0a http://failover-up0b]" {
+		t.Fatal("group with usable hosts should still be used:", hosts)
+	}
+	for i := 0; i < MaxContinuousFailureTimes; i++ {
+		c.hostPool().Fail("http://failover-up0b")
+	}
+	if hosts := fmt.Sprint(queryer.QueryUpHosts(false)); hosts != "[http://failover-up1]" {
+		t.Fatal("backup group should be used when primary group is down:", hosts)
+	}
+	for i := 0; i < MaxContinuousFailureTimes; i++ {
+		c.hostPool().Fail("http://failover-up1")
+	}
+	if hosts := fmt.Sprint(queryer.QueryUpHosts(false)); hosts != "[http://failover-up0a http://failover-up0b]" {
+		t.Fatal("first group should be returned when all groups are down:", hosts)
+	}
+	if n := uc.count("failover", "ak"); n != 1 {
+		t.Fatal("query result should be cached:", n)
+	}
+}
+
+func TestQueryAllHosts(t *testing.T) {
+	uc := newFakeUcServer()
+	defer uc.Close()
+	queryer, c := newTestQueryer(t, uc, "all")
+
+	for _, host := range []string{"http://all-up0a", "http://all-up0b"} {
+		for i := 0; i < MaxContinuousFailureTimes; i++ {
+			c.hostPool().Fail(host)
+		}
+	}
+	if hosts := fmt.Sprint(queryer.QueryAllHosts(false, "up")); hosts != "[http://all-up0a http://all-up0b http://all-up1]" {
+		t.Fatal("hosts of all groups should be returned:", hosts)
+	}
+	if hosts := queryer.QueryAllHosts(false, "rs"); len(hosts) != 0 {
+		t.Fatal("unexpected rs hosts:", hosts)
+	}
+	if hosts := queryer.QueryAllHosts(false, "unknown"); len(hosts) != 0 {
+		t.Fatal("unknown service should have no hosts:", hosts)
+	}
+}
+
+func TestProberPrimaryGroupFailback(t *testing.T) {
+	up := func() *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	}
+	primary, backup := up(), up()
+	defer primary.Close()
+	defer backup.Close()
+	uc := newFakeUcServer()
+	defer uc.Close()
+	uc.groups = [][]string{{primary.URL}, {backup.URL}}
+	queryer, c := newTestQueryer(t, uc, "failback")
+	c.Probe = ProbeConfig{Interval: 20, Timeout: 1000}
+
+	for i := 0; i < MaxContinuousFailureTimes; i++ {
+		c.hostPool().Fail(primary.URL)
+	}
+	if hosts := fmt.Sprint(queryer.QueryUpHosts(false)); hosts != fmt.Sprint([]string{backup.URL}) {
+		t.Fatal("backup group should be used when primary group is down:", hosts)
+	}
+
+	stop := StartProber(c)
+	defer stop()
+	for i := 0; i < 100 && !c.hostPool().Usable(primary.URL); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if hosts := fmt.Sprint(queryer.QueryUpHosts(false)); hosts != fmt.Sprint([]string{primary.URL}) {
+		t.Fatal("primary group should be used again after it is probed successfully:", hosts, c.hostPool().Health())
+	}
+}
+
+func TestQueryerForBucket(t *testing.T) {
+	uc := newFakeUcServer()
+	defer uc.Close()
+	queryer, _ := newTestQueryer(t, uc, "bucket-a")
+	other := queryer.ForBucket("ak2", "bucket-b")
+
+	for i := 0; i < 3; i++ {
+		if hosts := queryer.QueryUpHosts(false); len(hosts) != 2 || hosts[0] != "http://bucket-a-up0a" {
+			t.Fatal("unexpected hosts of bucket-a:", hosts)
+		}
+		if hosts := other.QueryUpHosts(false); len(hosts) != 2 || hosts[0] != "http://bucket-b-up0a" {
+			t.Fatal("unexpected hosts of bucket-b:", hosts)
+		}
+	}
+	if uc.count("bucket-a", "ak") != 1 || uc.count("bucket-b", "ak2") != 1 {
+		t.Fatal("each bucket should be queried once and cached separately:", uc.queries)
+	}
+	if queryer.bucket != "bucket-a" || queryer.ak != "ak" || other.pool != queryer.pool {
+		t.Fatal("ForBucket should share the pool without changing the original queryer")
+	}
+}
@@ -0,0 +1,73 @@
+package operation
+
+import (
+	"time"
+
+	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/x/retry.v1"
+)
+
+// RetryConfig 是 Uploader、Downloader、Lister 和 Queryer 共用的重试配置，时间的单位都是毫秒。
+// 没有配置 max_attempts 时各操作使用原来的默认次数：上传、下载 3 次，rs/rsf 操作 2 次，
+// 批量操作 3 轮，查询 uc 10 次
+type RetryConfig struct {
+	MaxAttempts       int     `json:"max_attempts" toml:"max_attempts"`
+	InitialBackoff    int64   `json:"initial_backoff" toml:"initial_backoff"`
+	MaxBackoff        int64   `json:"max_backoff" toml:"max_backoff"`
+	Multiplier        float64 `json:"multiplier" toml:"multiplier"`
+	Jitter            float64 `json:"jitter" toml:"jitter"`
+	MaxElapsed        int64   `json:"max_elapsed" toml:"max_elapsed"`
+	RespectRetryAfter bool    `json:"respect_retry_after" toml:"respect_retry_after"`
+	RetryableCodes    []int   `json:"retryable_codes" toml:"retryable_codes"`
+}
+
+const (
+	defaultUpRetryTimes    = 3
+	defaultDownRetryTimes  = 3
+	defaultRsRetryTimes    = 2
+	defaultBatchRetryTimes = 3
+	defaultUcRetryTimes    = 10
+
+	kodocliRetryInterval = 3000 // kodocli 默认的重试间隔，单位毫秒
+)
+
+func (c *RetryConfig) isZero() bool {
+	return c.MaxAttempts == 0 && c.InitialBackoff == 0 && c.MaxBackoff == 0 && c.Multiplier == 0 && c.Jitter == 0 &&
+		c.MaxElapsed == 0 && !c.RespectRetryAfter && len(c.RetryableCodes) == 0
+}
+
+// policy 按配置生成重试策略，没有配置 max_attempts 时最多尝试 defaultAttempts 次
+func (c *RetryConfig) policy(defaultAttempts int) *retry.Policy {
+	attempts := c.MaxAttempts
+	if attempts <= 0 {
+		attempts = defaultAttempts
+	}
+	return &retry.Policy{
+		MaxAttempts:       attempts,
+		InitialBackoff:    time.Duration(c.InitialBackoff) * time.Millisecond,
+		MaxBackoff:        time.Duration(c.MaxBackoff) * time.Millisecond,
+		Multiplier:        c.Multiplier,
+		Jitter:            c.Jitter,
+		MaxElapsed:        time.Duration(c.MaxElapsed) * time.Millisecond,
+		RespectRetryAfter: c.RespectRetryAfter,
+		RetryableCodes:    c.RetryableCodes,
+	}
+}
+
+// kodocliPolicy 返回传给 kodocli 的重试策略，没有任何配置时返回 nil，使用 kodocli 的默认策略。
+// 只配置了部分字段时，其余字段逐个使用 kodocli 的默认值：尝试次数由 kodocli 按操作决定，
+// 重试间隔 3 秒且不增长
+func (c *RetryConfig) kodocliPolicy() *retry.Policy {
+	if c.isZero() {
+		return nil
+	}
+	d := *c
+	if d.InitialBackoff == 0 {
+		d.InitialBackoff = kodocliRetryInterval
+	}
+	if d.Multiplier == 0 {
+		d.Multiplier = 1
+	}
+	p := d.policy(0)
+	p.MaxAttempts = c.MaxAttempts
+	return p
+}
@@ -0,0 +1,22 @@
+package operation
+
+import (
+	"testing"
+	"time"
+)
+
+func TestKodocliPolicy(t *testing.T) {
+	if p := (&RetryConfig{}).kodocliPolicy(); p != nil {
+		t.Fatal("empty config should use kodocli default policy:", p)
+	}
+
+	p := (&RetryConfig{MaxElapsed: 60000}).kodocliPolicy()
+	if p.MaxAttempts != 0 || p.InitialBackoff != 3*time.Second || p.Multiplier != 1 || p.MaxElapsed != time.Minute {
+		t.Fatalf("unset fields should keep kodocli defaults: %+v", p)
+	}
+
+	p = (&RetryConfig{MaxAttempts: 2, InitialBackoff: 100, Multiplier: 2}).kodocliPolicy()
+	if p.MaxAttempts != 2 || p.InitialBackoff != 100*time.Millisecond || p.Multiplier != 2 {
+		t.Fatalf("configured fields should be used: %+v", p)
+	}
+}
@@ -2,11 +2,14 @@ package operation
 
 import (
 	"encoding/json"
-	"log"
 	"net/http"
 	"os"
 	"strings"
 	"time"
+
+	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/x/hostpool.v1"
+	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/x/metrics.v1"
+	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/x/slog.v1"
 )
 
 type server struct {
@@ -15,6 +18,8 @@ type server struct {
 	del      bool
 	downPath string
 	sim      bool
+	metrics  http.Handler
+	pool     *hostpool.Pool
 }
 
 type Req struct {
@@ -33,8 +38,8 @@ func (s *server) download(res http.ResponseWriter, req *http.Request) {
 	path := req.URL.Path
 	fPath := s.downPath + renameFile(path)
 	f, err := os.Open(fPath)
-	elog.Info("download", req.Method, path, req.URL.RawQuery, err)
-	elog.Info("ranges", req.Header.Get("Range"))
+	elog.Log(slog.LevelInfo, "download", slog.Op("download"), slog.Key(path), slog.F("method", req.Method), slog.F("query", req.URL.RawQuery),
+		slog.F("range", req.Header.Get("Range")), slog.Err(err))
 	if err != nil {
 		res.WriteHeader(http.StatusNotFound)
 		return
@@ -56,6 +61,10 @@ func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	case http.MethodGet:
 		if r.URL.Path == "/list" {
 			s.listFiles(w, r)
+		} else if r.URL.Path == "/metrics" {
+			s.serveMetrics(w, r)
+		} else if r.URL.Path == "/hosts" {
+			s.hosts(w, r)
 		} else {
 			s.download(w, r)
 		}
@@ -65,14 +74,28 @@ func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *server) listStat(w http.ResponseWriter, r *http.Request) {
+	f, err := NewFilterFromQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	ret := s.lister.batchStat(r.Body)
 	if ret == nil {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
+	if f = AndFilter(s.lister.filter, f); f != nil {
+		selected := ret[:0]
+		for _, stat := range ret {
+			if f.Match(listFilterItem(stat.Name, stat.Size, stat.PutTime)) {
+				selected = append(selected, stat)
+			}
+		}
+		ret = selected
+	}
 	j, err := json.Marshal(ret)
 	if err != nil {
-		log.Println("json marshal error", err)
+		elog.Log(slog.LevelError, "json marshal failed", slog.Op("stat"), slog.Err(err))
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
@@ -82,14 +105,55 @@ func (s *server) listStat(w http.ResponseWriter, r *http.Request) {
 
 func (s *server) listFiles(w http.ResponseWriter, r *http.Request) {
 	prefix := r.URL.Query().Get("prefix")
-	ret := s.lister.ListPrefix(prefix)
-	if ret == nil {
+	f, err := NewFilterFromQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if delimiter := r.URL.Query().Get("delimiter"); delimiter != "" {
+		s.listDir(w, prefix, delimiter, f)
+		return
+	}
+	items, err := s.lister.ListPrefixWithFilter(prefix, f)
+	if err != nil {
+		elog.Log(slog.LevelError, "list failed", slog.Op("list"), slog.Key(prefix), slog.Err(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	ret := make([]string, 0, len(items))
+	for _, item := range items {
+		ret = append(ret, item.Key)
+	}
+	j, err := json.Marshal(ret)
+	if err != nil {
+		elog.Log(slog.LevelError, "json marshal failed", slog.Op("list"), slog.Err(err))
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(j)
+}
+
+// listDir 返回 prefix 下一级的“子目录”和文件
+func (s *server) listDir(w http.ResponseWriter, prefix, delimiter string, f Filter) {
+	ret, err := s.lister.ListDir(prefix, delimiter)
+	if err != nil {
+		elog.Log(slog.LevelError, "list dir failed", slog.Op("list"), slog.Key(prefix), slog.Err(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if f != nil {
+		selected := ret.Items[:0]
+		for _, item := range ret.Items {
+			if f.Match(listFilterItem(item.Key, item.Fsize, item.PutTime)) {
+				selected = append(selected, item)
+			}
+		}
+		ret.Items = selected
+	}
 	j, err := json.Marshal(ret)
 	if err != nil {
-		log.Println("json marshal error", err)
+		elog.Log(slog.LevelError, "json marshal failed", slog.Op("list"), slog.Err(err))
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
@@ -101,23 +165,25 @@ func (s *server) upload(w http.ResponseWriter, r *http.Request) {
 	d := json.NewDecoder(r.Body)
 	var reqs []Req
 	err := d.Decode(&reqs)
-	log.Printf("receive request %+v\n", reqs)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
-		log.Println(err)
+		elog.Log(slog.LevelWarn, "invalid upload request", slog.Op("upload"), slog.Err(err))
 		return
 	}
+	elog.Log(slog.LevelInfo, "receive upload request", slog.Op("upload"), slog.F("count", len(reqs)))
 	go func() {
 		for _, req := range reqs {
 			if s.sim {
 				err := os.Rename(req.Path, s.downPath+renameFile(req.Path))
-				log.Println("move ", req.Path, s.downPath+renameFile(req.Path), err)
+				elog.Log(slog.LevelInfo, "move", slog.F("path", req.Path), slog.F("dest", s.downPath+renameFile(req.Path)), slog.Err(err))
 			} else {
 				key := req.Key
 				if key == "" {
 					key = req.Path
 				}
-				s.up.Upload(req.Path, key)
+				if err := s.up.Upload(req.Path, key); err != nil {
+					elog.Log(slog.LevelError, "upload failed", slog.Op("upload"), slog.Key(key), slog.F("path", req.Path), slog.Err(err))
+				}
 				if req.Delete == nil {
 					if s.del {
 						os.Remove(req.Path)
@@ -132,23 +198,62 @@ func (s *server) upload(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+// serveMetrics 以 Prometheus 文本格式输出这个服务的监控数据
+func (s *server) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	if s.metrics == nil {
+		http.NotFound(w, r)
+		return
+	}
+	s.metrics.ServeHTTP(w, r)
+}
+
+// hosts 返回节点健康状态表
+func (s *server) hosts(w http.ResponseWriter, r *http.Request) {
+	j, err := json.Marshal(s.pool.Health())
+	if err != nil {
+		elog.Log(slog.LevelError, "json marshal failed", slog.Op("hosts"), slog.Err(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(j)
+}
+
+// serverMetrics 返回服务使用的 Collector 和输出监控数据的 Handler。cfg 没有配置 Metrics 时创建一个
+// 只属于这个服务的 Registry 并保存到 cfg.Metrics，不会修改 metrics.Default()；
+// 配置的 Collector 没有实现 http.Handler 时不提供 /metrics
+func serverMetrics(cfg *Config) http.Handler {
+	if cfg.Metrics == nil {
+		cfg.Metrics = metrics.NewRegistry()
+	}
+	h, _ := cfg.Metrics.(http.Handler)
+	return h
+}
+
+// StartServer 启动 cfg.Addr 上的服务。除了上传、下载和列举接口，/metrics 输出这个服务的监控数据，
+// /hosts 输出节点健康状态表。调用返回的 *http.Server 的 Shutdown 可以关闭服务并停止后台探测
 func StartServer(cfg *Config) (*http.Server, error) {
+	handler := serverMetrics(cfg)
 	s := server{
 		up:       NewUploader(cfg),
 		del:      cfg.Delete,
 		downPath: cfg.DownPath,
 		sim:      cfg.Sim,
 		lister:   NewLister(cfg),
+		metrics:  handler,
+		pool:     cfg.hostPool(),
 	}
 	srv := &http.Server{
 		Addr:    cfg.Addr,
 		Handler: &s,
 	}
+	srv.RegisterOnShutdown(StartProber(cfg))
 
 	go func() {
-		// service connections
-		if err := srv.ListenAndServe(); err != nil {
-			log.Panicln("upload server failed: " + err.Error())
+		// service connections，Shutdown 之后返回的 http.ErrServerClosed 不是错误
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			elog.Log(slog.LevelError, "upload server failed", slog.F("addr", cfg.Addr), slog.Err(err))
 		}
 	}()
 	return srv, nil
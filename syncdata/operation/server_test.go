@@ -0,0 +1,130 @@
+package operation
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/x/metrics.v1"
+	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/x/slog.v1"
+)
+
+func TestServerStatFilter(t *testing.T) {
+	rs := newFakeRsServer()
+	defer rs.Close()
+	rs.put("new", []byte("new"))
+	rs.put("old", []byte("old"))
+	rs.files["bucket:old"] = fakeEntry{data: []byte("old"), putTime: time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC).UnixNano() / 100}
+	s := &server{lister: NewLister(newTestConfig(rs))}
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("POST", "/stat?after=2020-01-01", strings.NewReader(`["old","new","missing"]`)))
+	if w.Code != http.StatusOK {
+		t.Fatal("stat failed:", w.Code, w.Body.String())
+	}
+	var stats []FileStat
+	if err := json.Unmarshal(w.Body.Bytes(), &stats); err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 2 || stats[0].Name != "new" || stats[1].Name != "missing" || !stats[1].NotFound() {
+		t.Fatal("old file should be filtered out by put time:", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("POST", "/stat?min_size=ten", strings.NewReader(`["new"]`)))
+	if w.Code != http.StatusBadRequest {
+		t.Fatal("invalid filter should be rejected:", w.Code)
+	}
+}
+
+func TestServerListDir(t *testing.T) {
+	rs := newFakeRsServer()
+	defer rs.Close()
+	s := &server{lister: newTestLister(rs, 1, "a/1.jpg", "a/2.png", "a/b/1", "c")}
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/list?prefix=a/&delimiter=/&include=*.jpg", nil))
+	if w.Code != http.StatusOK {
+		t.Fatal("list dir failed:", w.Code, w.Body.String())
+	}
+	var ret DirList
+	if err := json.Unmarshal(w.Body.Bytes(), &ret); err != nil {
+		t.Fatal(err)
+	}
+	if len(ret.CommonPrefixes) != 1 || ret.CommonPrefixes[0] != "a/b/" || len(ret.Items) != 1 || ret.Items[0].Key != "a/1.jpg" {
+		t.Fatal("unexpected dir list:", w.Body.String())
+	}
+}
+
+func TestServerAdmin(t *testing.T) {
+	rs := newFakeRsServer()
+	defer rs.Close()
+	c := newTestConfig(rs)
+	handler := serverMetrics(c)
+	if handler == nil || c.Metrics == nil || metrics.Default() != metrics.Nop {
+		t.Fatal("server should use its own registry without changing the default collector")
+	}
+	c.hostPool().Fail("http://bad.example.com")
+	s := &server{lister: NewLister(c), metrics: handler, pool: c.hostPool(), downPath: t.TempDir() + "/"}
+	if _, err := s.lister.ListPrefix(""); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `us3_requests_total{op="list"`) {
+		t.Fatal("unexpected metrics:", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/hosts", nil))
+	var health []struct{ Host, State string }
+	if err := json.Unmarshal(w.Body.Bytes(), &health); err != nil || len(health) != 1 || health[0].Host != "http://bad.example.com" || health[0].State != "closed" {
+		t.Fatal("unexpected hosts:", w.Body.String(), err)
+	}
+
+	for _, path := range []string{"/-/metrics", "/-/hosts"} {
+		w = httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != http.StatusNotFound {
+			t.Fatal("path should not be an admin route:", path, w.Code)
+		}
+	}
+}
+
+func TestStartServerShutdown(t *testing.T) {
+	r := &logRecorder{}
+	useLogger(t, r)
+	rs := newFakeRsServer()
+	defer rs.Close()
+	c := newTestConfig(rs)
+	c.Addr = "127.0.0.1:0"
+
+	srv, err := StartServer(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if err = srv.Shutdown(context.Background()); err != nil {
+		t.Fatal("shutdown failed:", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if e := r.find("upload server failed"); e != nil {
+		t.Fatal("graceful shutdown should not be logged as a failure:", e.fields)
+	}
+
+	c.Addr = "127.0.0.1:-1"
+	if _, err = StartServer(c); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100 && r.find("upload server failed") == nil; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if e := r.find("upload server failed"); e == nil || e.level != slog.LevelError || e.fields["error"] == nil {
+		t.Fatal("listen failure should be logged:", r.entries)
+	}
+}
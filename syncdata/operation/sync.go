@@ -0,0 +1,247 @@
+package operation
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/api.v7/kodo"
+	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/x/slog.v1"
+)
+
+const (
+	SyncUpload   = "upload"
+	SyncDownload = "download"
+	SyncDelete   = "delete"
+)
+
+// SyncOp 是同步计划中的一项操作
+type SyncOp struct {
+	Action string `json:"action"`         // SyncUpload、SyncDownload 或 SyncDelete
+	Key    string `json:"key"`            // 远端 key，删除本地文件时为空
+	Path   string `json:"path,omitempty"` // 本地文件路径，删除远端文件时为空
+	Size   int64  `json:"size"`
+	Reason string `json:"reason"` // new、size、hash 或 gone
+	Err    error  `json:"-"`      // 执行失败时的错误
+
+	hash string
+}
+
+func (op *SyncOp) String() string {
+	switch {
+	case op.Action == SyncDownload:
+		return fmt.Sprintf("%s %s -> %s (%s, %d bytes)", op.Action, op.Key, op.Path, op.Reason, op.Size)
+	case op.Action != SyncDelete:
+		return fmt.Sprintf("%s %s -> %s (%s, %d bytes)", op.Action, op.Path, op.Key, op.Reason, op.Size)
+	case op.Key != "":
+		return fmt.Sprintf("%s %s (%s)", op.Action, op.Key, op.Reason)
+	default:
+		return fmt.Sprintf("%s %s (%s)", op.Action, op.Path, op.Reason)
+	}
+}
+
+// SyncOptions 控制一次同步的行为
+type SyncOptions struct {
+	Delete bool // 删除源端已经不存在的目标文件
+	DryRun bool // 只生成同步计划，不执行
+}
+
+// Syncer 把本地目录同步到 bucket 的某个前缀下，只上传新增或者内容有变化的文件
+type Syncer struct {
+	uploader    *Uploader
+	lister      *Lister
+	partSize    int64
+	concurrency int
+}
+
+func syncConcurrency(c *Config) int {
+	if c.SyncConcurrency <= 0 {
+		return 4
+	}
+	return c.SyncConcurrency
+}
+
+func NewSyncer(c *Config) *Syncer {
+	return &Syncer{
+		uploader:    NewUploader(c),
+		lister:      NewLister(c),
+		partSize:    uploadPartSize(c),
+		concurrency: syncConcurrency(c),
+	}
+}
+
+func NewSyncerV2() *Syncer {
+	c := getConf()
+	if c == nil {
+		return nil
+	}
+	return NewSyncer(c)
+}
+
+// SetProgressListener 设置每个文件的上传进度通知，传入 nil 关闭通知
+func (s *Syncer) SetProgressListener(listener ProgressListener) {
+	s.uploader.SetProgressListener(listener)
+}
+
+// Plan 比较本地目录 dir 和远端 prefix 下的文件，返回需要执行的操作。
+// prefix 被当作目录，不以 '/' 结尾时会补上 '/'，例如 backup 只对应 backup/ 下的文件，不包括 backup2/ 下的文件。
+// 本地文件相对 dir 的路径（以 '/' 分隔）拼接在 prefix 后面作为 key，大小和 qetag 都一致的文件会被跳过。
+// key 不满足配置的过滤条件或者相对路径被 dir 下的 .us3ignore 忽略的文件不参与同步
+func (s *Syncer) Plan(dir, prefix string, opts SyncOptions) ([]SyncOp, error) {
+	prefix = dirPrefix(prefix)
+	ignore, err := dirIgnoreFilter(dir)
+	if err != nil {
+		return nil, err
+	}
+	items, err := s.lister.listItems(prefix)
+	if err != nil {
+		return nil, err
+	}
+	remote := make(map[string]kodo.ListItem, len(items))
+	for _, item := range items {
+		remote[item.Key] = item
+	}
+
+	var ops []SyncOp
+	local := make(map[string]struct{})
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		key := prefix + filepath.ToSlash(rel)
+		if rel == IgnoreFileName || !matchFilter(ignore, localFilterItem(rel, info)) ||
+			!matchFilter(s.lister.filter, localFilterItem(key, info)) {
+			return nil
+		}
+		local[key] = struct{}{}
+
+		op := SyncOp{Action: SyncUpload, Key: key, Path: path, Size: info.Size()}
+		item, ok := remote[key]
+		switch {
+		case !ok:
+			op.Reason = "new"
+		case item.Fsize != info.Size():
+			op.Reason = "size"
+		default:
+			same, err := sameEtag(path, info.Size(), s.partSize, item.Hash)
+			if err != nil {
+				return err
+			}
+			if same {
+				return nil
+			}
+			op.Reason = "hash"
+		}
+		ops = append(ops, op)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Delete {
+		var gone []SyncOp
+		for _, item := range items {
+			rel := listFilterItem(strings.TrimPrefix(item.Key, prefix), item.Fsize, item.PutTime)
+			if _, ok := local[item.Key]; !ok && matchFilter(ignore, rel) {
+				gone = append(gone, SyncOp{Action: SyncDelete, Key: item.Key, Size: item.Fsize, Reason: "gone"})
+			}
+		}
+		sort.Slice(gone, func(i, j int) bool { return gone[i].Key < gone[j].Key })
+		ops = append(ops, gone...)
+	}
+	return ops, nil
+}
+
+// dirPrefix 把非空且不以 '/' 结尾的 prefix 补上 '/'，使前缀只匹配这个目录下的 key
+func dirPrefix(prefix string) string {
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		return prefix + "/"
+	}
+	return prefix
+}
+
+// dirIgnoreFilter 读取 dir 下的 .us3ignore，文件不存在时返回 nil
+func dirIgnoreFilter(dir string) (Filter, error) {
+	path := filepath.Join(dir, IgnoreFileName)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil, nil
+	}
+	return NewFilter(&FilterConfig{IgnoreFile: path})
+}
+
+// sameEtag 判断本地文件 path 的 etag 是否和远端的 hash 一致
+func sameEtag(path string, size, partSize int64, hash string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	etag, err := localEtag(f, size, partSize, hash)
+	if err != nil {
+		return false, err
+	}
+	return etag == hash, nil
+}
+
+// Sync 生成同步计划并执行，返回执行过的操作；DryRun 时只返回计划。
+// 单个操作失败不会中断同步，失败的操作会设置 Err，同时返回汇总的错误
+func (s *Syncer) Sync(dir, prefix string, opts SyncOptions) ([]SyncOp, error) {
+	ops, err := s.Plan(dir, prefix, opts)
+	if err != nil || opts.DryRun {
+		return ops, err
+	}
+	return ops, runSyncOps(ops, s.concurrency, func(op *SyncOp) error {
+		if op.Action == SyncDelete {
+			return s.lister.Delete(op.Key)
+		}
+		return s.uploader.Upload(op.Path, op.Key)
+	})
+}
+
+// runSyncOps 以 concurrency 的并发度执行 ops，失败的操作会设置 Err，返回汇总的错误
+func runSyncOps(ops []SyncOp, concurrency int, do func(op *SyncOp) error) error {
+	var wg sync.WaitGroup
+	ch := make(chan *SyncOp)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for op := range ch {
+				if op.Err = do(op); op.Err != nil {
+					elog.Log(slog.LevelWarn, "sync failed", slog.Op(op.Action), slog.Key(op.Key), slog.F("path", op.Path), slog.F("reason", op.Reason), slog.Err(op.Err))
+				}
+			}
+		}()
+	}
+	for i := range ops {
+		ch <- &ops[i]
+	}
+	close(ch)
+	wg.Wait()
+
+	var failed int
+	var first error
+	for _, op := range ops {
+		if op.Err != nil {
+			if first == nil {
+				first = op.Err
+			}
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("sync: %d of %d operations failed, first error: %v", failed, len(ops), first)
+	}
+	return nil
+}
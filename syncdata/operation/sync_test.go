@@ -0,0 +1,364 @@
+package operation
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/api.v7/kodo"
+)
+
+// fakeRsServer 模拟 rs 和 rsf 的 stat、delete、batch、list 接口，以及 put 表单上传，
+// 文件以 "bucket:key" 为键保存在内存中
+type fakeRsServer struct {
+	*httptest.Server
+
+	m           sync.Mutex
+	files       map[string]fakeEntry
+	pageSize    int            // 每页最多返回的条目数，为 0 时使用请求中的 limit
+	lists       []string       // 每次 list 请求的 marker
+	batches     []int          // 每次 batch 请求的操作数
+	failLists   int            // 接下来的 failLists 次 list 请求返回 503
+	failBatches int            // 接下来的 failBatches 次 batch 请求返回 503
+	ops         map[string]int // 每个操作的执行次数
+	opHook      func(op string, n int) int
+}
+
+type fakeEntry struct {
+	data    []byte
+	putTime int64
+}
+
+func newFakeRsServer() *fakeRsServer {
+	s := &fakeRsServer{files: map[string]fakeEntry{}, ops: map[string]int{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+func (s *fakeRsServer) put(key string, data []byte) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.files["bucket:"+key] = fakeEntry{data: data, putTime: time.Now().UnixNano() / 100}
+}
+
+func (s *fakeRsServer) keys() []string {
+	s.m.Lock()
+	defer s.m.Unlock()
+	var keys []string
+	for entry := range s.files {
+		keys = append(keys, entry)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (s *fakeRsServer) serve(w http.ResponseWriter, req *http.Request) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case req.URL.Path == "/list":
+		s.list(w, req)
+	case req.URL.Path == "/batch":
+		req.ParseForm()
+		s.batches = append(s.batches, len(req.Form["op"]))
+		if s.failBatches > 0 {
+			s.failBatches--
+			writeFakeError(w, http.StatusServiceUnavailable, "injected")
+			return
+		}
+		rets := make([]map[string]interface{}, len(req.Form["op"]))
+		for i, op := range req.Form["op"] {
+			code, data := s.do(op)
+			rets[i] = map[string]interface{}{"code": code}
+			if code == 200 {
+				rets[i]["data"] = data
+			} else {
+				rets[i]["data"] = map[string]string{"error": "injected"}
+				rets[i]["error"] = fmt.Sprint("error ", code)
+			}
+		}
+		json.NewEncoder(w).Encode(rets)
+	case strings.HasPrefix(req.URL.Path, "/put/"):
+		segs := strings.Split(req.URL.Path, "/")
+		key, _ := base64.URLEncoding.DecodeString(segs[len(segs)-1])
+		data, _ := ioutil.ReadAll(req.Body)
+		s.files["bucket:"+string(key)] = fakeEntry{data: data, putTime: time.Now().UnixNano() / 100}
+		hash, _ := Etag(bytes.NewReader(data))
+		json.NewEncoder(w).Encode(map[string]string{"key": string(key), "hash": hash})
+	default:
+		code, data := s.do(req.URL.Path)
+		if code != 200 {
+			writeFakeError(w, code, "injected")
+			return
+		}
+		if data == nil {
+			data = map[string]string{}
+		}
+		json.NewEncoder(w).Encode(data)
+	}
+}
+
+func writeFakeError(w http.ResponseWriter, code int, msg string) {
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
+
+func encodedEntry(key string) string {
+	return base64.URLEncoding.EncodeToString([]byte("bucket:" + key))
+}
+
+// do 执行 /stat/<entry>、/delete/<entry>、/copy/<src>/<dest>、/move/<src>/<dest> 操作
+func (s *fakeRsServer) do(op string) (int, interface{}) {
+	s.ops[op]++
+	if s.opHook != nil {
+		if code := s.opHook(op, s.ops[op]); code != 0 {
+			return code, nil
+		}
+	}
+	segs := strings.Split(strings.TrimPrefix(op, "/"), "/")
+	entries := make([]string, 0, 2)
+	for _, seg := range segs[1:] {
+		entry, _ := base64.URLEncoding.DecodeString(seg)
+		entries = append(entries, string(entry))
+	}
+	src, ok := s.files[entries[0]]
+	if !ok {
+		return 612, nil
+	}
+	switch segs[0] {
+	case "stat":
+		hash, _ := Etag(bytes.NewReader(src.data))
+		return 200, kodo.Entry{Hash: hash, Fsize: int64(len(src.data)), PutTime: src.putTime}
+	case "delete":
+		delete(s.files, entries[0])
+	case "copy", "move":
+		if _, ok := s.files[entries[1]]; ok {
+			return 614, nil
+		}
+		s.files[entries[1]] = src
+		if segs[0] == "move" {
+			delete(s.files, entries[0])
+		}
+	default:
+		return 400, nil
+	}
+	return 200, nil
+}
+
+func (s *fakeRsServer) list(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	bucket, prefix, delimiter, marker := query.Get("bucket"), query.Get("prefix"), query.Get("delimiter"), query.Get("marker")
+	s.lists = append(s.lists, marker)
+	if s.failLists > 0 {
+		s.failLists--
+		writeFakeError(w, http.StatusServiceUnavailable, "injected")
+		return
+	}
+	limit := s.pageSize
+	if limit == 0 {
+		fmt.Sscan(query.Get("limit"), &limit)
+	}
+
+	var keys []string
+	for entry := range s.files {
+		if key := strings.TrimPrefix(entry, bucket+":"); key != entry && strings.HasPrefix(key, prefix) && key > marker {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	ret := struct {
+		Marker   string          `json:"marker"`
+		Items    []kodo.ListItem `json:"items"`
+		Prefixes []string        `json:"commonPrefixes"`
+	}{}
+	seen := map[string]bool{}
+	last := ""
+	for _, key := range keys {
+		common := ""
+		if delimiter != "" {
+			if i := strings.Index(key[len(prefix):], delimiter); i >= 0 {
+				common = key[:len(prefix)+i+len(delimiter)]
+			}
+		}
+		if common != "" && seen[common] {
+			last = key
+			continue
+		}
+		if len(ret.Items)+len(ret.Prefixes) >= limit {
+			ret.Marker = last
+			break
+		}
+		if common != "" {
+			seen[common] = true
+			ret.Prefixes = append(ret.Prefixes, common)
+		} else {
+			entry := s.files[bucket+":"+key]
+			hash, _ := Etag(bytes.NewReader(entry.data))
+			ret.Items = append(ret.Items, kodo.ListItem{Key: key, Hash: hash, Fsize: int64(len(entry.data)), PutTime: entry.putTime})
+		}
+		last = key
+	}
+	json.NewEncoder(w).Encode(ret)
+}
+
+func newTestConfig(srv *fakeRsServer) *Config {
+	return &Config{
+		UpHosts:  []string{srv.URL},
+		RsHosts:  []string{srv.URL},
+		RsfHosts: []string{srv.URL},
+		Bucket:   "bucket",
+		Ak:       "ak",
+		Sk:       "sk",
+		Retry:    RetryConfig{MaxAttempts: 1},
+	}
+}
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func opsString(ops []SyncOp) string {
+	s := make([]string, len(ops))
+	for i, op := range ops {
+		name := op.Key
+		if op.Action == SyncDownload || name == "" {
+			name = filepath.ToSlash(op.Path)
+		}
+		s[i] = fmt.Sprintf("%s %s %s", op.Action, name, op.Reason)
+	}
+	return strings.Join(s, ", ")
+}
+
+func TestSyncerPlanAndSync(t *testing.T) {
+	srv := newFakeRsServer()
+	defer srv.Close()
+	srv.put("p/same", []byte("same"))
+	srv.put("p/size", []byte("old size"))
+	srv.put("p/hash", []byte("old1"))
+	srv.put("p/gone", []byte("gone"))
+	srv.put("p/keep.log", []byte("ignored remote file"))
+	srv.put("other", []byte("out of prefix"))
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"new":        "new",
+		"same":       "same",
+		"size":       "new size!",
+		"hash":       "new1",
+		"sub/nested": "nested",
+		"debug.log":  "ignored local file",
+		".us3ignore": "*.log\n",
+	})
+	syncer := NewSyncer(newTestConfig(srv))
+
+	ops, err := syncer.Plan(dir, "p/", SyncOptions{})
+	if err != nil {
+		t.Fatal("plan failed:", err)
+	}
+	expected := "upload p/hash hash, upload p/new new, upload p/size size, upload p/sub/nested new"
+	if got := opsString(ops); got != expected {
+		t.Fatalf("unexpected plan:\n%s\nexpect:\n%s", got, expected)
+	}
+
+	ops, err = syncer.Sync(dir, "p/", SyncOptions{Delete: true, DryRun: true})
+	if err != nil || !strings.HasSuffix(opsString(ops), ", delete p/gone gone") {
+		t.Fatal("unexpected dry run:", opsString(ops), err)
+	}
+	if len(srv.keys()) != 6 {
+		t.Fatal("dry run should not change anything:", srv.keys())
+	}
+
+	if ops, err = syncer.Sync(dir, "p/", SyncOptions{Delete: true}); err != nil {
+		t.Fatal("sync failed:", err)
+	}
+	for _, op := range ops {
+		if op.Err != nil {
+			t.Fatal("sync op failed:", op.String(), op.Err)
+		}
+	}
+	expected = "[bucket:other bucket:p/hash bucket:p/keep.log bucket:p/new bucket:p/same bucket:p/size bucket:p/sub/nested]"
+	if got := fmt.Sprint(srv.keys()); got != expected {
+		t.Fatalf("unexpected remote files:\n%s\nexpect:\n%s", got, expected)
+	}
+	if ops, err = syncer.Plan(dir, "p/", SyncOptions{Delete: true}); err != nil || len(ops) != 0 {
+		t.Fatal("nothing should be left to sync:", opsString(ops), err)
+	}
+}
+
+func TestSyncerPlanSiblingPrefix(t *testing.T) {
+	srv := newFakeRsServer()
+	defer srv.Close()
+	srv.put("backup/a.txt", []byte("a"))
+	srv.put("backup/gone", []byte("gone"))
+	srv.put("backup2/b.txt", []byte("sibling"))
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.txt": "a", "c.txt": "c"})
+	syncer := NewSyncer(newTestConfig(srv))
+
+	ops, err := syncer.Plan(dir, "backup", SyncOptions{Delete: true})
+	if err != nil {
+		t.Fatal("plan failed:", err)
+	}
+	expected := "upload backup/c.txt new, delete backup/gone gone"
+	if got := opsString(ops); got != expected {
+		t.Fatalf("unexpected plan:\n%s\nexpect:\n%s", got, expected)
+	}
+	if _, err = syncer.Sync(dir, "backup", SyncOptions{Delete: true}); err != nil {
+		t.Fatal("sync failed:", err)
+	}
+	expected = "[bucket:backup/a.txt bucket:backup/c.txt bucket:backup2/b.txt]"
+	if got := fmt.Sprint(srv.keys()); got != expected {
+		t.Fatalf("files under the sibling prefix should be kept:\n%s\nexpect:\n%s", got, expected)
+	}
+}
+
+func TestSyncerPlanListError(t *testing.T) {
+	srv := newFakeRsServer()
+	defer srv.Close()
+	srv.failLists = 1
+
+	_, err := NewSyncer(newTestConfig(srv)).Plan(t.TempDir(), "p/", SyncOptions{})
+	if !errors.Is(err, ErrServer) {
+		t.Fatal("expect list error, but got:", err)
+	}
+}
+
+func TestRunSyncOps(t *testing.T) {
+	ops := []SyncOp{{Key: "a"}, {Key: "b"}, {Key: "c"}}
+	injected := errors.New("injected")
+	err := runSyncOps(ops, 2, func(op *SyncOp) error {
+		if op.Key == "b" {
+			return injected
+		}
+		return nil
+	})
+	if err == nil || !strings.Contains(err.Error(), "1 of 3") {
+		t.Fatal("unexpected error:", err)
+	}
+	if ops[0].Err != nil || ops[1].Err != injected || ops[2].Err != nil {
+		t.Fatal("failed op should be marked:", ops)
+	}
+}
@@ -0,0 +1,117 @@
+package operation
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/x/slog.v1"
+)
+
+// TLSConfig 是访问 https 节点时的 TLS 配置。
+// ca_file 是 PEM 格式的 CA 证书，和系统的根证书一起用于校验服务端证书；
+// cert_file 和 key_file 是双向 TLS 使用的客户端证书和私钥；
+// insecure_skip_verify 跳过服务端证书校验，只能用于测试环境
+type TLSConfig struct {
+	CAFile             string `json:"ca_file" toml:"ca_file"`
+	CertFile           string `json:"cert_file" toml:"cert_file"`
+	KeyFile            string `json:"key_file" toml:"key_file"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify" toml:"insecure_skip_verify"`
+}
+
+var errInvalidCA = errors.New("no valid certificate in ca_file")
+
+func (c *TLSConfig) isZero() bool {
+	return c.CAFile == "" && c.CertFile == "" && c.KeyFile == "" && !c.InsecureSkipVerify
+}
+
+// tlsConfig 按配置生成 tls.Config，没有配置时返回 nil，使用 Go 默认的配置
+func (c *TLSConfig) tlsConfig() (*tls.Config, error) {
+	if c.isZero() {
+		return nil, nil
+	}
+	cfg := &tls.Config{InsecureSkipVerify: c.InsecureSkipVerify}
+	if c.CAFile != "" {
+		pem, err := ioutil.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errInvalidCA
+		}
+		cfg.RootCAs = pool
+	}
+	if c.CertFile != "" || c.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// withTLS 返回使用 c.TLS 的 base 的副本，没有配置 TLS 时返回 base。
+// 配置无效时不能静默地退回到不校验客户端证书或者不信任指定 CA 的连接，返回的 RoundTripper 让每个请求都失败并返回
+// TLS 配置的错误；Load 会提前检查 TLS 配置
+func withTLS(c *Config, base *http.Transport) http.RoundTripper {
+	cfg, err := c.TLS.tlsConfig()
+	if err != nil {
+		elog.Log(slog.LevelError, "invalid tls config", slog.F("tls", c.TLS), slog.Err(err))
+		return errTransport{&tlsConfigError{Err: err}}
+	}
+	if cfg == nil {
+		return base
+	}
+	t := base.Clone()
+	t.TLSClientConfig = cfg
+	return t
+}
+
+// tlsConfigError 是 TLS 配置无效时请求返回的错误，重试也不会成功
+type tlsConfigError struct {
+	Err error
+}
+
+func (e *tlsConfigError) Error() string {
+	return "invalid tls config: " + e.Err.Error()
+}
+
+func (e *tlsConfigError) Unwrap() error {
+	return e.Err
+}
+
+// HttpCode 返回 0，使重试策略不再重试
+func (e *tlsConfigError) HttpCode() int {
+	return 0
+}
+
+// errTransport 的每个请求都返回 err
+type errTransport struct {
+	err error
+}
+
+func (t errTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	return nil, t.err
+}
+
+// transportWithTLS 返回给 kodo 和 kodocli 使用的 Transport，没有配置 TLS 时返回 nil，使用它们默认的 Transport。
+// 相同的 TLS 配置只创建一次 Transport
+func transportWithTLS(c *Config) http.RoundTripper {
+	if c.TLS.isZero() {
+		return nil
+	}
+	return c.cachedTransport(fmt.Sprintf("default %+v", c.TLS), func() http.RoundTripper {
+		return withTLS(c, http.DefaultTransport.(*http.Transport))
+	})
+}
@@ -0,0 +1,80 @@
+package operation
+
+import (
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newFakeTLSRsServer 和 newFakeRsServer 相同，但是使用 https，返回的 CA 文件可以用于校验它的证书
+func newFakeTLSRsServer(t *testing.T) (*fakeRsServer, string) {
+	s := &fakeRsServer{files: map[string]fakeEntry{}, ops: map[string]int{}}
+	s.Server = httptest.NewTLSServer(http.HandlerFunc(s.serve))
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.Certificate().Raw})
+	if err := ioutil.WriteFile(caFile, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return s, caFile
+}
+
+func TestTLSCAFile(t *testing.T) {
+	rs, caFile := newFakeTLSRsServer(t)
+	defer rs.Close()
+	rs.put("a", []byte("a"))
+
+	c := newTestConfig(rs)
+	if _, err := NewLister(c).ListPrefix(""); err == nil || !strings.Contains(err.Error(), "certificate") {
+		t.Fatal("server certificate should not be trusted without ca_file:", err)
+	}
+
+	c.TLS.CAFile = caFile
+	if files, err := NewLister(c).ListPrefix(""); err != nil || len(files) != 1 {
+		t.Fatal("list with ca_file failed:", files, err)
+	}
+	client := c.DownTransport.client(c, downTransportDefaults)
+	resp, err := client.Get(rs.URL + "/stat/" + encodedEntry("a"))
+	if err != nil {
+		t.Fatal("configured transport should trust ca_file:", err)
+	}
+	resp.Body.Close()
+}
+
+func TestInvalidTLSConfig(t *testing.T) {
+	badCA := filepath.Join(t.TempDir(), "bad.pem")
+	if err := ioutil.WriteFile(badCA, []byte("not a certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []TLSConfig{
+		{CAFile: badCA},
+		{CAFile: filepath.Join(t.TempDir(), "missing.pem")},
+		{CertFile: badCA, KeyFile: badCA},
+	} {
+		c := &Config{
+			UpHosts:  []string{"https://up"},
+			RsHosts:  []string{"https://rs"},
+			RsfHosts: []string{"https://rsf"},
+			Bucket:   "bucket",
+			Ak:       "ak",
+			Sk:       "sk",
+			TLS:      tc,
+			Retry:    RetryConfig{MaxAttempts: 3, InitialBackoff: 60 * 1000},
+		}
+		start := time.Now()
+		if _, err := NewLister(c).ListPrefix(""); err == nil || !strings.Contains(err.Error(), "invalid tls config") || errors.Is(err, ErrNetwork) {
+			t.Fatalf("invalid tls config should fail the request: %+v, %v", tc, err)
+		}
+		if err := NewUploader(c).UploadData([]byte("data"), "key"); err == nil || !strings.Contains(err.Error(), "invalid tls config") {
+			t.Fatalf("invalid tls config should fail the upload: %+v, %v", tc, err)
+		}
+		if elapsed := time.Since(start); elapsed > 10*time.Second {
+			t.Fatal("invalid tls config should not be retried:", elapsed)
+		}
+	}
+}
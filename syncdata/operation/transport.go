@@ -0,0 +1,129 @@
+package operation
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/x/slog.v1"
+)
+
+// TransportConfig 是 HTTP 客户端的配置，时间的单位都是毫秒，为 0 的配置项使用各客户端原来的默认值。
+// 下载（down_transport）默认连接超时 1 秒、请求超时 10 分钟；查询 uc（uc_transport）默认连接超时 500 毫秒、
+// 请求超时 1 秒；上传（up_transport）默认连接超时 30 秒、请求超时 10 分钟。
+// 由同一个 Config 创建的对象共享 Transport 和其中的连接池
+type TransportConfig struct {
+	DialTimeout           int64  `json:"dial_timeout" toml:"dial_timeout"`
+	TLSHandshakeTimeout   int64  `json:"tls_handshake_timeout" toml:"tls_handshake_timeout"`
+	ResponseHeaderTimeout int64  `json:"response_header_timeout" toml:"response_header_timeout"`
+	IdleConnTimeout       int64  `json:"idle_conn_timeout" toml:"idle_conn_timeout"`
+	Timeout               int64  `json:"timeout" toml:"timeout"`                         // 整个请求（包括读取响应）的超时时间
+	KeepAlive             int64  `json:"keep_alive" toml:"keep_alive"`                   // TCP keepalive 的间隔，负数表示关闭
+	DisableKeepAlives     bool   `json:"disable_keep_alives" toml:"disable_keep_alives"` // 每个请求使用新的连接
+	MaxConnsPerHost       int    `json:"max_conns_per_host" toml:"max_conns_per_host"`
+	MaxIdleConnsPerHost   int    `json:"max_idle_conns_per_host" toml:"max_idle_conns_per_host"`
+	Proxy                 string `json:"proxy" toml:"proxy"` // 代理地址，为空时使用 HTTP_PROXY 等环境变量
+
+	// RoundTripper 不为空时直接使用，忽略除 Timeout 以外的配置和 TLS 配置
+	RoundTripper http.RoundTripper `json:"-" toml:"-"`
+}
+
+// transportDefaults 是各客户端原来的默认值
+type transportDefaults struct {
+	dialTimeout time.Duration
+	timeout     time.Duration
+}
+
+var (
+	downTransportDefaults = transportDefaults{dialTimeout: 1 * time.Second, timeout: 10 * time.Minute}
+	ucTransportDefaults   = transportDefaults{dialTimeout: 500 * time.Millisecond, timeout: 1 * time.Second}
+	upTransportDefaults   = transportDefaults{dialTimeout: 30 * time.Second, timeout: 10 * time.Minute}
+)
+
+func (tc *TransportConfig) isZero() bool {
+	return tc.DialTimeout == 0 && tc.TLSHandshakeTimeout == 0 && tc.ResponseHeaderTimeout == 0 && tc.IdleConnTimeout == 0 &&
+		tc.Timeout == 0 && tc.KeepAlive == 0 && !tc.DisableKeepAlives && tc.MaxConnsPerHost == 0 &&
+		tc.MaxIdleConnsPerHost == 0 && tc.Proxy == "" && tc.RoundTripper == nil
+}
+
+func (tc *TransportConfig) validate() error {
+	if tc.Proxy != "" {
+		if _, err := url.Parse(tc.Proxy); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func millis(ms int64, def time.Duration) time.Duration {
+	if ms == 0 {
+		return def
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
+var transportLock sync.Mutex
+
+// cachedTransport 返回 c 中按 key 缓存的 Transport，没有时用 build 创建并缓存，
+// 使由同一个 Config 创建的对象共享连接池。key 包含影响 Transport 的所有配置，修改配置后会创建新的 Transport
+func (c *Config) cachedTransport(key string, build func() http.RoundTripper) http.RoundTripper {
+	transportLock.Lock()
+	defer transportLock.Unlock()
+	if t, ok := c.transports[key]; ok {
+		return t
+	}
+	t := build()
+	if c.transports == nil {
+		c.transports = make(map[string]http.RoundTripper)
+	}
+	c.transports[key] = t
+	return t
+}
+
+// transport 返回按配置和 c.TLS 创建的 RoundTripper，相同的配置只创建一次
+func (tc *TransportConfig) transport(c *Config, def transportDefaults) http.RoundTripper {
+	if tc.RoundTripper != nil {
+		return tc.RoundTripper
+	}
+	key := fmt.Sprintf("%+v %+v %+v", def, *tc, c.TLS)
+	return c.cachedTransport(key, func() http.RoundTripper { return tc.newTransport(c, def) })
+}
+
+func (tc *TransportConfig) newTransport(c *Config, def transportDefaults) http.RoundTripper {
+	proxy := http.ProxyFromEnvironment
+	if tc.Proxy != "" {
+		if u, err := url.Parse(tc.Proxy); err != nil {
+			elog.Log(slog.LevelError, "invalid proxy", slog.F("proxy", tc.Proxy), slog.Err(err))
+		} else {
+			proxy = http.ProxyURL(u)
+		}
+	}
+	t := &http.Transport{
+		Proxy: proxy,
+		DialContext: (&net.Dialer{
+			Timeout:   millis(tc.DialTimeout, def.dialTimeout),
+			KeepAlive: millis(tc.KeepAlive, 30*time.Second),
+			DualStack: true,
+		}).DialContext,
+		MaxIdleConns:          100,
+		MaxConnsPerHost:       tc.MaxConnsPerHost,
+		MaxIdleConnsPerHost:   tc.MaxIdleConnsPerHost,
+		IdleConnTimeout:       millis(tc.IdleConnTimeout, 90*time.Second),
+		TLSHandshakeTimeout:   millis(tc.TLSHandshakeTimeout, 10*time.Second),
+		ResponseHeaderTimeout: millis(tc.ResponseHeaderTimeout, 0),
+		ExpectContinueTimeout: 1 * time.Second,
+		DisableKeepAlives:     tc.DisableKeepAlives,
+	}
+	return withTLS(c, t)
+}
+
+// client 创建一个客户端对象独占的 http.Client，Transport 由同一个 Config 创建的对象共享
+func (tc *TransportConfig) client(c *Config, def transportDefaults) *http.Client {
+	return &http.Client{
+		Transport: tc.transport(c, def),
+		Timeout:   millis(tc.Timeout, def.timeout),
+	}
+}
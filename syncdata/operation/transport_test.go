@@ -0,0 +1,57 @@
+package operation
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestTransportShared(t *testing.T) {
+	c := &Config{
+		IoHosts:       []string{"http://io"},
+		UcHosts:       []string{"http://uc"},
+		UpHosts:       []string{"http://up"},
+		RsHosts:       []string{"http://rs"},
+		RsfHosts:      []string{"http://rsf"},
+		DownTransport: TransportConfig{DialTimeout: 100, MaxConnsPerHost: 8},
+		UpTransport:   TransportConfig{Timeout: 60000},
+	}
+	d1, d2 := NewDownloader(c), NewDownloader(c)
+	if d1.client == d2.client || d1.client.Transport != d2.client.Transport {
+		t.Fatal("downloaders should share the transport but not the client")
+	}
+	if tr := d1.client.Transport.(*http.Transport); tr.MaxConnsPerHost != 8 || d1.client.Timeout != 10*time.Minute {
+		t.Fatal("down_transport should be applied:", tr.MaxConnsPerHost, d1.client.Timeout)
+	}
+	if d1.queryer.client.Transport != NewQueryer(c).client.Transport || d1.queryer.client.Transport == d1.client.Transport {
+		t.Fatal("queryers should share the uc transport")
+	}
+	u1, u2 := NewUploader(c), NewUploader(c)
+	if u1.transport == nil || u1.transport != u2.transport || u1.timeout != time.Minute {
+		t.Fatal("uploaders should share the up transport")
+	}
+
+	c.DownTransport.MaxConnsPerHost = 16
+	if tr := NewDownloader(c).client.Transport.(*http.Transport); tr == d1.client.Transport || tr.MaxConnsPerHost != 16 {
+		t.Fatal("changed config should create a new transport")
+	}
+}
+
+func TestTransportWithTLSShared(t *testing.T) {
+	c := &Config{RsHosts: []string{"https://rs"}, RsfHosts: []string{"https://rsf"}}
+	if NewLister(c).transport != nil {
+		t.Fatal("default transport should be used without tls config")
+	}
+	c.TLS.InsecureSkipVerify = true
+	l1, l2 := NewLister(c), NewLister(c)
+	tr, ok := l1.transport.(*http.Transport)
+	if !ok || tr == http.DefaultTransport || l1.transport != l2.transport || !tr.TLSClientConfig.InsecureSkipVerify {
+		t.Fatal("listers should share one transport with tls config")
+	}
+
+	rt := &http.Transport{}
+	c.DownTransport.RoundTripper = rt
+	if NewDownloader(c).client.Transport != rt {
+		t.Fatal("configured RoundTripper should be used directly")
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"encoding/json"
 	"io"
 	"io/ioutil"
+	"net/http"
 	"os"
 	"strings"
 	"time"
@@ -14,6 +15,11 @@ import (
 	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/api.v7/auth/qbox"
 	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/api.v8/kodo"
 	q "github.com/ufilesdk-dev/us3-qiniu-go-sdk/api.v8/kodocli"
+	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/x/hostpool.v1"
+	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/x/httputil.v1"
+	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/x/metrics.v1"
+	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/x/retry.v1"
+	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/x/slog.v1"
 )
 
 type Uploader struct {
@@ -23,6 +29,16 @@ type Uploader struct {
 	partSize      int64
 	upConcurrency int
 	queryer       *Queryer
+	checkpoints   *checkpointStore
+	progress      ProgressListener
+	retry         *retry.Policy
+	partRetry     *retry.Policy
+	selector      hostpool.Selector
+	pool          *hostpool.Pool
+	metrics       metrics.Collector
+	useHTTPS      bool
+	transport     http.RoundTripper
+	timeout       time.Duration
 }
 
 func (p *Uploader) makeUptoken(policy *kodo.PutPolicy) string {
@@ -34,10 +50,20 @@ func (p *Uploader) makeUptoken(policy *kodo.PutPolicy) string {
 	return qbox.SignWithData(p.credentials, b)
 }
 
+// SetProgressListener 设置上传进度通知，传入 nil 关闭通知
+func (p *Uploader) SetProgressListener(listener ProgressListener) {
+	p.progress = listener
+}
+
 func (p *Uploader) UploadData(data []byte, key string) (err error) {
+	return p.UploadDataWithContext(context.Background(), data, key)
+}
+
+func (p *Uploader) UploadDataWithContext(ctx context.Context, data []byte, key string) (err error) {
+	defer func() { err = wrapError("upload", key, "", err) }()
 	t := time.Now()
 	defer func() {
-		elog.Info("up time ", key, time.Now().Sub(t))
+		elog.Log(slog.LevelInfo, "upload finished", slog.Op("upload"), slog.Key(key), slog.Duration(time.Since(t)))
 	}()
 	key = strings.TrimPrefix(key, "/")
 	policy := kodo.PutPolicy{
@@ -49,30 +75,46 @@ func (p *Uploader) UploadData(data []byte, key string) (err error) {
 
 	upHosts := p.upHosts
 	if p.queryer != nil {
-		if hosts := p.queryer.QueryUpHosts(false); len(hosts) > 0 {
+		if hosts := p.queryer.QueryUpHosts(p.useHTTPS); len(hosts) > 0 {
 			upHosts = hosts
 		}
 	}
 
+	progress := newProgressTracker(p.progress, key, int64(len(data)))
 	var uploader = q.NewUploader(1, &q.UploadConfig{
 		UpHosts:        upHosts,
 		UploadPartSize: p.partSize,
 		Concurrency:    p.upConcurrency,
+		OnProgress:     progress.uploadCallback(),
+		Retry:          p.partRetry,
+		HostSelector:   p.selector,
+		HostPool:       p.pool,
+		Metrics:        p.metrics,
+		Transport:      p.transport,
+		Timeout:        p.timeout,
 	})
-	for i := 0; i < 3; i++ {
-		err = uploader.Put2(context.Background(), nil, upToken, key, bytes.NewReader(data), int64(len(data)), nil)
-		if err == nil {
-			break
+	err = p.retry.Do(ctx, func(i int) error {
+		if i > 0 {
+			elog.Log(slog.LevelInfo, "retry", slog.Op("upload"), slog.Key(key), slog.F("attempt", i), slog.Err(err))
 		}
-		elog.Info("small upload retry", i, err)
+		err = uploader.Put2(ctx, nil, upToken, key, bytes.NewReader(data), int64(len(data)), nil)
+		return err
+	})
+	if err == nil {
+		progress.finish()
 	}
 	return
 }
 
 func (p *Uploader) UploadDataReader(data io.ReaderAt, size int, key string) (err error) {
+	return p.UploadDataReaderWithContext(context.Background(), data, size, key)
+}
+
+func (p *Uploader) UploadDataReaderWithContext(ctx context.Context, data io.ReaderAt, size int, key string) (err error) {
+	defer func() { err = wrapError("upload", key, "", err) }()
 	t := time.Now()
 	defer func() {
-		elog.Info("up time ", key, time.Now().Sub(t))
+		elog.Log(slog.LevelInfo, "upload finished", slog.Op("upload"), slog.Key(key), slog.Duration(time.Since(t)))
 	}()
 	key = strings.TrimPrefix(key, "/")
 	policy := kodo.PutPolicy{
@@ -84,31 +126,47 @@ func (p *Uploader) UploadDataReader(data io.ReaderAt, size int, key string) (err
 
 	upHosts := p.upHosts
 	if p.queryer != nil {
-		if hosts := p.queryer.QueryUpHosts(false); len(hosts) > 0 {
+		if hosts := p.queryer.QueryUpHosts(p.useHTTPS); len(hosts) > 0 {
 			upHosts = hosts
 		}
 	}
 
+	progress := newProgressTracker(p.progress, key, int64(size))
 	var uploader = q.NewUploader(1, &q.UploadConfig{
 		UpHosts:        upHosts,
 		UploadPartSize: p.partSize,
 		Concurrency:    p.upConcurrency,
+		OnProgress:     progress.uploadCallback(),
+		Retry:          p.partRetry,
+		HostSelector:   p.selector,
+		HostPool:       p.pool,
+		Metrics:        p.metrics,
+		Transport:      p.transport,
+		Timeout:        p.timeout,
 	})
 
-	for i := 0; i < 3; i++ {
-		err = uploader.Put2(context.Background(), nil, upToken, key, newReaderAtNopCloser(data), int64(size), nil)
-		if err == nil {
-			break
+	err = p.retry.Do(ctx, func(i int) error {
+		if i > 0 {
+			elog.Log(slog.LevelInfo, "retry", slog.Op("upload"), slog.Key(key), slog.F("attempt", i), slog.Err(err))
 		}
-		elog.Info("small upload retry", i, err)
+		err = uploader.Put2(ctx, nil, upToken, key, newReaderAtNopCloser(data), int64(size), nil)
+		return err
+	})
+	if err == nil {
+		progress.finish()
 	}
 	return
 }
 
 func (p *Uploader) Upload(file string, key string) (err error) {
+	return p.UploadWithContext(context.Background(), file, key)
+}
+
+func (p *Uploader) UploadWithContext(ctx context.Context, file string, key string) (err error) {
+	defer func() { err = wrapError("upload", key, "", err) }()
 	t := time.Now()
 	defer func() {
-		elog.Info("up time ", key, time.Now().Sub(t))
+		elog.Log(slog.LevelInfo, "upload finished", slog.Op("upload"), slog.Key(key), slog.Duration(time.Since(t)))
 	}()
 	key = strings.TrimPrefix(key, "/")
 	policy := kodo.PutPolicy{
@@ -119,58 +177,121 @@ func (p *Uploader) Upload(file string, key string) (err error) {
 
 	f, err := os.Open(file)
 	if err != nil {
-		elog.Info("open file failed: ", file, err)
+		elog.Log(slog.LevelWarn, "open file failed", slog.Op("upload"), slog.Key(key), slog.F("path", file), slog.Err(err))
 		return err
 	}
 	defer f.Close()
 
 	fInfo, err := f.Stat()
 	if err != nil {
-		elog.Info("get file stat failed: ", err)
+		elog.Log(slog.LevelWarn, "get file stat failed", slog.Op("upload"), slog.Key(key), slog.F("path", file), slog.Err(err))
 		return err
 	}
 
 	upHosts := p.upHosts
 	if p.queryer != nil {
-		if hosts := p.queryer.QueryUpHosts(false); len(hosts) > 0 {
+		if hosts := p.queryer.QueryUpHosts(p.useHTTPS); len(hosts) > 0 {
 			upHosts = hosts
 		}
 	}
 
+	progress := newProgressTracker(p.progress, key, fInfo.Size())
+	defer func() {
+		if err == nil {
+			progress.finish()
+		}
+	}()
 	var uploader = q.NewUploader(1, &q.UploadConfig{
 		UpHosts:        upHosts,
 		UploadPartSize: p.partSize,
 		Concurrency:    p.upConcurrency,
+		OnProgress:     progress.uploadCallback(),
+		Retry:          p.partRetry,
+		HostSelector:   p.selector,
+		HostPool:       p.pool,
+		Metrics:        p.metrics,
+		Transport:      p.transport,
+		Timeout:        p.timeout,
 	})
 
 	if fInfo.Size() <= p.partSize {
-		for i := 0; i < 3; i++ {
-			err = uploader.Put2(context.Background(), nil, upToken, key, newReaderAtNopCloser(f), fInfo.Size(), nil)
-			if err == nil {
-				break
+		return p.retry.Do(ctx, func(i int) error {
+			if i > 0 {
+				elog.Log(slog.LevelInfo, "retry", slog.Op("upload"), slog.Key(key), slog.F("attempt", i), slog.Err(err))
 			}
-			elog.Info("small upload retry", i, err)
+			err = uploader.Put2(ctx, nil, upToken, key, newReaderAtNopCloser(f), fInfo.Size(), nil)
+			return err
+		})
+	}
+
+	if p.checkpoints != nil {
+		return p.uploadWithCheckpoint(ctx, &uploader, upToken, key, f, fInfo)
+	}
+
+	return p.retry.Do(ctx, func(i int) error {
+		if i > 0 {
+			elog.Log(slog.LevelInfo, "retry", slog.Op("upload_multipart"), slog.Key(key), slog.F("attempt", i), slog.Err(err))
 		}
-		return
+		err = uploader.Upload(ctx, nil, upToken, key, newReaderAtNopCloser(f), fInfo.Size(), nil,
+			func(partIdx int, etag string) {
+				elog.Log(slog.LevelDebug, "part uploaded", slog.Op("upload_multipart"), slog.Key(key), slog.F("part", partIdx), slog.F("etag", etag))
+			})
+		return err
+	})
+}
+
+func (p *Uploader) uploadWithCheckpoint(ctx context.Context, uploader *q.Uploader, upToken, key string, f *os.File, fInfo os.FileInfo) (err error) {
+	cp := p.checkpoints.load(p.bucket, key, fInfo, p.partSize)
+	policy := *p.retry
+	policy.Retryable = func(err error) bool {
+		return httputil.DetectCode(err) == 612 || p.retry.ShouldRetry(err)
 	}
+	return policy.Do(ctx, func(i int) error {
+		if i > 0 {
+			elog.Log(slog.LevelInfo, "retry", slog.Op("upload_multipart"), slog.Key(key), slog.F("attempt", i), slog.Err(err))
+		}
+		if cp == nil {
+			uploadId, err := uploader.InitMultipart(ctx, upToken, key)
+			if err != nil {
+				return err
+			}
+			if cp, err = p.checkpoints.create(p.bucket, key, fInfo, p.partSize, uploadId); err != nil {
+				// 无法保存进度时终止刚创建的 uploadId，这次尝试改为不带断点续传的分片上传，失败时由 kodocli 删除已上传的分片
+				elog.Log(slog.LevelWarn, "create checkpoint failed, upload without checkpoint", slog.Op("upload_multipart"), slog.Key(key), slog.F("upload_id", uploadId), slog.Err(err))
+				if e := uploader.AbortMultipart(context.Background(), upToken, key, uploadId); e != nil {
+					elog.Log(slog.LevelWarn, "abort multipart failed", slog.Op("upload_multipart"), slog.Key(key), slog.F("upload_id", uploadId), slog.Err(e))
+				}
+				return uploader.Upload(ctx, nil, upToken, key, newReaderAtNopCloser(f), fInfo.Size(), nil, nil)
+			}
+		} else {
+			elog.Log(slog.LevelInfo, "resume upload", slog.Op("upload_multipart"), slog.Key(key), slog.F("upload_id", cp.UploadId), slog.F("parts", len(cp.Parts)))
+		}
 
-	for i := 0; i < 3; i++ {
-		err = uploader.Upload(context.Background(), nil, upToken, key, newReaderAtNopCloser(f), fInfo.Size(), nil,
+		err = uploader.ResumeUpload(ctx, nil, upToken, key, cp.UploadId, cp.parts(), newReaderAtNopCloser(f), fInfo.Size(), nil,
 			func(partIdx int, etag string) {
-				elog.Info("callback", partIdx, etag)
+				if err := cp.addPart(partIdx, etag); err != nil {
+					elog.Log(slog.LevelWarn, "save checkpoint failed", slog.Op("upload_multipart"), slog.Key(key), slog.F("part", partIdx), slog.Err(err))
+				}
 			})
 		if err == nil {
-			break
+			cp.remove()
+		} else if httputil.DetectCode(err) == 612 { // uploadId 已经过期或者不存在，需要重新上传
+			cp.remove()
+			cp = nil
 		}
-		elog.Info("part upload retry", i, err)
-	}
-	return
+		return err
+	})
 }
 
 func (p *Uploader) UploadReader(reader io.Reader, key string) (err error) {
+	return p.UploadReaderWithContext(context.Background(), reader, key)
+}
+
+func (p *Uploader) UploadReaderWithContext(ctx context.Context, reader io.Reader, key string) (err error) {
+	defer func() { err = wrapError("upload", key, "", err) }()
 	t := time.Now()
 	defer func() {
-		elog.Info("up time ", key, time.Now().Sub(t))
+		elog.Log(slog.LevelInfo, "upload finished", slog.Op("upload"), slog.Key(key), slog.Duration(time.Since(t)))
 	}()
 	key = strings.TrimPrefix(key, "/")
 	policy := kodo.PutPolicy{
@@ -181,7 +302,7 @@ func (p *Uploader) UploadReader(reader io.Reader, key string) (err error) {
 
 	upHosts := p.upHosts
 	if p.queryer != nil {
-		if hosts := p.queryer.QueryUpHosts(false); len(hosts) > 0 {
+		if hosts := p.queryer.QueryUpHosts(p.useHTTPS); len(hosts) > 0 {
 			upHosts = hosts
 		}
 	}
@@ -190,8 +311,21 @@ func (p *Uploader) UploadReader(reader io.Reader, key string) (err error) {
 		UpHosts:        upHosts,
 		UploadPartSize: p.partSize,
 		Concurrency:    p.upConcurrency,
+		Retry:          p.partRetry,
+		HostSelector:   p.selector,
+		HostPool:       p.pool,
+		Metrics:        p.metrics,
+		Transport:      p.transport,
+		Timeout:        p.timeout,
 	})
 
+	var progress *progressTracker
+	defer func() {
+		if err == nil {
+			progress.finish()
+		}
+	}()
+
 	bufReader := bufio.NewReader(reader)
 	firstPart, err := ioutil.ReadAll(io.LimitReader(bufReader, p.partSize))
 	if err != nil {
@@ -210,29 +344,37 @@ func (p *Uploader) UploadReader(reader io.Reader, key string) (err error) {
 	}
 
 	if smallUpload {
-		for i := 0; i < 3; i++ {
-			err = uploader.Put2(context.Background(), nil, upToken, key, bytes.NewReader(firstPart), int64(len(firstPart)), nil)
-			if err == nil {
-				break
+		progress = newProgressTracker(p.progress, key, int64(len(firstPart)))
+		uploader.OnProgress = progress.uploadCallback()
+		return p.retry.Do(ctx, func(i int) error {
+			if i > 0 {
+				elog.Log(slog.LevelInfo, "retry", slog.Op("upload"), slog.Key(key), slog.F("attempt", i), slog.Err(err))
 			}
-			elog.Info("small upload retry", i, err)
-		}
-		return
+			err = uploader.Put2(ctx, nil, upToken, key, bytes.NewReader(firstPart), int64(len(firstPart)), nil)
+			return err
+		})
 	}
 
-	err = uploader.StreamUpload(context.Background(), nil, upToken, key, io.MultiReader(bytes.NewReader(firstPart), bufReader),
+	progress = newProgressTracker(p.progress, key, -1)
+	uploader.OnProgress = progress.uploadCallback()
+	err = uploader.StreamUpload(ctx, nil, upToken, key, io.MultiReader(bytes.NewReader(firstPart), bufReader),
 		func(partIdx int, etag string) {
-			elog.Info("callback", partIdx, etag)
+			elog.Log(slog.LevelDebug, "part uploaded", slog.Op("upload_multipart"), slog.Key(key), slog.F("part", partIdx), slog.F("etag", etag))
 		})
 	return err
 }
 
-func NewUploader(c *Config) *Uploader {
-	mac := qbox.NewMac(c.Ak, c.Sk)
+// uploadPartSize 返回分片上传使用的分片大小，配置的单位是 MB，最小为 4MB
+func uploadPartSize(c *Config) int64 {
 	part := c.PartSize * 1024 * 1024
 	if part < 4*1024*1024 {
 		part = 4 * 1024 * 1024
 	}
+	return part
+}
+
+func NewUploader(c *Config) *Uploader {
+	mac := qbox.NewMac(c.Ak, c.Sk)
 	var queryer *Queryer = nil
 
 	if len(c.UcHosts) > 0 {
@@ -243,10 +385,27 @@ func NewUploader(c *Config) *Uploader {
 		bucket:        c.Bucket,
 		upHosts:       dupStrings(c.UpHosts),
 		credentials:   mac,
-		partSize:      part,
+		partSize:      uploadPartSize(c),
 		upConcurrency: c.UpConcurrency,
 		queryer:       queryer,
+		checkpoints:   newCheckpointStore(c.CheckpointDir),
+		retry:         c.Retry.policy(defaultUpRetryTimes),
+		partRetry:     c.Retry.kodocliPolicy(),
+		selector:      newHostSelector(c),
+		pool:          c.hostPool(),
+		metrics:       c.Metrics,
+		useHTTPS:      c.UseHTTPS,
+		transport:     upTransport(c),
+		timeout:       millis(c.UpTransport.Timeout, upTransportDefaults.timeout),
+	}
+}
+
+// upTransport 返回上传使用的 Transport，没有配置 up_transport 和 TLS 时返回 nil，使用 kodocli 默认的 Transport
+func upTransport(c *Config) http.RoundTripper {
+	if c.UpTransport.isZero() {
+		return transportWithTLS(c)
 	}
+	return c.UpTransport.transport(c, upTransportDefaults)
 }
 
 func NewUploaderV2() *Uploader {
@@ -0,0 +1,256 @@
+package hostpool
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/x/metrics.v1"
+)
+
+// State 是节点熔断器的状态
+type State int
+
+const (
+	// StateClosed 节点正常
+	StateClosed State = iota
+	// StateOpen 节点被熔断，不会被优先选择
+	StateOpen
+	// StateHalfOpen 熔断超时或者探测成功后的试探状态，下一次请求成功后恢复为 StateClosed，失败则重新熔断
+	StateHalfOpen
+)
+
+var stateNames = []string{"closed", "open", "half_open"}
+
+func (s State) String() string {
+
+	if s >= StateClosed && int(s) < len(stateNames) {
+		return stateNames[s]
+	}
+	return "unknown"
+}
+
+func (s State) MarshalText() ([]byte, error) {
+
+	return []byte(s.String()), nil
+}
+
+// BreakerConfig 是熔断器的参数
+type BreakerConfig struct {
+	// MaxFailures 是 Window 内连续失败多少次后熔断
+	MaxFailures int
+	// Window 是统计连续失败的时间窗口
+	Window time.Duration
+	// OpenTimeout 是没有探测时，熔断多久后进入 StateHalfOpen。
+	// 有 Prober 在运行时，熔断的节点只有探测成功后才会进入 StateHalfOpen
+	OpenTimeout time.Duration
+}
+
+// DefaultBreakerConfig 和原来的策略一致：1 分钟内连续失败 5 次熔断，1 分钟后恢复
+var DefaultBreakerConfig = BreakerConfig{
+	MaxFailures: 5,
+	Window:      1 * time.Minute,
+	OpenTimeout: 1 * time.Minute,
+}
+
+// HostHealth 是节点健康状态表中的一项
+type HostHealth struct {
+	Host        string    `json:"host"`
+	State       State     `json:"state"`
+	Failures    int       `json:"failures"` // 当前连续失败的次数
+	LastFailure time.Time `json:"last_failure"`
+	OpenedAt    time.Time `json:"opened_at"` // 最近一次熔断的时间，StateClosed 时为零值
+	LastProbe   time.Time `json:"last_probe"`
+	ProbeError  string    `json:"probe_error,omitempty"`
+}
+
+type breaker struct {
+	state      State
+	failures   []time.Time // 最近的连续失败时间，最多保留 MaxFailures 个
+	openedAt   time.Time
+	lastProbe  time.Time
+	probeError string
+}
+
+// Pool 记录一组节点的健康状态，为每个节点维护一个熔断器，实现必须可以被多个 goroutine 同时调用。
+// Pool 不是全局的：使用同一组节点的客户端共享一个 Pool，访问不同区域的客户端各自使用自己的 Pool，
+// 一个区域的故障不会影响另一个区域节点的状态。
+// nil 的 *Pool 可以直接使用：所有节点都视为可用，不记录任何状态
+type Pool struct {
+	// OnStateChange 在节点被熔断（healthy 为 false）或者恢复为 StateClosed 时调用，不能阻塞。
+	// NewPool 把它设置为上报到 metrics.Default()
+	OnStateChange func(host string, healthy bool)
+
+	cfg      BreakerConfig
+	mu       sync.Mutex
+	breakers map[string]*breaker
+	probing  int
+}
+
+// NewPool 创建一个 Pool，cfg 中为 0 的参数使用 DefaultBreakerConfig 中的值
+//
+func NewPool(cfg BreakerConfig) *Pool {
+
+	if cfg.MaxFailures <= 0 {
+		cfg.MaxFailures = DefaultBreakerConfig.MaxFailures
+	}
+	if cfg.Window <= 0 {
+		cfg.Window = DefaultBreakerConfig.Window
+	}
+	if cfg.OpenTimeout <= 0 {
+		cfg.OpenTimeout = DefaultBreakerConfig.OpenTimeout
+	}
+	return &Pool{
+		OnStateChange: func(host string, healthy bool) {
+			metrics.Default().SetHostHealthy(host, healthy)
+		},
+		cfg:      cfg,
+		breakers: make(map[string]*breaker),
+	}
+}
+
+func (p *Pool) breaker(host string) *breaker {
+
+	b, ok := p.breakers[host]
+	if !ok {
+		b = &breaker{}
+		p.breakers[host] = b
+	}
+	return b
+}
+
+func (p *Pool) notify(host string, healthy bool) {
+
+	if p.OnStateChange != nil {
+		p.OnStateChange(host, healthy)
+	}
+}
+
+// Usable 判断节点是否可用，熔断的节点在没有探测且超过 OpenTimeout 后进入 StateHalfOpen
+//
+func (p *Pool) Usable(host string) bool {
+
+	if p == nil {
+		return true
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	b, ok := p.breakers[host]
+	if !ok || b.state != StateOpen {
+		return true
+	}
+	if p.probing == 0 && time.Since(b.openedAt) >= p.cfg.OpenTimeout {
+		b.state = StateHalfOpen
+		return true
+	}
+	return false
+}
+
+// Fail 记录一次节点的失败
+//
+func (p *Pool) Fail(host string) {
+
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	now := time.Now()
+	b := p.breaker(host)
+	b.failures = append(b.failures, now)
+	if len(b.failures) > p.cfg.MaxFailures {
+		b.failures = b.failures[len(b.failures)-p.cfg.MaxFailures:]
+	}
+	opened := false
+	switch b.state {
+	case StateHalfOpen:
+		b.state, b.openedAt = StateOpen, now
+	case StateClosed:
+		if len(b.failures) >= p.cfg.MaxFailures && now.Sub(b.failures[0]) <= p.cfg.Window {
+			b.state, b.openedAt, opened = StateOpen, now, true
+		}
+	}
+	p.mu.Unlock()
+	if opened {
+		p.notify(host, false)
+	}
+}
+
+// Succeed 记录一次节点的成功，StateHalfOpen 的节点恢复为 StateClosed
+//
+func (p *Pool) Succeed(host string) {
+
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	b, ok := p.breakers[host]
+	if !ok {
+		p.mu.Unlock()
+		return
+	}
+	recovered := b.state != StateClosed
+	if recovered && b.state == StateOpen && p.probing > 0 {
+		// 熔断的节点只能由探测恢复，进行中的请求成功不能让它恢复
+		p.mu.Unlock()
+		return
+	}
+	b.state, b.failures = StateClosed, nil
+	p.mu.Unlock()
+	if recovered {
+		p.notify(host, true)
+	}
+}
+
+// probed 记录一次探测的结果：熔断的节点探测成功后进入 StateHalfOpen，
+// 正常的节点探测失败计为一次失败
+func (p *Pool) probed(host string, err error) {
+
+	p.mu.Lock()
+	b := p.breaker(host)
+	b.lastProbe = time.Now()
+	b.probeError = ""
+	if err != nil {
+		b.probeError = err.Error()
+	}
+	state := b.state
+	if err == nil && state == StateOpen {
+		b.state = StateHalfOpen
+	}
+	p.mu.Unlock()
+	if err != nil && state == StateClosed {
+		p.Fail(host)
+	}
+}
+
+// Health 返回节点健康状态表，按节点排序
+//
+func (p *Pool) Health() []HostHealth {
+
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ret := make([]HostHealth, 0, len(p.breakers))
+	for host, b := range p.breakers {
+		h := HostHealth{
+			Host:       host,
+			State:      b.state,
+			Failures:   len(b.failures),
+			OpenedAt:   b.openedAt,
+			LastProbe:  b.lastProbe,
+			ProbeError: b.probeError,
+		}
+		if n := len(b.failures); n > 0 {
+			h.LastFailure = b.failures[n-1]
+		}
+		if b.state == StateClosed {
+			h.OpenedAt = time.Time{}
+		}
+		ret = append(ret, h)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Host < ret[j].Host })
+	return ret
+}
+
+// --------------------------------------------------------------------
@@ -0,0 +1,162 @@
+package hostpool
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// ---------------------------------------------------
+
+func TestBreaker(t *testing.T) {
+
+	pool := NewPool(BreakerConfig{MaxFailures: 3, OpenTimeout: 50 * time.Millisecond})
+	var changes []bool
+	pool.OnStateChange = func(host string, healthy bool) { changes = append(changes, healthy) }
+
+	pool.Fail("h")
+	pool.Fail("h")
+	pool.Succeed("h")
+	pool.Fail("h")
+	pool.Fail("h")
+	if !pool.Usable("h") {
+		t.Fatal("success should reset failures")
+	}
+	pool.Fail("h")
+	if pool.Usable("h") {
+		t.Fatal("host should be open after 3 failures")
+	}
+	if h := pool.Health(); len(h) != 1 || h[0].State != StateOpen || h[0].Failures != 3 {
+		t.Fatal("health invalid:", h)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if !pool.Usable("h") || pool.Health()[0].State != StateHalfOpen {
+		t.Fatal("host should be half-open after open timeout")
+	}
+	pool.Fail("h")
+	if pool.Usable("h") {
+		t.Fatal("failure in half-open should reopen")
+	}
+	time.Sleep(60 * time.Millisecond)
+	pool.Usable("h")
+	pool.Succeed("h")
+	if h := pool.Health()[0]; h.State != StateClosed || h.Failures != 0 || !h.OpenedAt.IsZero() {
+		t.Fatal("success in half-open should close:", h)
+	}
+	if len(changes) != 2 || changes[0] || !changes[1] {
+		t.Fatal("state changes invalid:", changes)
+	}
+}
+
+func TestBreakerWindow(t *testing.T) {
+
+	pool := NewPool(BreakerConfig{MaxFailures: 2, Window: 20 * time.Millisecond})
+	pool.Fail("h")
+	time.Sleep(30 * time.Millisecond)
+	pool.Fail("h")
+	if !pool.Usable("h") {
+		t.Fatal("failures out of window should not open")
+	}
+	pool.Fail("h")
+	if pool.Usable("h") {
+		t.Fatal("failures in window should open")
+	}
+}
+
+func TestProber(t *testing.T) {
+
+	var healthy int32
+	probe := func(ctx context.Context, host string) error {
+		if host == "bad" || atomic.LoadInt32(&healthy) == 0 {
+			return errors.New("down")
+		}
+		return nil
+	}
+	pool := NewPool(BreakerConfig{MaxFailures: 1, OpenTimeout: time.Millisecond})
+	p := NewProber(pool, func() []string { return []string{"good", "bad", "good"} }, probe, time.Hour, 0).Start()
+	defer p.Stop()
+
+	pool.Fail("good")
+	time.Sleep(10 * time.Millisecond)
+	if pool.Usable("good") {
+		t.Fatal("open host should wait for probe while probing")
+	}
+	pool.Succeed("good")
+	if pool.Usable("good") {
+		t.Fatal("request success should not close open host while probing")
+	}
+	p.ProbeOnce()
+	if pool.Usable("good") {
+		t.Fatal("failed probe should keep host open")
+	}
+	atomic.StoreInt32(&healthy, 1)
+	p.ProbeOnce()
+	if !pool.Usable("good") {
+		t.Fatal("successful probe should re-enable host")
+	}
+	if pool.Usable("bad") {
+		t.Fatal("failed probe should count as failure")
+	}
+	h := pool.Health()
+	if len(h) != 2 || h[0].Host != "bad" || h[0].ProbeError != "down" || h[1].State != StateHalfOpen {
+		t.Fatal("health invalid:", h)
+	}
+
+	p.Stop()
+	time.Sleep(2 * time.Millisecond)
+	if !pool.Usable("bad") {
+		t.Fatal("open timeout should apply after prober stops")
+	}
+}
+
+func TestHTTPProbe(t *testing.T) {
+
+	code := 404
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(code)
+	}))
+	defer svr.Close()
+
+	probe := HTTPProbe(nil)
+	if err := probe(context.Background(), svr.URL); err != nil {
+		t.Fatal("4xx should be usable:", err)
+	}
+	code = 503
+	if err := probe(context.Background(), svr.URL); err == nil {
+		t.Fatal("5xx should fail")
+	}
+	if err := probe(context.Background(), "http://127.0.0.1:1"); err == nil {
+		t.Fatal("network error should fail")
+	}
+}
+
+func TestHealthJSON(t *testing.T) {
+
+	pool := NewPool(BreakerConfig{MaxFailures: 1})
+	pool.Fail("h")
+	b, err := json.Marshal(pool.Health())
+	if err != nil || !strings.Contains(string(b), `"host":"h","state":"open","failures":1`) {
+		t.Fatal("json invalid:", string(b), err)
+	}
+}
+
+func TestNilPool(t *testing.T) {
+
+	var pool *Pool
+	for i := 0; i < 10; i++ {
+		pool.Fail("h")
+	}
+	pool.Succeed("h")
+	if !pool.Usable("h") || pool.Health() != nil {
+		t.Fatal("nil pool should treat every host as usable")
+	}
+}
+
+// ---------------------------------------------------
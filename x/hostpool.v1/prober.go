@@ -0,0 +1,135 @@
+package hostpool
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ProbeFunc 探测一个节点是否可用
+type ProbeFunc func(ctx context.Context, host string) error
+
+// 默认探测参数
+var (
+	DefaultProbeInterval = 10 * time.Second
+	DefaultProbeTimeout  = 3 * time.Second
+)
+
+// HTTPProbe 返回通过 client 向节点发送 HEAD / 的 ProbeFunc，只要收到非 5xx 的响应就认为节点可用。
+// client 为 nil 时使用 http.DefaultClient
+func HTTPProbe(client *http.Client) ProbeFunc {
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return func(ctx context.Context, host string) error {
+		req, err := http.NewRequest("HEAD", host+"/", nil)
+		if err != nil {
+			return err
+		}
+		resp, err := client.Do(req.WithContext(ctx))
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode/100 == 5 {
+			return errors.New("probe returns " + strconv.Itoa(resp.StatusCode))
+		}
+		return nil
+	}
+}
+
+// Prober 在后台定期探测节点，并把结果记录到 Pool：熔断的节点只有探测成功后才会恢复，
+// 正常的节点探测失败计为一次失败
+type Prober struct {
+	pool     *Pool
+	hosts    func() []string
+	probe    ProbeFunc
+	interval time.Duration
+	timeout  time.Duration
+
+	once sync.Once
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewProber 创建一个探测 hosts() 返回的节点的 Prober，interval、timeout 为 0 时使用默认值，probe 为 nil 时使用 HTTPProbe(nil)
+func NewProber(pool *Pool, hosts func() []string, probe ProbeFunc, interval, timeout time.Duration) *Prober {
+
+	if probe == nil {
+		probe = HTTPProbe(nil)
+	}
+	if interval <= 0 {
+		interval = DefaultProbeInterval
+	}
+	if timeout <= 0 {
+		timeout = DefaultProbeTimeout
+	}
+	return &Prober{
+		pool: pool, hosts: hosts, probe: probe, interval: interval, timeout: timeout,
+		stop: make(chan struct{}), done: make(chan struct{}),
+	}
+}
+
+// Start 启动后台探测，在 Stop 之前熔断的节点不会因为超时自动恢复
+func (p *Prober) Start() *Prober {
+
+	p.pool.mu.Lock()
+	p.pool.probing++
+	p.pool.mu.Unlock()
+	go p.loop()
+	return p
+}
+
+// Stop 停止后台探测并等待进行中的探测结束，可以多次调用
+func (p *Prober) Stop() {
+
+	p.once.Do(func() {
+		close(p.stop)
+		<-p.done
+		p.pool.mu.Lock()
+		p.pool.probing--
+		p.pool.mu.Unlock()
+	})
+}
+
+func (p *Prober) loop() {
+
+	defer close(p.done)
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		p.ProbeOnce()
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ProbeOnce 并发地探测一遍所有节点
+func (p *Prober) ProbeOnce() {
+
+	seen := make(map[string]bool)
+	var wg sync.WaitGroup
+	for _, host := range p.hosts() {
+		if host == "" || seen[host] {
+			continue
+		}
+		seen[host] = true
+		wg.Add(1)
+		go func(host string) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+			defer cancel()
+			p.pool.probed(host, p.probe(ctx, host))
+		}(host)
+	}
+	wg.Wait()
+}
+
+// --------------------------------------------------------------------
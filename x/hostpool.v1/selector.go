@@ -0,0 +1,367 @@
+/*
+包 hostpool 提供在一组等价节点之间选择请求节点的策略
+
+	sel, err := hostpool.New(hostpool.EWMA)
+	host := sel.Select(hosts, isHostValid)
+	sel.Start(host)
+	start := time.Now()
+	err = doRequest(host)
+	sel.Done(host, time.Since(start), hostpool.HostFailed(err))
+
+除了轮询以外的策略都依赖 Start 和 Done 反馈的请求耗时、并发数和失败情况，
+调用方需要在每次请求前后调用它们。
+
+Pool 为每个节点维护一个熔断器（closed、open、half-open），Prober 在后台探测节点，
+熔断的节点只有探测成功后才会重新启用。
+*/
+package hostpool
+
+import (
+	"errors"
+	"math/rand"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/x/httputil.v1"
+)
+
+// 选择策略的名字，用于配置
+const (
+	RoundRobin    = "round_robin"
+	EWMA          = "ewma"
+	LeastInFlight = "least_inflight"
+	PowerOfTwo    = "p2c"
+)
+
+var ErrUnknownStrategy = errors.New("unknown host selector strategy")
+
+// Selector 选择请求使用的节点，实现必须可以被多个 goroutine 同时调用
+type Selector interface {
+	// Select 从 hosts 中选择一个节点，优先选择 usable 返回 true 的节点，都不可用时从全部节点中选择。
+	// usable 为 nil 时认为所有节点都可用，hosts 不能为空
+	Select(hosts []string, usable func(host string) bool) string
+	// Start 在向 host 发出请求之前调用
+	Start(host string)
+	// Done 在请求结束后调用，failed 表示请求因为节点的原因失败，例如网络错误或者 5xx
+	Done(host string, elapsed time.Duration, failed bool)
+}
+
+// 默认参数
+var (
+	DefaultMaxFindPercent = 50
+	DefaultDecay          = 0.3
+	DefaultFailurePenalty = 5 * time.Second
+	DefaultStatsTTL       = 1 * time.Minute
+)
+
+// New 按策略名创建 Selector，name 为空时使用轮询
+//
+func New(name string) (Selector, error) {
+
+	switch name {
+	case "", RoundRobin:
+		return NewRoundRobin(DefaultMaxFindPercent), nil
+	case EWMA:
+		return NewEWMA(), nil
+	case LeastInFlight:
+		return NewLeastInFlight(), nil
+	case PowerOfTwo:
+		return NewPowerOfTwo(), nil
+	}
+	return nil, ErrUnknownStrategy
+}
+
+// HostFailed 判断请求错误是否应该算作节点的失败：网络错误、429 和 5xx
+//
+func HostFailed(err error) bool {
+
+	if err == nil {
+		return false
+	}
+	code := httputil.DetectCode(err)
+	return code == 429 || code/100 == 5
+}
+
+// --------------------------------------------------------------------
+// 请求统计
+
+// Stats 记录每个节点的耗时指数加权平均值（EWMA）和正在进行的请求数
+type Stats struct {
+	// Decay 是新样本在 EWMA 中的权重，取值 (0, 1]
+	Decay float64
+	// FailurePenalty 是失败请求计入 EWMA 的最小耗时
+	FailurePenalty time.Duration
+	// TTL 之内没有更新的 EWMA 被忽略，使长时间没有被选中的慢节点有机会重新被探测
+	TTL time.Duration
+
+	mu    sync.Mutex
+	hosts map[string]*hostStat
+}
+
+type hostStat struct {
+	inflight int
+	ewma     float64 // 秒
+	updated  time.Time
+}
+
+func newStats() *Stats {
+
+	return &Stats{
+		Decay:          DefaultDecay,
+		FailurePenalty: DefaultFailurePenalty,
+		TTL:            DefaultStatsTTL,
+		hosts:          make(map[string]*hostStat),
+	}
+}
+
+func (s *Stats) stat(host string) *hostStat {
+
+	hs, ok := s.hosts[host]
+	if !ok {
+		hs = &hostStat{}
+		s.hosts[host] = hs
+	}
+	return hs
+}
+
+func (s *Stats) Start(host string) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stat(host).inflight++
+}
+
+func (s *Stats) Done(host string, elapsed time.Duration, failed bool) {
+
+	if failed && elapsed < s.FailurePenalty {
+		elapsed = s.FailurePenalty
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hs := s.stat(host)
+	if hs.inflight > 0 {
+		hs.inflight--
+	}
+	now := time.Now()
+	if hs.updated.IsZero() || now.Sub(hs.updated) > s.TTL {
+		hs.ewma = elapsed.Seconds()
+	} else {
+		hs.ewma = s.Decay*elapsed.Seconds() + (1-s.Decay)*hs.ewma
+	}
+	hs.updated = now
+}
+
+// Latency 返回节点当前的耗时 EWMA，没有样本或者样本过期时 ok 为 false
+//
+func (s *Stats) Latency(host string) (latency time.Duration, ok bool) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hs, found := s.hosts[host]
+	if !found || !s.fresh(hs, time.Now()) {
+		return 0, false
+	}
+	return time.Duration(hs.ewma * float64(time.Second)), true
+}
+
+// InFlight 返回节点正在进行的请求数
+//
+func (s *Stats) InFlight(host string) int {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if hs, ok := s.hosts[host]; ok {
+		return hs.inflight
+	}
+	return 0
+}
+
+func (s *Stats) fresh(hs *hostStat, now time.Time) bool {
+
+	return !hs.updated.IsZero() && now.Sub(hs.updated) <= s.TTL
+}
+
+// snapshot 返回 hosts 的耗时 EWMA（没有有效样本时为 0）和并发数
+func (s *Stats) snapshot(hosts []string) (ewma []float64, inflight []int) {
+
+	ewma, inflight = make([]float64, len(hosts)), make([]int, len(hosts))
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, host := range hosts {
+		if hs, ok := s.hosts[host]; ok {
+			if s.fresh(hs, now) {
+				ewma[i] = hs.ewma
+			}
+			inflight[i] = hs.inflight
+		}
+	}
+	return
+}
+
+// --------------------------------------------------------------------
+
+// candidates 返回 usable 的节点，都不可用时返回全部节点
+func candidates(hosts []string, usable func(string) bool) []string {
+
+	if usable == nil {
+		return hosts
+	}
+	valid := make([]string, 0, len(hosts))
+	for _, host := range hosts {
+		if usable(host) {
+			valid = append(valid, host)
+		}
+	}
+	if len(valid) == 0 {
+		return hosts
+	}
+	return valid
+}
+
+// pickMin 返回 score 最小的下标，分数相同时从 offset 开始轮流选择，避免总是选中第一个节点
+func pickMin(n int, offset uint32, score func(i int) float64) int {
+
+	best, bestScore := -1, 0.0
+	for j := 0; j < n; j++ {
+		i := int((offset + uint32(j)) % uint32(n))
+		if s := score(i); best < 0 || s < bestScore {
+			best, bestScore = i, s
+		}
+	}
+	return best
+}
+
+// --------------------------------------------------------------------
+
+type roundRobin struct {
+	*Stats
+	index          uint32
+	maxFindPercent int
+}
+
+// NewRoundRobin 返回轮询的 Selector。每次最多检查 len(hosts)*maxFindPercent/100+1 个节点，
+// 都不可用时返回最后检查的节点
+//
+func NewRoundRobin(maxFindPercent int) Selector {
+
+	return &roundRobin{Stats: newStats(), maxFindPercent: maxFindPercent}
+}
+
+func (p *roundRobin) Select(hosts []string, usable func(string) bool) string {
+
+	if len(hosts) == 1 {
+		return hosts[0]
+	}
+	var host string
+	for i := 0; i <= len(hosts)*p.maxFindPercent/100; i++ {
+		index := int(atomic.AddUint32(&p.index, 1) - 1)
+		host = hosts[index%len(hosts)]
+		if usable == nil || usable(host) {
+			break
+		}
+	}
+	return host
+}
+
+// --------------------------------------------------------------------
+
+type ewma struct {
+	*Stats
+	index uint32
+}
+
+// NewEWMA 返回选择耗时 EWMA 最小的节点的 Selector，没有样本的节点优先被选中
+//
+func NewEWMA() Selector {
+
+	return &ewma{Stats: newStats()}
+}
+
+func (p *ewma) Select(hosts []string, usable func(string) bool) string {
+
+	hosts = candidates(hosts, usable)
+	if len(hosts) == 1 {
+		return hosts[0]
+	}
+	latency, _ := p.snapshot(hosts)
+	offset := atomic.AddUint32(&p.index, 1) - 1
+	return hosts[pickMin(len(hosts), offset, func(i int) float64 { return latency[i] })]
+}
+
+// --------------------------------------------------------------------
+
+type leastInFlight struct {
+	*Stats
+	index uint32
+}
+
+// NewLeastInFlight 返回选择正在进行的请求数最少的节点的 Selector
+//
+func NewLeastInFlight() Selector {
+
+	return &leastInFlight{Stats: newStats()}
+}
+
+func (p *leastInFlight) Select(hosts []string, usable func(string) bool) string {
+
+	hosts = candidates(hosts, usable)
+	if len(hosts) == 1 {
+		return hosts[0]
+	}
+	_, inflight := p.snapshot(hosts)
+	offset := atomic.AddUint32(&p.index, 1) - 1
+	return hosts[pickMin(len(hosts), offset, func(i int) float64 { return float64(inflight[i]) })]
+}
+
+// --------------------------------------------------------------------
+
+const minLatency = 0.001 // 秒
+
+type powerOfTwo struct {
+	*Stats
+	mu     sync.Mutex
+	random *rand.Rand
+}
+
+// NewPowerOfTwo 返回随机选择两个节点，再从中选择 EWMA*(并发数+1) 较小者的 Selector
+//
+func NewPowerOfTwo() Selector {
+
+	return &powerOfTwo{
+		Stats:  newStats(),
+		random: rand.New(rand.NewSource(time.Now().UnixNano() | int64(os.Getpid()))),
+	}
+}
+
+func (p *powerOfTwo) Select(hosts []string, usable func(string) bool) string {
+
+	hosts = candidates(hosts, usable)
+	if len(hosts) == 1 {
+		return hosts[0]
+	}
+	p.mu.Lock()
+	a := p.random.Intn(len(hosts))
+	b := p.random.Intn(len(hosts) - 1)
+	p.mu.Unlock()
+	if b >= a {
+		b++
+	}
+	pair := []string{hosts[a], hosts[b]}
+	latency, inflight := p.snapshot(pair)
+	score := func(i int) float64 {
+		l := latency[i]
+		if l < minLatency {
+			l = minLatency // 没有样本的节点按并发数比较
+		}
+		return l * float64(inflight[i]+1)
+	}
+	if score(1) < score(0) {
+		return pair[1]
+	}
+	return pair[0]
+}
+
+// --------------------------------------------------------------------
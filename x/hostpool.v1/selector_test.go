@@ -0,0 +1,127 @@
+package hostpool
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/x/rpc.v7"
+)
+
+// ---------------------------------------------------
+
+var testHosts = []string{"http://a", "http://b", "http://c", "http://d"}
+
+func TestNew(t *testing.T) {
+
+	for _, name := range []string{"", RoundRobin, EWMA, LeastInFlight, PowerOfTwo} {
+		if sel, err := New(name); err != nil || sel == nil {
+			t.Fatal("New failed:", name, err)
+		}
+	}
+	if _, err := New("random"); err != ErrUnknownStrategy {
+		t.Fatal("New should fail for unknown strategy:", err)
+	}
+}
+
+func TestHostFailed(t *testing.T) {
+
+	if HostFailed(nil) {
+		t.Fatal("nil error is not a host failure")
+	}
+	if !HostFailed(errors.New("connection refused")) {
+		t.Fatal("network error is a host failure")
+	}
+	if !HostFailed(&rpc.ErrorInfo{Code: 503}) || !HostFailed(&rpc.ErrorInfo{Code: 429}) {
+		t.Fatal("503 and 429 are host failures")
+	}
+	if HostFailed(&rpc.ErrorInfo{Code: 612}) || HostFailed(&rpc.ErrorInfo{Code: 401}) {
+		t.Fatal("612 and 401 are not host failures")
+	}
+}
+
+func TestRoundRobin(t *testing.T) {
+
+	sel := NewRoundRobin(50)
+	for i := 0; i < 8; i++ {
+		if host := sel.Select(testHosts, nil); host != testHosts[i%4] {
+			t.Fatal("round robin order invalid:", i, host)
+		}
+	}
+	usable := func(host string) bool { return host != "http://a" }
+	for i := 0; i < 8; i++ {
+		if host := sel.Select(testHosts, usable); host == "http://a" {
+			t.Fatal("round robin should skip unusable host")
+		}
+	}
+}
+
+func TestEWMA(t *testing.T) {
+
+	sel := NewEWMA()
+	sel.Done("http://a", 100*time.Millisecond, false)
+	sel.Done("http://b", 10*time.Millisecond, false)
+	sel.Done("http://c", 50*time.Millisecond, false)
+	if host := sel.Select(testHosts, nil); host != "http://d" {
+		t.Fatal("host without samples should be tried first:", host)
+	}
+	sel.Done("http://d", 20*time.Millisecond, true)
+	for i := 0; i < 4; i++ {
+		if host := sel.Select(testHosts, nil); host != "http://b" {
+			t.Fatal("fastest host should be selected:", host)
+		}
+	}
+	if host := sel.Select(testHosts, func(host string) bool { return host != "http://b" }); host != "http://c" {
+		t.Fatal("fastest usable host should be selected:", host)
+	}
+
+	stats := sel.(*ewma).Stats
+	if latency, ok := stats.Latency("http://d"); !ok || latency != DefaultFailurePenalty {
+		t.Fatal("failure should be penalized:", latency, ok)
+	}
+	stats.Done("http://b", 110*time.Millisecond, false)
+	if latency, _ := stats.Latency("http://b"); latency != 40*time.Millisecond {
+		t.Fatal("ewma invalid:", latency)
+	}
+}
+
+func TestLeastInFlight(t *testing.T) {
+
+	sel := NewLeastInFlight()
+	for _, host := range []string{"http://a", "http://a", "http://b", "http://c"} {
+		sel.Start(host)
+	}
+	if host := sel.Select(testHosts, nil); host != "http://d" {
+		t.Fatal("idle host should be selected:", host)
+	}
+	sel.Start("http://d")
+	sel.Done("http://b", time.Millisecond, false)
+	if host := sel.Select(testHosts, nil); host != "http://b" {
+		t.Fatal("least in-flight host should be selected:", host)
+	}
+	if n := sel.(*leastInFlight).InFlight("http://a"); n != 2 {
+		t.Fatal("in-flight count invalid:", n)
+	}
+}
+
+func TestPowerOfTwo(t *testing.T) {
+
+	sel := NewPowerOfTwo()
+	sel.Done("http://a", time.Second, false)
+	sel.Done("http://b", time.Second, false)
+	sel.Done("http://c", time.Second, false)
+	sel.Done("http://d", time.Millisecond, false)
+	counts := make(map[string]int)
+	for i := 0; i < 600; i++ {
+		counts[sel.Select(testHosts, nil)]++
+	}
+	// d 参与的两两比较中总是胜出，被选中的概率为 1/2
+	if counts["http://d"] < 200 || counts["http://d"] > 400 {
+		t.Fatal("p2c distribution invalid:", counts)
+	}
+	if host := sel.Select(testHosts[:1], nil); host != "http://a" {
+		t.Fatal("single host should be selected:", host)
+	}
+}
+
+// ---------------------------------------------------
@@ -0,0 +1,75 @@
+/*
+包 metrics 提供 SDK 的监控数据上报接口，以及一个不依赖网络、输出 Prometheus 文本格式的实现
+
+	reg := metrics.NewRegistry()
+	metrics.SetDefault(reg)
+	http.Handle("/metrics", reg)
+
+默认的 Collector 不做任何事情。
+*/
+package metrics
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// 传给 AddBytes 的数据方向
+const (
+	Up   = "up"
+	Down = "down"
+)
+
+// Collector 接收 SDK 上报的监控数据，实现必须可以被多个 goroutine 同时调用
+type Collector interface {
+	// ObserveRequest 记录一次请求，code 是 HTTP 状态码，网络错误为 599
+	ObserveRequest(op, host string, code int, elapsed time.Duration)
+	// AddBytes 记录上传（Up）或者下载（Down）的字节数
+	AddBytes(direction string, n int64)
+	// ObservePartUpload 记录一次分片上传的耗时
+	ObservePartUpload(host string, elapsed time.Duration)
+	// IncRetry 记录一次重试
+	IncRetry(op string)
+	// SetHostHealthy 在节点被标记为不可用（healthy 为 false）或者恢复时调用
+	SetHostHealthy(host string, healthy bool)
+}
+
+type nopCollector struct{}
+
+func (nopCollector) ObserveRequest(op, host string, code int, elapsed time.Duration) {}
+func (nopCollector) AddBytes(direction string, n int64)                              {}
+func (nopCollector) ObservePartUpload(host string, elapsed time.Duration)            {}
+func (nopCollector) IncRetry(op string)                                              {}
+func (nopCollector) SetHostHealthy(host string, healthy bool)                        {}
+
+// Nop 丢弃所有数据
+var Nop Collector = nopCollector{}
+
+type holder struct {
+	c Collector
+}
+
+var std atomic.Value
+
+func init() {
+	std.Store(holder{Nop})
+}
+
+// SetDefault 设置 SDK 使用的 Collector，传入 nil 恢复为 Nop
+//
+func SetDefault(c Collector) {
+
+	if c == nil {
+		c = Nop
+	}
+	std.Store(holder{c})
+}
+
+// Default 返回 SDK 使用的 Collector
+//
+func Default() Collector {
+
+	return std.Load().(holder).c
+}
+
+// --------------------------------------------------------------------
@@ -0,0 +1,230 @@
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBuckets 是耗时直方图默认的分桶，单位秒
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+const (
+	counterType   = "counter"
+	gaugeType     = "gauge"
+	histogramType = "histogram"
+)
+
+// Registry 是在内存中汇总数据的 Collector，通过 WriteTo 或者 ServeHTTP 以 Prometheus 文本格式输出
+type Registry struct {
+	mu       sync.Mutex
+	buckets  []float64
+	families map[string]*family
+}
+
+type family struct {
+	help   string
+	typ    string
+	series map[string]*series // key 为渲染后的标签
+}
+
+type series struct {
+	value   float64 // counter、gauge 的值，histogram 的 sum
+	count   uint64
+	buckets []uint64
+}
+
+// NewRegistry 创建一个 Registry，buckets 为空时使用 DefaultBuckets
+//
+func NewRegistry(buckets ...float64) *Registry {
+
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	return &Registry{buckets: buckets, families: make(map[string]*family)}
+}
+
+func (r *Registry) ObserveRequest(op, host string, code int, elapsed time.Duration) {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.series("us3_requests_total", "Requests by operation, host and status code.", counterType,
+		"op", op, "host", host, "code", strconv.Itoa(code)).value++
+	r.observe(r.series("us3_request_duration_seconds", "Request latency by operation and host.", histogramType,
+		"op", op, "host", host), elapsed)
+}
+
+func (r *Registry) AddBytes(direction string, n int64) {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.series("us3_bytes_total", "Bytes transferred by direction.", counterType, "direction", direction).value += float64(n)
+}
+
+func (r *Registry) ObservePartUpload(host string, elapsed time.Duration) {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.observe(r.series("us3_part_upload_duration_seconds", "Part upload latency by host.", histogramType, "host", host), elapsed)
+}
+
+func (r *Registry) IncRetry(op string) {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.series("us3_retries_total", "Retries by operation.", counterType, "op", op).value++
+}
+
+func (r *Registry) SetHostHealthy(host string, healthy bool) {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	state, value := "bad", 0.0
+	if healthy {
+		state, value = "recovered", 1
+	}
+	r.series("us3_host_healthy", "Whether the host is currently usable (1) or marked bad (0).", gaugeType, "host", host).value = value
+	r.series("us3_host_state_changes_total", "Times a host was marked bad or recovered.", counterType,
+		"host", host, "state", state).value++
+}
+
+// WriteTo 以 Prometheus 文本格式（version 0.0.4）输出所有数据
+//
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cw := &countWriter{w: bufio.NewWriter(w)}
+	names := make([]string, 0, len(r.families))
+	for name := range r.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		f := r.families[name]
+		cw.WriteString("# HELP " + name + " " + f.help + "\n")
+		cw.WriteString("# TYPE " + name + " " + f.typ + "\n")
+		keys := make([]string, 0, len(f.series))
+		for key := range f.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			s := f.series[key]
+			if f.typ != histogramType {
+				cw.WriteString(name + braces(key) + " " + formatFloat(s.value) + "\n")
+				continue
+			}
+			for i, le := range r.buckets {
+				cw.WriteString(name + "_bucket" + braces(joinLabels(key, `le="`+formatFloat(le)+`"`)) + " " +
+					strconv.FormatUint(s.buckets[i], 10) + "\n")
+			}
+			cw.WriteString(name + "_bucket" + braces(joinLabels(key, `le="+Inf"`)) + " " + strconv.FormatUint(s.count, 10) + "\n")
+			cw.WriteString(name + "_sum" + braces(key) + " " + formatFloat(s.value) + "\n")
+			cw.WriteString(name + "_count" + braces(key) + " " + strconv.FormatUint(s.count, 10) + "\n")
+		}
+	}
+	if cw.err == nil {
+		cw.err = cw.w.Flush()
+	}
+	return cw.n, cw.err
+}
+
+// ServeHTTP 实现 /metrics 接口
+//
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.WriteTo(w)
+}
+
+// --------------------------------------------------------------------
+
+func (r *Registry) series(name, help, typ string, labels ...string) *series {
+
+	f, ok := r.families[name]
+	if !ok {
+		f = &family{help: help, typ: typ, series: make(map[string]*series)}
+		r.families[name] = f
+	}
+	key := renderLabels(labels)
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{}
+		if typ == histogramType {
+			s.buckets = make([]uint64, len(r.buckets))
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+func (r *Registry) observe(s *series, elapsed time.Duration) {
+
+	v := elapsed.Seconds()
+	s.value += v
+	s.count++
+	for i, le := range r.buckets {
+		if v <= le {
+			s.buckets[i]++
+		}
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func renderLabels(labels []string) string {
+
+	parts := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		parts = append(parts, labels[i]+`="`+labelEscaper.Replace(labels[i+1])+`"`)
+	}
+	return strings.Join(parts, ",")
+}
+
+func joinLabels(key, label string) string {
+
+	if key == "" {
+		return label
+	}
+	return key + "," + label
+}
+
+func braces(key string) string {
+
+	if key == "" {
+		return ""
+	}
+	return "{" + key + "}"
+}
+
+func formatFloat(v float64) string {
+
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+type countWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (cw *countWriter) WriteString(s string) {
+
+	if cw.err != nil {
+		return
+	}
+	n, err := cw.w.WriteString(s)
+	cw.n += int64(n)
+	cw.err = err
+}
+
+// --------------------------------------------------------------------
@@ -0,0 +1,117 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// ---------------------------------------------------
+
+func TestRegistry(t *testing.T) {
+
+	r := NewRegistry(0.1, 1)
+	r.ObserveRequest("stat", "http://rs", 200, 50*time.Millisecond)
+	r.ObserveRequest("stat", "http://rs", 200, 500*time.Millisecond)
+	r.ObserveRequest("stat", "http://rs", 599, 2*time.Second)
+	r.AddBytes(Up, 100)
+	r.AddBytes(Up, 28)
+	r.IncRetry("stat")
+	r.SetHostHealthy("http://rs", false)
+	r.SetHostHealthy("http://rs", true)
+
+	var buf bytes.Buffer
+	n, err := r.WriteTo(&buf)
+	if err != nil || n != int64(buf.Len()) {
+		t.Fatal("WriteTo failed:", n, err)
+	}
+	out := buf.String()
+	for _, line := range []string{
+		"# TYPE us3_requests_total counter",
+		`us3_requests_total{op="stat",host="http://rs",code="200"} 2`,
+		`us3_requests_total{op="stat",host="http://rs",code="599"} 1`,
+		"# TYPE us3_request_duration_seconds histogram",
+		`us3_request_duration_seconds_bucket{op="stat",host="http://rs",le="0.1"} 1`,
+		`us3_request_duration_seconds_bucket{op="stat",host="http://rs",le="1"} 2`,
+		`us3_request_duration_seconds_bucket{op="stat",host="http://rs",le="+Inf"} 3`,
+		`us3_request_duration_seconds_sum{op="stat",host="http://rs"} 2.55`,
+		`us3_request_duration_seconds_count{op="stat",host="http://rs"} 3`,
+		`us3_bytes_total{direction="up"} 128`,
+		`us3_retries_total{op="stat"} 1`,
+		`us3_host_healthy{host="http://rs"} 1`,
+		`us3_host_state_changes_total{host="http://rs",state="bad"} 1`,
+		`us3_host_state_changes_total{host="http://rs",state="recovered"} 1`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Fatal("missing line:", line, "\n", out)
+		}
+	}
+}
+
+func TestLabelEscape(t *testing.T) {
+
+	r := NewRegistry()
+	r.IncRetry("a\"b\\c\nd")
+	var buf bytes.Buffer
+	r.WriteTo(&buf)
+	if !strings.Contains(buf.String(), `us3_retries_total{op="a\"b\\c\nd"} 1`) {
+		t.Fatal("label not escaped:", buf.String())
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+
+	r := NewRegistry()
+	r.AddBytes(Down, 1)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Fatal("content type invalid:", ct)
+	}
+	if !strings.Contains(w.Body.String(), `us3_bytes_total{direction="down"} 1`) {
+		t.Fatal("body invalid:", w.Body.String())
+	}
+}
+
+func TestDefault(t *testing.T) {
+
+	if Default() != Nop {
+		t.Fatal("default collector should be Nop")
+	}
+	r := NewRegistry()
+	SetDefault(r)
+	if Default() != r {
+		t.Fatal("SetDefault failed")
+	}
+	SetDefault(nil)
+	if Default() != Nop {
+		t.Fatal("SetDefault(nil) should restore Nop")
+	}
+}
+
+func TestConcurrent(t *testing.T) {
+
+	r := NewRegistry()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				r.ObserveRequest("list", "h", 200, time.Millisecond)
+				r.WriteTo(&bytes.Buffer{})
+			}
+		}()
+	}
+	wg.Wait()
+	var buf bytes.Buffer
+	r.WriteTo(&buf)
+	if !strings.Contains(buf.String(), `us3_requests_total{op="list",host="h",code="200"} 800`) {
+		t.Fatal("concurrent count invalid:", buf.String())
+	}
+}
+
+// ---------------------------------------------------
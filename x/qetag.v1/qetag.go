@@ -0,0 +1,191 @@
+/*
+包 qetag 提供在本地计算七牛/US3 文件 hash（etag）的能力
+
+对于普通上传或者按 4MB 分片上传的文件：
+
+	数据按 4MB 分块，每块计算 SHA1；
+	只有一块时 etag = urlsafe_base64(0x16 + SHA1(块))；
+	多于一块时 etag = urlsafe_base64(0x96 + SHA1(SHA1(块1) + SHA1(块2) + ...))。
+
+对于使用自定义分片大小分片上传（UploadWithParts）的文件，每个分片先按上面的规则计算，
+再把各分片的 SHA1 部分拼接后计算 SHA1，etag = urlsafe_base64(0x9e + SHA1(...))。
+*/
+package qetag
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"io"
+	"os"
+	"runtime"
+	"sync"
+)
+
+const BlockSize = 1 << 22
+
+const (
+	smallPrefix = 0x16
+	largePrefix = 0x96
+	partsPrefix = 0x9e
+)
+
+var ErrInvalidParts = errors.New("qetag: sum of parts not equal with size")
+
+// --------------------------------------------------------------------
+
+// 顺序读取 r 计算 etag。
+//
+func Etag(r io.Reader) (string, error) {
+
+	var sums [][sha1.Size]byte
+	buf := make([]byte, BlockSize)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 || len(sums) == 0 {
+			sums = append(sums, sha1.Sum(buf[:n]))
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		} else if err != nil {
+			return "", err
+		}
+	}
+	return encode(sumBlocks(sums)), nil
+}
+
+// 并发计算 r 中 [0, size) 数据的 etag，concurrency <= 0 时使用 CPU 个数。
+//
+func EtagReaderAt(r io.ReaderAt, size int64, concurrency int) (string, error) {
+
+	sums, err := blockSums(r, 0, size, concurrency)
+	if err != nil {
+		return "", err
+	}
+	return encode(sumBlocks(sums)), nil
+}
+
+// 并发计算本地文件的 etag。
+//
+func EtagFile(path string) (string, error) {
+
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	return EtagReaderAt(f, fi.Size(), 0)
+}
+
+// 计算按 parts 指定的分片大小分片上传后的 etag，parts 之和必须等于 size。
+// 如果除最后一片外每片都是 4MB，结果和 EtagReaderAt 相同。
+//
+func EtagWithParts(r io.ReaderAt, size int64, parts []int64, concurrency int) (string, error) {
+
+	var total int64
+	for _, part := range parts {
+		total += part
+	}
+	if total != size || len(parts) == 0 {
+		return "", ErrInvalidParts
+	}
+	if isBlockSizeParts(parts) {
+		return EtagReaderAt(r, size, concurrency)
+	}
+
+	h := sha1.New()
+	var offset int64
+	for _, part := range parts {
+		sums, err := blockSums(r, offset, part, concurrency)
+		if err != nil {
+			return "", err
+		}
+		h.Write(sumBlocks(sums)[1:])
+		offset += part
+	}
+	return encode(append([]byte{partsPrefix}, h.Sum(nil)...)), nil
+}
+
+// --------------------------------------------------------------------
+
+func isBlockSizeParts(parts []int64) bool {
+
+	last := len(parts) - 1
+	for i, part := range parts {
+		if i < last && part != BlockSize || i == last && part > BlockSize {
+			return false
+		}
+	}
+	return true
+}
+
+func blockSums(r io.ReaderAt, offset, size int64, concurrency int) ([][sha1.Size]byte, error) {
+
+	if concurrency <= 0 {
+		concurrency = runtime.NumCPU()
+	}
+	blockCnt := int((size + BlockSize - 1) / BlockSize)
+	if blockCnt == 0 {
+		return [][sha1.Size]byte{sha1.Sum(nil)}, nil
+	}
+
+	sums := make([][sha1.Size]byte, blockCnt)
+	tasks := make(chan int)
+	var wg sync.WaitGroup
+	var errOnce sync.Once
+	var firstErr error
+	for i := 0; i < concurrency && i < blockCnt; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h := sha1.New()
+			for blkIdx := range tasks {
+				start := int64(blkIdx) * BlockSize
+				blkSize := int64(BlockSize)
+				if blkSize > size-start {
+					blkSize = size - start
+				}
+				h.Reset()
+				n, err := io.Copy(h, io.NewSectionReader(r, offset+start, blkSize))
+				if err == nil && n != blkSize {
+					err = io.ErrUnexpectedEOF
+				}
+				if err != nil {
+					errOnce.Do(func() { firstErr = err })
+					continue
+				}
+				copy(sums[blkIdx][:], h.Sum(nil))
+			}
+		}()
+	}
+	for i := 0; i < blockCnt; i++ {
+		tasks <- i
+	}
+	close(tasks)
+	wg.Wait()
+	return sums, firstErr
+}
+
+func sumBlocks(sums [][sha1.Size]byte) []byte {
+
+	if len(sums) == 1 {
+		return append([]byte{smallPrefix}, sums[0][:]...)
+	}
+	h := sha1.New()
+	for _, sum := range sums {
+		h.Write(sum[:])
+	}
+	return append([]byte{largePrefix}, h.Sum(nil)...)
+}
+
+func encode(b []byte) string {
+
+	return base64.URLEncoding.EncodeToString(b)
+}
+
+// --------------------------------------------------------------------
@@ -0,0 +1,125 @@
+package qetag
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// ---------------------------------------------------
+
+func randData(n int) []byte {
+
+	b := make([]byte, n)
+	rand.New(rand.NewSource(int64(n))).Read(b)
+	return b
+}
+
+func TestEtag(t *testing.T) {
+
+	etag, err := Etag(bytes.NewReader(nil))
+	if err != nil || etag != "Fto5o-5ea0sNMlW_75VgGJCv2AcJ" {
+		t.Fatal("Etag of empty data failed:", etag, err)
+	}
+
+	etag, err = Etag(bytes.NewReader([]byte("hello")))
+	sum := sha1.Sum([]byte("hello"))
+	expected := base64.URLEncoding.EncodeToString(append([]byte{0x16}, sum[:]...))
+	if err != nil || etag != expected {
+		t.Fatal("Etag of small data failed:", etag, expected, err)
+	}
+
+	data := randData(BlockSize*2 + 100)
+	h := sha1.New()
+	for _, blk := range [][]byte{data[:BlockSize], data[BlockSize : 2*BlockSize], data[2*BlockSize:]} {
+		sum := sha1.Sum(blk)
+		h.Write(sum[:])
+	}
+	expected = base64.URLEncoding.EncodeToString(append([]byte{0x96}, h.Sum(nil)...))
+	etag, err = Etag(bytes.NewReader(data))
+	if err != nil || etag != expected {
+		t.Fatal("Etag of large data failed:", etag, expected, err)
+	}
+}
+
+func TestEtagReaderAt(t *testing.T) {
+
+	for _, n := range []int{0, 1, BlockSize - 1, BlockSize, BlockSize + 1, BlockSize*5 + 3} {
+		data := randData(n)
+		expected, err := Etag(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal("Etag failed:", err)
+		}
+		for _, concurrency := range []int{0, 1, 3} {
+			etag, err := EtagReaderAt(bytes.NewReader(data), int64(n), concurrency)
+			if err != nil || etag != expected {
+				t.Fatal("EtagReaderAt failed:", n, concurrency, etag, expected, err)
+			}
+		}
+	}
+
+	data := randData(100)
+	_, err := EtagReaderAt(bytes.NewReader(data), 200, 1)
+	if err == nil {
+		t.Fatal("EtagReaderAt should fail on short data")
+	}
+}
+
+func TestEtagFile(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "qetag")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	data := randData(BlockSize + 10)
+	path := filepath.Join(dir, "file")
+	if err = ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	expected, _ := Etag(bytes.NewReader(data))
+	etag, err := EtagFile(path)
+	if err != nil || etag != expected {
+		t.Fatal("EtagFile failed:", etag, expected, err)
+	}
+}
+
+func TestEtagWithParts(t *testing.T) {
+
+	data := randData(BlockSize*3 + 7)
+	size := int64(len(data))
+	v1, _ := Etag(bytes.NewReader(data))
+
+	etag, err := EtagWithParts(bytes.NewReader(data), size, []int64{BlockSize, BlockSize, BlockSize, 7}, 2)
+	if err != nil || etag != v1 {
+		t.Fatal("EtagWithParts of 4MB parts should equal v1:", etag, v1, err)
+	}
+
+	parts := []int64{BlockSize * 2, BlockSize + 7}
+	h := sha1.New()
+	var offset int64
+	for _, part := range parts {
+		partEtag, _ := Etag(bytes.NewReader(data[offset : offset+part]))
+		b, _ := base64.URLEncoding.DecodeString(partEtag)
+		h.Write(b[1:])
+		offset += part
+	}
+	expected := base64.URLEncoding.EncodeToString(append([]byte{0x9e}, h.Sum(nil)...))
+	etag, err = EtagWithParts(bytes.NewReader(data), size, parts, 0)
+	if err != nil || etag != expected {
+		t.Fatal("EtagWithParts failed:", etag, expected, err)
+	}
+
+	_, err = EtagWithParts(bytes.NewReader(data), size, []int64{BlockSize}, 0)
+	if err != ErrInvalidParts {
+		t.Fatal("EtagWithParts should fail on invalid parts:", err)
+	}
+}
+
+// ---------------------------------------------------
@@ -0,0 +1,221 @@
+/*
+包 retry 提供 SDK 中统一使用的重试策略
+
+	policy := &retry.Policy{MaxAttempts: 3, InitialBackoff: time.Second}
+	err := policy.Do(ctx, func(attempt int) error {
+		return doRequest()
+	})
+
+错误是否可以重试默认按 HTTP 状态码判断（见 IsRetryableCode）。设置了 MaxElapsed 时，限流错误（509、429）
+不计入尝试次数，只受 MaxElapsed 限制；没有设置 MaxElapsed 时限流错误和其他错误一样计入尝试次数。
+*/
+package retry
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/x/errors.v1"
+)
+
+// Policy 描述重试策略，零值表示不重试。Policy 在使用过程中不会被修改，可以被多个 goroutine 共享
+type Policy struct {
+	MaxAttempts       int           // 最多尝试次数，包括第一次，小于 1 时视为 1
+	InitialBackoff    time.Duration // 第一次重试前的等待时间，0 表示立即重试
+	MaxBackoff        time.Duration // 等待时间的上限，0 表示使用 DefaultMaxBackoff（不小于 InitialBackoff）
+	Multiplier        float64       // 每次重试后等待时间的增长倍数，小于 1 时视为 2
+	Jitter            float64       // 随机抖动比例，取值 [0, 1]，实际等待时间在 [backoff*(1-Jitter), backoff] 之间
+	MaxElapsed        time.Duration // 从第一次尝试开始的最长总时间，0 表示不限制
+	RespectRetryAfter bool          // 错误中带有 Retry-After 时使用服务端要求的等待时间
+
+	RetryableCodes []int            // 可以重试的状态码，为空时使用 IsRetryableCode
+	Retryable      func(error) bool // 自定义错误分类，优先于 RetryableCodes
+}
+
+// DefaultMaxBackoff 是没有设置 MaxBackoff 时等待时间的上限
+const DefaultMaxBackoff = time.Minute
+
+// 实现了 RetryAfter 的错误可以告诉 Policy 服务端要求的等待时间，例如 rpc.ErrorInfo
+type retryAfterer interface {
+	RetryAfter() time.Duration
+}
+
+// --------------------------------------------------------------------
+
+// Do 执行 fn 直到成功、遇到不可重试的错误、尝试次数用完或者超过 MaxElapsed，返回最后一次的错误。
+// attempt 从 0 开始计数，包括限流导致的重试。没有设置 MaxElapsed 时限流导致的重试也计入 MaxAttempts，
+// 避免服务端持续限流时无限重试。ctx 结束时立刻返回 ctx.Err()。
+//
+func (p *Policy) Do(ctx context.Context, fn func(attempt int) error) (err error) {
+
+	start := time.Now()
+	retries, throttles := 0, 0
+	for attempt := 0; ; attempt++ {
+		err = fn(attempt)
+		if err == nil || !p.ShouldRetry(err) {
+			return
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		var wait time.Duration
+		if IsThrottled(err) && p.MaxElapsed > 0 {
+			wait = p.backoff(throttles)
+			throttles++
+		} else {
+			if retries+1 >= p.maxAttempts() {
+				return
+			}
+			wait = p.backoff(retries)
+			retries++
+		}
+		var ra retryAfterer
+		if p.RespectRetryAfter && errors.As(err, &ra) && ra.RetryAfter() > 0 {
+			wait = ra.RetryAfter()
+		}
+		if p.MaxElapsed > 0 && time.Since(start)+wait > p.MaxElapsed {
+			return
+		}
+		if wait > 0 {
+			if e := sleep(ctx, wait); e != nil {
+				return e
+			}
+		}
+	}
+}
+
+// ShouldRetry 判断 err 是否可以重试，context 取消或者超时不会重试。
+//
+func (p *Policy) ShouldRetry(err error) bool {
+
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if p.Retryable != nil {
+		return p.Retryable(err)
+	}
+	code, _ := errors.HttpCodeOf(err)
+	return p.ShouldRetryCode(code)
+}
+
+// ShouldRetryCode 按状态码判断是否可以重试，用于批量操作中单个条目的结果。
+//
+func (p *Policy) ShouldRetryCode(code int) bool {
+
+	if len(p.RetryableCodes) == 0 {
+		return IsRetryableCode(code)
+	}
+	for _, c := range p.RetryableCodes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// Attempts 返回最多尝试次数，至少为 1。
+//
+func (p *Policy) Attempts() int {
+
+	return p.maxAttempts()
+}
+
+// Backoff 返回第 retry 次重试（从 0 开始）前的等待时间，包含随机抖动。
+//
+func (p *Policy) Backoff(retry int) time.Duration {
+
+	return p.backoff(retry)
+}
+
+// WithAttempts 返回一个 MaxAttempts 为 n 的副本。
+//
+func (p *Policy) WithAttempts(n int) *Policy {
+
+	q := *p
+	q.MaxAttempts = n
+	return &q
+}
+
+// --------------------------------------------------------------------
+
+// IsRetryableCode 是默认的错误分类：5xx（579 回调失败除外）、406 数据校验失败、408、429 和 509 可以重试，
+// 其他 4xx 以及 612 文件不存在、614 文件已存在等 6xx 错误不再重试。
+// 网络错误等没有状态码的错误被 x/errors.v1 视为 599，可以重试
+//
+func IsRetryableCode(code int) bool {
+
+	switch code {
+	case 406, 408, 429, 509:
+		return true
+	case 579:
+		return false
+	}
+	return code/100 == 5
+}
+
+// IsThrottled 判断 err 是否是限流错误，设置了 MaxElapsed 时限流错误的重试不计入尝试次数。
+//
+func IsThrottled(err error) bool {
+
+	code, _ := errors.HttpCodeOf(err)
+	return code == 509 || code == 429
+}
+
+// --------------------------------------------------------------------
+
+func (p *Policy) maxAttempts() int {
+
+	if p.MaxAttempts < 1 {
+		return 1
+	}
+	return p.MaxAttempts
+}
+
+func (p *Policy) backoff(retry int) time.Duration {
+
+	if p.InitialBackoff <= 0 {
+		return 0
+	}
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 2
+	}
+	max := p.MaxBackoff
+	if max <= 0 {
+		max = DefaultMaxBackoff
+		if max < p.InitialBackoff {
+			max = p.InitialBackoff
+		}
+	}
+	d := float64(p.InitialBackoff)
+	for i := 0; i < retry && d < float64(max); i++ {
+		d *= multiplier
+	}
+	if d > float64(max) {
+		d = float64(max)
+	}
+	if p.Jitter > 0 {
+		jitter := p.Jitter
+		if jitter > 1 {
+			jitter = 1
+		}
+		d -= d * jitter * rand.Float64()
+	}
+	return time.Duration(d)
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// --------------------------------------------------------------------
@@ -0,0 +1,176 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// ---------------------------------------------------
+
+type codeError struct {
+	code       int
+	retryAfter time.Duration
+}
+
+func (e *codeError) Error() string             { return "code error" }
+func (e *codeError) HttpCode() int             { return e.code }
+func (e *codeError) RetryAfter() time.Duration { return e.retryAfter }
+
+func TestDo(t *testing.T) {
+
+	p := &Policy{MaxAttempts: 3}
+	calls := 0
+	err := p.Do(context.Background(), func(attempt int) error {
+		if attempt != calls {
+			t.Fatal("attempt invalid:", attempt, calls)
+		}
+		calls++
+		return &codeError{code: 503}
+	})
+	if calls != 3 || err == nil {
+		t.Fatal("Do should try 3 times:", calls, err)
+	}
+
+	calls = 0
+	err = p.Do(context.Background(), func(attempt int) error {
+		calls++
+		if calls == 2 {
+			return nil
+		}
+		return errors.New("network error")
+	})
+	if calls != 2 || err != nil {
+		t.Fatal("Do should succeed on second attempt:", calls, err)
+	}
+
+	calls = 0
+	err = p.Do(context.Background(), func(attempt int) error {
+		calls++
+		return &codeError{code: 612}
+	})
+	if calls != 1 || err == nil {
+		t.Fatal("612 should not be retried:", calls, err)
+	}
+
+	calls = 0
+	err = (&Policy{}).Do(context.Background(), func(attempt int) error {
+		calls++
+		return &codeError{code: 503}
+	})
+	if calls != 1 || err == nil {
+		t.Fatal("zero Policy should not retry:", calls, err)
+	}
+}
+
+func TestDoThrottled(t *testing.T) {
+
+	p := &Policy{MaxAttempts: 2, MaxElapsed: time.Minute}
+	calls := 0
+	err := p.Do(context.Background(), func(attempt int) error {
+		calls++
+		if calls <= 3 {
+			return &codeError{code: 509}
+		}
+		return &codeError{code: 500}
+	})
+	if calls != 5 || err == nil {
+		t.Fatal("throttled errors should not count as attempts:", calls, err)
+	}
+
+	p = &Policy{MaxAttempts: 2}
+	calls = 0
+	err = p.Do(context.Background(), func(attempt int) error {
+		calls++
+		return &codeError{code: 429}
+	})
+	if calls != 2 || err == nil {
+		t.Fatal("throttled errors should count as attempts without MaxElapsed:", calls, err)
+	}
+}
+
+func TestDoContext(t *testing.T) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	p := &Policy{MaxAttempts: 10, InitialBackoff: time.Hour}
+	start := time.Now()
+	err := p.Do(ctx, func(attempt int) error {
+		return &codeError{code: 500}
+	})
+	if err != context.DeadlineExceeded || time.Since(start) > time.Second {
+		t.Fatal("Do should stop when ctx is done:", err, time.Since(start))
+	}
+}
+
+func TestDoMaxElapsedAndRetryAfter(t *testing.T) {
+
+	p := &Policy{MaxAttempts: 10, InitialBackoff: 20 * time.Millisecond, MaxElapsed: 50 * time.Millisecond}
+	calls := 0
+	p.Do(context.Background(), func(attempt int) error {
+		calls++
+		return &codeError{code: 500}
+	})
+	if calls < 2 || calls > 3 {
+		t.Fatal("MaxElapsed should stop retrying:", calls)
+	}
+
+	p = &Policy{MaxAttempts: 2, InitialBackoff: time.Hour, RespectRetryAfter: true}
+	start := time.Now()
+	p.Do(context.Background(), func(attempt int) error {
+		return &codeError{code: 503, retryAfter: 10 * time.Millisecond}
+	})
+	if time.Since(start) > time.Second {
+		t.Fatal("Retry-After should override backoff:", time.Since(start))
+	}
+}
+
+func TestBackoff(t *testing.T) {
+
+	p := &Policy{InitialBackoff: time.Second, MaxBackoff: 5 * time.Second}
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, d := range expected {
+		if b := p.Backoff(i); b != d {
+			t.Fatal("Backoff invalid:", i, b, d)
+		}
+	}
+
+	p = &Policy{InitialBackoff: time.Second}
+	for _, i := range []int{6, 100, 10000} {
+		if b := p.Backoff(i); b != DefaultMaxBackoff {
+			t.Fatal("Backoff should be capped by DefaultMaxBackoff:", i, b)
+		}
+	}
+	p = &Policy{InitialBackoff: 2 * time.Minute}
+	if b := p.Backoff(3); b != 2*time.Minute {
+		t.Fatal("default cap should not be less than InitialBackoff:", b)
+	}
+
+	p = &Policy{InitialBackoff: time.Second, Jitter: 0.5}
+	for i := 0; i < 100; i++ {
+		if b := p.Backoff(0); b < 500*time.Millisecond || b > time.Second {
+			t.Fatal("Backoff with jitter out of range:", b)
+		}
+	}
+}
+
+func TestShouldRetry(t *testing.T) {
+
+	p := &Policy{}
+	for code, expected := range map[int]bool{500: true, 599: true, 406: true, 509: true, 579: false, 400: false, 612: false, 614: false} {
+		if p.ShouldRetry(&codeError{code: code}) != expected {
+			t.Fatal("ShouldRetry invalid:", code)
+		}
+	}
+	if p.ShouldRetry(context.Canceled) {
+		t.Fatal("context.Canceled should not be retried")
+	}
+
+	p = &Policy{RetryableCodes: []int{612}}
+	if !p.ShouldRetryCode(612) || p.ShouldRetryCode(500) {
+		t.Fatal("RetryableCodes should override default classification")
+	}
+}
+
+// ---------------------------------------------------
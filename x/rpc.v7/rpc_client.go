@@ -9,6 +9,7 @@ import (
 	"log"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
@@ -136,6 +137,10 @@ func (r Client) Do(ctx Context, req *http.Request) (resp *http.Response, err err
 		req.Header.Set("User-Agent", UserAgent)
 	}
 
+	// CancelRequest can't cancel requests made through a wrapped transport or
+	// over HTTP/2, so bind ctx to the request as well
+	req = req.WithContext(ctx)
+
 	transport := r.Transport // don't change r.Transport
 	if transport == nil {
 		transport = http.DefaultTransport
@@ -181,6 +186,8 @@ type ErrorInfo struct {
 	Reqid string `json:"reqid,omitempty"`
 	Errno int    `json:"errno,omitempty"`
 	Code  int    `json:"code"`
+
+	retryAfter time.Duration
 }
 
 func (r *ErrorInfo) ErrorDetail() string {
@@ -204,6 +211,28 @@ func (r *ErrorInfo) HttpCode() int {
 	return r.Code
 }
 
+// RetryAfter returns the delay requested by the Retry-After response header, or 0.
+func (r *ErrorInfo) RetryAfter() time.Duration {
+
+	return r.retryAfter
+}
+
+func parseRetryAfter(v string) time.Duration {
+
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
 // --------------------------------------------------------------------
 
 func parseError(e *ErrorInfo, r io.Reader) {
@@ -230,8 +259,9 @@ func parseError(e *ErrorInfo, r io.Reader) {
 func ResponseError(resp *http.Response) (err error) {
 
 	e := &ErrorInfo{
-		Reqid: resp.Header.Get("X-Reqid"),
-		Code:  resp.StatusCode,
+		Reqid:      resp.Header.Get("X-Reqid"),
+		Code:       resp.StatusCode,
+		retryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
 	}
 	if resp.StatusCode > 299 {
 		if resp.ContentLength != 0 {
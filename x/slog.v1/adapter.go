@@ -0,0 +1,175 @@
+package slog
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ufilesdk-dev/us3-qiniu-go-sdk/x/xlog.v8"
+)
+
+// --------------------------------------------------------------------
+// JSON lines
+
+type jsonLogger struct {
+	mu  sync.Mutex
+	w   io.Writer
+	min Level
+}
+
+// NewJSON 返回一个每条日志输出一行 JSON 的 Logger，低于 min 的日志被丢弃。
+// 每行包含 time、level、msg 以及所有字段，error 和 time.Duration 输出为字符串
+//
+func NewJSON(w io.Writer, min Level) Logger {
+
+	return &jsonLogger{w: w, min: min}
+}
+
+func (l *jsonLogger) Log(level Level, msg string, fields ...Field) {
+
+	if level < l.min {
+		return
+	}
+	var b strings.Builder
+	b.WriteString(`{"time":`)
+	writeJSON(&b, time.Now().Format(time.RFC3339Nano))
+	b.WriteString(`,"level":`)
+	writeJSON(&b, level.String())
+	b.WriteString(`,"msg":`)
+	writeJSON(&b, msg)
+	for _, f := range fields {
+		b.WriteByte(',')
+		writeJSON(&b, f.Key)
+		b.WriteByte(':')
+		writeJSON(&b, jsonValue(f.Value))
+	}
+	b.WriteString("}\n")
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	io.WriteString(l.w, b.String())
+}
+
+func jsonValue(v interface{}) interface{} {
+
+	switch v := v.(type) {
+	case error:
+		return v.Error()
+	case time.Duration:
+		return v.String()
+	}
+	return v
+}
+
+func writeJSON(b *strings.Builder, v interface{}) {
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		data, _ = json.Marshal(fmt.Sprint(v))
+	}
+	b.Write(data)
+}
+
+// --------------------------------------------------------------------
+// x/xlog.v8
+
+type xlogLogger struct {
+	xl *xlog.Logger
+}
+
+// FromXlog 把日志输出到 xl，字段按 FormatText 的格式附加在消息后面，reqid 使用 xl 的 reqid
+//
+func FromXlog(xl *xlog.Logger) Logger {
+
+	return xlogLogger{xl}
+}
+
+func (l xlogLogger) Log(level Level, msg string, fields ...Field) {
+
+	s := FormatText(msg, fields)
+	switch {
+	case level <= LevelDebug:
+		l.xl.Debug(s)
+	case level == LevelInfo:
+		l.xl.Info(s)
+	case level == LevelWarn:
+		l.xl.Warn(s)
+	default:
+		l.xl.Error(s)
+	}
+}
+
+// --------------------------------------------------------------------
+// kodocli.Ilog
+
+// Leveled 是 kodocli.Ilog 风格的按级别输出的日志接口
+type Leveled interface {
+	Debug(v ...interface{})
+	Info(v ...interface{})
+	Warn(v ...interface{})
+	Error(v ...interface{})
+}
+
+type leveledLogger struct {
+	l Leveled
+}
+
+// FromLeveled 把日志输出到 kodocli.Ilog 等按级别输出的日志，字段按 FormatText 的格式附加在消息后面
+//
+func FromLeveled(l Leveled) Logger {
+
+	return leveledLogger{l}
+}
+
+func (l leveledLogger) Log(level Level, msg string, fields ...Field) {
+
+	s := FormatText(msg, fields)
+	switch {
+	case level <= LevelDebug:
+		l.l.Debug(s)
+	case level == LevelInfo:
+		l.l.Info(s)
+	case level == LevelWarn:
+		l.l.Warn(s)
+	default:
+		l.l.Error(s)
+	}
+}
+
+// LeveledLogger 把 Logger 包装成 kodocli.Ilog，参数按 fmt.Sprintln 拼接为消息。
+// Fatal 输出 error 级别的日志后调用 Exit
+type LeveledLogger struct {
+	Logger
+	Exit func(code int)
+}
+
+// ToLeveled 返回把日志输出到 l 的 kodocli.Ilog 实现，Fatal 时调用 os.Exit(1)
+//
+func ToLeveled(l Logger) *LeveledLogger {
+
+	return &LeveledLogger{Logger: l, Exit: os.Exit}
+}
+
+func (l *LeveledLogger) Debug(v ...interface{}) { l.Log(LevelDebug, sprint(v)) }
+func (l *LeveledLogger) Info(v ...interface{})  { l.Log(LevelInfo, sprint(v)) }
+func (l *LeveledLogger) Warn(v ...interface{})  { l.Log(LevelWarn, sprint(v)) }
+func (l *LeveledLogger) Error(v ...interface{}) { l.Log(LevelError, sprint(v)) }
+
+func (l *LeveledLogger) Fatal(v ...interface{}) {
+
+	l.Log(LevelError, sprint(v))
+	if l.Exit != nil {
+		l.Exit(1)
+	}
+}
+
+func sprint(v []interface{}) string {
+
+	return strings.TrimSuffix(fmt.Sprintln(v...), "\n")
+}
+
+// --------------------------------------------------------------------
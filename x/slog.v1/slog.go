@@ -0,0 +1,161 @@
+/*
+包 slog 定义 SDK 使用的结构化日志接口，以及 JSON lines、x/xlog.v8 和 kodocli.Ilog 风格日志的适配
+
+	logger := slog.NewJSON(os.Stderr, slog.LevelInfo)
+	logger.Log(slog.LevelInfo, "upload done", slog.Op("upload"), slog.Key(key), slog.Duration(time.Since(start)))
+
+库代码不应该直接写标准输出，需要输出日志时通过 Logger 输出，由使用者决定日志去向。
+*/
+package slog
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+
+	if l >= LevelDebug && int(l) < len(levelNames) {
+		return levelNames[l]
+	}
+	return "level(" + strconv.Itoa(int(l)) + ")"
+}
+
+// Field 是日志中的一个键值对
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// Logger 是结构化日志接口，实现必须可以被多个 goroutine 同时调用
+type Logger interface {
+	Log(level Level, msg string, fields ...Field)
+}
+
+// --------------------------------------------------------------------
+
+func F(key string, value interface{}) Field {
+
+	return Field{Key: key, Value: value}
+}
+
+func Op(op string) Field {
+
+	return Field{Key: "op", Value: op}
+}
+
+func Key(key string) Field {
+
+	return Field{Key: "key", Value: key}
+}
+
+func Host(host string) Field {
+
+	return Field{Key: "host", Value: host}
+}
+
+func Reqid(reqid string) Field {
+
+	return Field{Key: "reqid", Value: reqid}
+}
+
+func Duration(d time.Duration) Field {
+
+	return Field{Key: "duration", Value: d}
+}
+
+func Bytes(n int64) Field {
+
+	return Field{Key: "bytes", Value: n}
+}
+
+func Err(err error) Field {
+
+	return Field{Key: "error", Value: err}
+}
+
+// --------------------------------------------------------------------
+
+type discard struct{}
+
+func (discard) Log(level Level, msg string, fields ...Field) {}
+
+// Discard 丢弃所有日志
+var Discard Logger = discard{}
+
+// With 返回一个在每条日志中附加 fields 的 Logger
+//
+func With(l Logger, fields ...Field) Logger {
+
+	if len(fields) == 0 {
+		return l
+	}
+	return &withFields{l: l, fields: fields}
+}
+
+type withFields struct {
+	l      Logger
+	fields []Field
+}
+
+func (w *withFields) Log(level Level, msg string, fields ...Field) {
+
+	all := make([]Field, 0, len(w.fields)+len(fields))
+	all = append(all, w.fields...)
+	w.l.Log(level, msg, append(all, fields...)...)
+}
+
+// --------------------------------------------------------------------
+
+// FormatText 把消息和字段格式化为 "msg k1=v1 k2=v2" 的形式，供文本日志的适配器使用
+//
+func FormatText(msg string, fields []Field) string {
+
+	var b strings.Builder
+	b.WriteString(msg)
+	for _, f := range fields {
+		b.WriteByte(' ')
+		b.WriteString(f.Key)
+		b.WriteByte('=')
+		b.WriteString(quoteIfNeeded(valueString(f.Value)))
+	}
+	return b.String()
+}
+
+func valueString(v interface{}) string {
+
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	}
+	return fmt.Sprint(v)
+}
+
+func quoteIfNeeded(s string) string {
+
+	if s == "" || strings.ContainsAny(s, " =\"\t\r\n") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// --------------------------------------------------------------------
@@ -0,0 +1,77 @@
+package slog
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// ---------------------------------------------------
+
+func TestJSON(t *testing.T) {
+
+	var buf bytes.Buffer
+	l := NewJSON(&buf, LevelInfo)
+	l.Log(LevelDebug, "dropped")
+	l.Log(LevelInfo, "upload done", Op("upload"), Key("a b"), Host("http://up"), Reqid("r1"),
+		Duration(1500*time.Millisecond), Bytes(10), Err(errors.New("boom")))
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 1 {
+		t.Fatal("debug log should be dropped:", buf.String())
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &m); err != nil {
+		t.Fatal("invalid json line:", lines[0], err)
+	}
+	want := map[string]interface{}{
+		"level": "info", "msg": "upload done", "op": "upload", "key": "a b", "host": "http://up",
+		"reqid": "r1", "duration": "1.5s", "bytes": float64(10), "error": "boom",
+	}
+	for k, v := range want {
+		if m[k] != v {
+			t.Fatal("field invalid:", k, m[k], v)
+		}
+	}
+	if _, ok := m["time"]; !ok {
+		t.Fatal("time missing:", lines[0])
+	}
+}
+
+type recorder struct {
+	lines []string
+}
+
+func (r *recorder) Debug(v ...interface{}) { r.add("D", v) }
+func (r *recorder) Info(v ...interface{})  { r.add("I", v) }
+func (r *recorder) Warn(v ...interface{})  { r.add("W", v) }
+func (r *recorder) Error(v ...interface{}) { r.add("E", v) }
+
+func (r *recorder) add(level string, v []interface{}) {
+	r.lines = append(r.lines, level+" "+v[0].(string))
+}
+
+func TestLeveled(t *testing.T) {
+
+	r := &recorder{}
+	l := With(FromLeveled(r), Op("list"))
+	l.Log(LevelWarn, "retry", Host("http://rsf"), F("marker", ""), Err(errors.New("a=b")))
+	if len(r.lines) != 1 || r.lines[0] != `W retry op=list host=http://rsf marker="" error="a=b"` {
+		t.Fatal("FromLeveled invalid:", r.lines)
+	}
+
+	var buf bytes.Buffer
+	exited := 0
+	ll := ToLeveled(NewJSON(&buf, LevelDebug))
+	ll.Exit = func(code int) { exited = code }
+	ll.Info("small upload retry", 1, errors.New("x"))
+	ll.Fatal("bye")
+	if !strings.Contains(buf.String(), `"msg":"small upload retry 1 x"`) || !strings.Contains(buf.String(), `"level":"error","msg":"bye"`) || exited != 1 {
+		t.Fatal("ToLeveled invalid:", buf.String(), exited)
+	}
+}
+
+// ---------------------------------------------------